@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"os"
 
 	log "github.com/sirupsen/logrus"
 
 	. "rest_module/repository"
 	. "rest_module/rest"
 	. "rest_module/service"
+	. "rest_module/utils"
 )
 
 func main() {
@@ -15,16 +18,79 @@ func main() {
 	var dbManager = NewDBManager()
 	defer dbManager.CloseConnection()
 
+	// Миграции схемы: подкоманда migrate или применение при запуске, если MIGRATE_ON_START не равно false
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(dbManager, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if GetEnv("MIGRATE_ON_START", "true") == "true" {
+		if err := runMigrateCommand(dbManager, nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Создание объектов API пользователя
 	var integrationService = NewIntegrationService()
+	var roleRepository = InitRoleRepository(dbManager)
+	var sessionRepository = InitSessionRepository(dbManager)
+	var userTokenRepository = InitUserTokenRepository(dbManager)
+	var loginFailureRepository = InitLoginFailureRepository(dbManager)
+	var rateLimitRepository = InitRateLimitRepository(dbManager)
+	var passwordHistoryRepository = InitPasswordHistoryRepository(dbManager)
+	var apiKeyRepository = InitAPIKeyRepository(dbManager)
+	var oauthRepository = InitOAuthRepository(dbManager)
+	var signingKeyRepository = InitSigningKeyRepository(dbManager)
+	var identityRepository = InitIdentityRepository(dbManager)
+	var samlRepository = InitSAMLRepository(dbManager)
+	var invitationRepository = InitInvitationRepository(dbManager)
+	var webAuthnRepository = InitWebAuthnRepository(dbManager)
+	var auditRepository = InitAuditRepository(dbManager)
+	var impersonationRepository = InitImpersonationRepository(dbManager)
+	var secretBox = NewSecretBox()
+	var userTokenManager = UserTokenManagerNewInstance(userTokenRepository)
+	var notifier = NotifierNewInstance(NewMailer())
+	passwordHasher, err := PasswordHasherFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 	var userRepository = InitUserRepository(dbManager)
-	var userManager = UserManagerNewInstance(userRepository, integrationService)
+	var userManager = UserManagerNewInstance(userRepository, sessionRepository, userTokenManager, notifier,
+		PasswordPolicyFromEnv(passwordHistoryRepository, passwordHasher), passwordHistoryRepository, passwordHasher, integrationService)
+	var roleManager = RoleManagerNewInstance(roleRepository, userRepository)
+	roleManager.BootstrapAdmin(context.Background(), GetEnv("ADMIN_USERNAME", ""))
+	var sessionManager = SessionManagerNewInstance(sessionRepository)
+	var keyManager = KeyManagerNewInstance(signingKeyRepository, secretBox)
+	if err := keyManager.Start(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	var tokenService = NewTokenService(keyManager)
+	var mfaManager = MFAManagerNewInstance(userRepository, userRepository, secretBox)
+	var loginGuard = LoginGuardNewInstance(loginFailureRepository, rateLimitRepository)
+	var webAuthnManager = WebAuthnManagerNewInstance(webAuthnRepository, userRepository)
+	var apiKeyManager = APIKeyManagerNewInstance(apiKeyRepository, userRepository, roleManager)
+	var auditLog = AuditLogNewInstance(auditRepository)
+	var impersonationService = ImpersonationServiceNewInstance(impersonationRepository, userManager, roleManager, tokenService, auditLog)
+	authenticator, err := AuthenticatorFromEnv(userManager, identityRepository)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var authService = AuthServiceNewInstance(userManager, authenticator, roleManager, sessionManager, tokenService, userTokenManager, notifier, mfaManager, loginGuard, webAuthnManager,
+		impersonationService, oauthRepository)
+	var oauthServer = OAuthServerNewInstance(oauthRepository, authService, userManager, roleManager, sessionManager, tokenService)
+	var samlServiceProvider = SAMLServiceProviderNewInstance(samlRepository, identityRepository, userManager, authService, tokenService.Issuer())
+	if err := samlServiceProvider.LoadIdentityProvider(); err != nil {
+		log.Fatal(err)
+	}
+	var scimService = SCIMServiceNewInstance(userManager, roleManager, userRepository, tokenService.Issuer())
+	var invitationService = InvitationServiceNewInstance(invitationRepository, userManager, roleManager, userTokenManager, notifier)
 
 	// Главный контроллер приложения
-	api := ApiNewInstance(userManager, integrationService)
+	api := ApiNewInstance(userManager, integrationService, authService, roleManager, sessionManager, mfaManager, loginGuard, apiKeyManager, oauthServer, keyManager, samlServiceProvider, scimService, invitationService, webAuthnManager, impersonationService, auditLog)
 	// Запуск сетевой службы и HTTP-сервера
 	// на всех локальных IP-адресах на порту 8080.
-	err := http.ListenAndServe(":8080", api.Router())
+	err = http.ListenAndServe(":8080", api.Router())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	. "rest_module/repository"
+)
+
+// Подкоманда migrate: up (по умолчанию), down [число миграций] или status
+func runMigrateCommand(db *DBManager, args []string) error {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		return migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("Некорректное число миграций для отката %s", args[1])
+			}
+		}
+		return migrator.Down(steps)
+	case "status":
+		statuses, err := migrator.Status()
+		for _, status := range statuses {
+			applied := "не применена"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return err
+	default:
+		return fmt.Errorf("Неизвестная команда migrate %s, ожидается up, down или status", command)
+	}
+}
@@ -1,9 +1,152 @@
 package domain_model
 
+import "time"
+
 // Пользователь
 type User struct {
-	ID       int64  `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"-"`
-	Email    string `json:"email"`
+	ID            int64     `json:"id"`
+	Username      string    `json:"username"`
+	Password      string    `json:"-"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	Active        bool      `json:"active"`
+	ExternalID    string    `json:"external_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Roles         []string  `json:"roles"`
+}
+
+// Настройки TOTP пользователя
+type TOTPSettings struct {
+	Secret        string   // зашифрованный секрет
+	Enabled       bool     // подключение подтверждено кодом
+	LastStep      int64    // последний принятый шаг, защита от повтора кода
+	RecoveryCodes []string // хеши неиспользованных кодов восстановления
+}
+
+// Роль с набором разрешений
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// Сессия входа пользователя (устройство)
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	ClientID   string    `json:"client_id,omitempty"`
+	Scopes     []string  `json:"scopes,omitempty"`
+}
+
+// Состояние блокировки входа
+type LockStatus struct {
+	Failures    int        `json:"failures"`
+	Locked      bool       `json:"locked"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// Ключ API пользователя (UserID) или сервисной учетной записи (ServiceName)
+type APIKey struct {
+	ID          int64      `json:"id"`
+	UserID      *int64     `json:"user_id,omitempty"`
+	ServiceName string     `json:"service_name,omitempty"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Scopes      []string   `json:"scopes"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
+
+// Клиент OAuth2
+type OAuthClient struct {
+	ID           int64     `json:"-"`
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Выданный код авторизации OAuth2
+type OAuthCode struct {
+	ClientID      string
+	UserID        int64
+	RedirectURI   string // адрес возврата из запроса авторизации; пустой, если клиент его не передал
+	Scopes        []string
+	CodeChallenge string
+	Nonce         string
+	ExpiresAt     time.Time
+}
+
+// Ключ подписи токенов
+type SigningKey struct {
+	ID         int64
+	KID        string
+	Algorithm  string
+	PrivateKey string // зашифрованный PKCS#8
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+}
+
+// Приглашение пользователя; пользователь создается отключенным и включается при принятии
+type Invitation struct {
+	ID         int64      `json:"id"`
+	UserID     *int64     `json:"user_id,omitempty"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email"`
+	Roles      []string   `json:"roles"`
+	InvitedBy  *int64     `json:"invited_by,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Ключ доступа WebAuthn (passkey) пользователя
+type WebAuthnCredential struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"user_id"`
+	CredentialID string     `json:"credential_id"` // идентификатор в base64url
+	Name         string     `json:"name"`
+	PublicKey    []byte     `json:"-"` // открытый ключ в формате COSE
+	SignCount    uint32     `json:"sign_count"`
+	Transports   []string   `json:"transports"`
+	AAGUID       string     `json:"aaguid"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Запись журнала аудита
+type AuditEvent struct {
+	ID        int64          `json:"id"`
+	ActorID   *int64         `json:"actor_id,omitempty"` // кто выполнил действие
+	UserID    *int64         `json:"user_id,omitempty"`  // над чьей учетной записью
+	Action    string         `json:"action"`
+	Details   map[string]any `json:"details"`
+	IP        string         `json:"ip,omitempty"`
+	UserAgent string         `json:"user_agent,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// Вход администратора от имени пользователя
+type Impersonation struct {
+	ID        int64      `json:"id"`
+	ActorID   *int64     `json:"actor_id,omitempty"`
+	UserID    int64      `json:"user_id"`
+	Reason    string     `json:"reason"`
+	StartedAt time.Time  `json:"started_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
 }
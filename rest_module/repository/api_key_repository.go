@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	. "rest_module/model"
+
+	"github.com/lib/pq"
+)
+
+const apiKeyColumns = `"id", "user_id", coalesce("service_name", ''), "name", "prefix", "scopes", "created_at", "last_used_at", "expires_at"`
+
+type APIKeyRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitAPIKeyRepository(db *DBManager) *APIKeyRepository {
+	repo := APIKeyRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *APIKeyRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение нового ключа
+func (repo *APIKeyRepository) InsertAPIKey(ctx context.Context, key *APIKey, keyHash string) (int64, error) {
+	insertStmt := `insert into "api_keys" ("user_id", "service_name", "name", "prefix", "key_hash", "scopes", "expires_at")
+		values($1, nullif($2, ''), $3, $4, $5, $6, $7) returning "id"`
+
+	var id int64 = 0
+	err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, key.UserID, key.ServiceName, key.Name, key.Prefix, keyHash,
+		pq.Array(key.Scopes), key.ExpiresAt).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// Поиск действующего ключа по префиксу; возвращает ключ и хеш для сравнения
+func (repo *APIKeyRepository) GetActiveAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, string, error) {
+	selectStmt := `select ` + apiKeyColumns + `, "key_hash" from "api_keys"
+		where "prefix" = $1 and "revoked_at" is null and "expires_at" > now()`
+
+	key := APIKey{}
+	var hash string
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, prefix).Scan(&key.ID, &key.UserID, &key.ServiceName, &key.Name, &key.Prefix,
+		pq.Array(&key.Scopes), &key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &hash)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &key, hash, nil
+}
+
+// Действующие ключи пользователя
+func (repo *APIKeyRepository) GetUserAPIKeys(ctx context.Context, userID int64) (*[]APIKey, error) {
+	selectStmt := `select ` + apiKeyColumns + ` from "api_keys"
+		where "user_id" = $1 and "revoked_at" is null and "expires_at" > now() order by "created_at"`
+	return repo.query(ctx, selectStmt, userID)
+}
+
+// Действующие ключи сервисных учетных записей
+func (repo *APIKeyRepository) GetServiceAPIKeys(ctx context.Context) (*[]APIKey, error) {
+	selectStmt := `select ` + apiKeyColumns + ` from "api_keys"
+		where "service_name" is not null and "revoked_at" is null and "expires_at" > now() order by "service_name", "created_at"`
+	return repo.query(ctx, selectStmt)
+}
+
+// Отметка об использовании ключа; пишется не чаще раза в минуту
+func (repo *APIKeyRepository) TouchAPIKey(ctx context.Context, id int64) error {
+	updateStmt := `update "api_keys" set "last_used_at" = now()
+		where "id" = $1 and ("last_used_at" is null or "last_used_at" < now() - interval '1 minute')`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, id)
+	return err
+}
+
+// Отзыв ключа пользователя; false, если такого действующего ключа нет
+func (repo *APIKeyRepository) RevokeUserAPIKey(ctx context.Context, userID int64, id int64) (bool, error) {
+	updateStmt := `update "api_keys" set "revoked_at" = now() where "id" = $1 and "user_id" = $2 and "revoked_at" is null`
+	return repo.revoke(ctx, updateStmt, id, userID)
+}
+
+// Отзыв ключа сервисной учетной записи; false, если такого действующего ключа нет
+func (repo *APIKeyRepository) RevokeServiceAPIKey(ctx context.Context, id int64) (bool, error) {
+	updateStmt := `update "api_keys" set "revoked_at" = now() where "id" = $1 and "service_name" is not null and "revoked_at" is null`
+	return repo.revoke(ctx, updateStmt, id)
+}
+
+func (repo *APIKeyRepository) revoke(ctx context.Context, updateStmt string, args ...any) (bool, error) {
+	result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, args...)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+func (repo *APIKeyRepository) query(ctx context.Context, selectStmt string, args ...any) (*[]APIKey, error) {
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key := APIKey{}
+		err = rows.Scan(&key.ID, &key.UserID, &key.ServiceName, &key.Name, &key.Prefix,
+			pq.Array(&key.Scopes), &key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return &keys, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	. "rest_module/model"
+)
+
+// Журнал аудита
+type AuditRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitAuditRepository(db *DBManager) *AuditRepository {
+	repo := AuditRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *AuditRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение записи журнала
+func (repo *AuditRepository) InsertAuditEvent(ctx context.Context, event *AuditEvent) (int64, error) {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return -1, err
+	}
+
+	insertStmt := `insert into "audit_log" ("actor_id", "user_id", "action", "details", "ip", "user_agent")
+		values($1, $2, $3, $4, $5, $6) returning "id", "created_at"`
+
+	err = repo.Database(ctx).QueryRowContext(ctx, insertStmt, event.ActorID, event.UserID, event.Action, details, event.IP, event.UserAgent).
+		Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return -1, err
+	}
+
+	return event.ID, nil
+}
+
+// Записи журнала, новые первыми; userID - участник записи (исполнитель или учетная запись) или 0 для всех,
+// action - действие или пусто для всех
+func (repo *AuditRepository) GetAuditEvents(ctx context.Context, userID int64, action string, limit int) (*[]AuditEvent, error) {
+	selectStmt := `select "id", "actor_id", "user_id", "action", "details", coalesce("ip", ''), coalesce("user_agent", ''), "created_at"
+		from "audit_log"
+		where ($1 = 0 or "user_id" = $1 or "actor_id" = $1) and ($2 = '' or "action" = $2)
+		order by "id" desc limit $3`
+
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, userID, action, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		event := AuditEvent{}
+		var details []byte
+		err = rows.Scan(&event.ID, &event.ActorID, &event.UserID, &event.Action, &details, &event.IP, &event.UserAgent, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(details, &event.Details); err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return &events, nil
+}
@@ -1,21 +1,33 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"net"
 	. "rest_module/utils"
 	"strconv"
 
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
-
-	_ "github.com/lib/pq"
 )
 
 type DBManager struct {
-	database           *sql.DB
-	currentTransaction *sql.Tx
+	database *sql.DB
+}
+
+// Общие методы пула соединений и транзакции, через которые репозитории выполняют запросы
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
+// Ключ транзакции в контексте
+type txKey struct{}
+
 // Конструктор БД.
 func NewDBManager() *DBManager {
 	// Получение параметров из переменных окружения
@@ -40,44 +52,97 @@ func NewDBManager() *DBManager {
 
 	manager := DBManager{}
 	manager.database = db
-	manager.currentTransaction = nil
 	return &manager
 }
 
+// Подключение к БД по строке подключения PostgreSQL
+func OpenDBManager(dsn string) (*DBManager, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка подключения к базе данных %w", err)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Ошибка подключения к базе данных %w", err)
+	}
+
+	manager := DBManager{}
+	manager.database = db
+	return &manager, nil
+}
+
 // Закрытие соединения
 func (manager *DBManager) CloseConnection() {
 	manager.database.Close()
 }
 
-// Старт транзакции
-func (manager *DBManager) BeginTransaction() error {
-	tx, err := manager.database.Begin()
-	if err != nil {
-		return fmt.Errorf("Ошибка открытия транзакции %s", err.Error())
+// Соединение для запросов: транзакция из контекста или пул соединений
+func (manager *DBManager) Querier(ctx context.Context) Querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
 	}
 
-	manager.currentTransaction = tx
-	return nil
+	return manager.database
 }
 
-// Подтверждение транзакции
-func (manager *DBManager) CommitTransaction() error {
-	if manager.currentTransaction == nil {
-		return fmt.Errorf("Транзакция не была открыта!")
+// Выполнение fn в транзакции с уровнем изоляции по умолчанию (read committed)
+func (manager *DBManager) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return manager.WithTxOptions(ctx, nil, fn)
+}
+
+// Выполнение fn в транзакции, переданной через контекст: репозитории, получившие этот контекст,
+// выполняют запросы в ней. Ошибка или паника fn откатывает транзакцию, иначе транзакция подтверждается.
+// Вызов внутри другой транзакции присоединяется к ней, opts при этом не применяются
+func (manager *DBManager) WithTxOptions(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := manager.database.BeginTx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("Ошибка открытия транзакции %w", err)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tx.Rollback()
+			panic(recovered)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		// Ошибка fn важнее ошибки отката: транзакция все равно не будет подтверждена
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			log.Warnf("Ошибка отката транзакции %v", rollbackErr)
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("Ошибка подтверждения транзакции %w", err)
 	}
 
-	manager.currentTransaction.Commit()
-	manager.currentTransaction = nil
 	return nil
 }
 
-// Подтверждение транзакции
-func (manager *DBManager) RollbackTransaction() error {
-	if manager.currentTransaction == nil {
-		return fmt.Errorf("Транзакция не была открыта!")
+// Операция не завершилась в срок: истек срок контекста или запрос отменен сервером (statement_timeout)
+func IsTimeout(err error) bool {
+	var pqErr *pq.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &pqErr) && pqErr.Code == "57014")
+}
+
+// Запрос отменен клиентом: соединение с ним закрыто до завершения операции
+func IsCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// БД недоступна: соединение не установлено или разорвано, сервер перегружен или перезапускается
+func IsUnavailable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Class() == "08" || pqErr.Code == "53300" || pqErr.Code == "57P01" || pqErr.Code == "57P03"
 	}
 
-	manager.currentTransaction.Rollback()
-	manager.currentTransaction = nil
-	return nil
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr)
 }
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Хранилище связей пользователей с учетными записями внешних провайдеров
+type IdentityStore interface {
+	// Сохранение связи пользователя с учетной записью провайдера
+	InsertIdentity(ctx context.Context, userID int64, provider, subject string) error
+	// Пользователь, связанный с учетной записью провайдера; 0, если связи нет
+	GetIdentityUserID(ctx context.Context, provider, subject string) (int64, error)
+}
+
+var _ IdentityStore = (*IdentityRepository)(nil)
+
+// Связь пользователей с учетными записями внешних провайдеров
+type IdentityRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitIdentityRepository(db *DBManager) *IdentityRepository {
+	repo := IdentityRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *IdentityRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение связи пользователя с учетной записью провайдера
+func (repo *IdentityRepository) InsertIdentity(ctx context.Context, userID int64, provider, subject string) error {
+	insertStmt := `insert into "user_identities" ("user_id", "provider", "subject") values($1, $2, $3)`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, insertStmt, userID, provider, subject)
+	return err
+}
+
+// Пользователь, связанный с учетной записью провайдера; 0, если связи нет
+func (repo *IdentityRepository) GetIdentityUserID(ctx context.Context, provider, subject string) (int64, error) {
+	selectStmt := `select "user_id" from "user_identities" where "provider" = $1 and "subject" = $2`
+
+	var userID int64
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return userID, err
+}
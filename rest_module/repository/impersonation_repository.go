@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	. "rest_module/model"
+)
+
+// Входы администраторов от имени пользователей
+type ImpersonationRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitImpersonationRepository(db *DBManager) *ImpersonationRepository {
+	repo := ImpersonationRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *ImpersonationRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение начатого входа от имени пользователя
+func (repo *ImpersonationRepository) InsertImpersonation(ctx context.Context, impersonation *Impersonation) (int64, error) {
+	insertStmt := `insert into "impersonations" ("actor_id", "user_id", "reason", "expires_at")
+		values($1, $2, $3, $4) returning "id", "started_at"`
+
+	err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, impersonation.ActorID, impersonation.UserID, impersonation.Reason, impersonation.ExpiresAt).
+		Scan(&impersonation.ID, &impersonation.StartedAt)
+	if err != nil {
+		return -1, err
+	}
+
+	return impersonation.ID, nil
+}
+
+// Поиск входа от имени пользователя; nil, если запись не найдена
+func (repo *ImpersonationRepository) GetImpersonation(ctx context.Context, id int64) (*Impersonation, error) {
+	selectStmt := `select "id", "actor_id", "user_id", "reason", "started_at", "expires_at", "ended_at" from "impersonations" where "id" = $1`
+
+	impersonation := Impersonation{}
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, id).Scan(&impersonation.ID, &impersonation.ActorID, &impersonation.UserID,
+		&impersonation.Reason, &impersonation.StartedAt, &impersonation.ExpiresAt, &impersonation.EndedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &impersonation, nil
+}
+
+// Вход от имени пользователя не завершен и не истек
+func (repo *ImpersonationRepository) IsActive(ctx context.Context, id int64) (bool, error) {
+	selectStmt := `select exists(select 1 from "impersonations" where "id" = $1 and "ended_at" is null and "expires_at" > now())`
+
+	var active bool
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, id).Scan(&active)
+	return active, err
+}
+
+// Завершение входа от имени пользователя; false, если он уже завершен или истек
+func (repo *ImpersonationRepository) EndImpersonation(ctx context.Context, id int64) (bool, error) {
+	updateStmt := `update "impersonations" set "ended_at" = now() where "id" = $1 and "ended_at" is null and "expires_at" > now()`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, id)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	. "rest_module/model"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Статус приглашения вычисляется по отметкам принятия, отзыва и сроку действия
+const invitationColumns = `"id", "user_id", "username", "email", "roles", "invited_by",
+	case when "accepted_at" is not null then 'accepted' when "revoked_at" is not null then 'revoked'
+		when "expires_at" <= now() then 'expired' else 'pending' end,
+	"created_at", "expires_at", "accepted_at", "revoked_at"`
+
+type InvitationRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitInvitationRepository(db *DBManager) *InvitationRepository {
+	repo := InvitationRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *InvitationRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение нового приглашения
+func (repo *InvitationRepository) InsertInvitation(ctx context.Context, invitation *Invitation) (int64, error) {
+	insertStmt := `insert into "invitations" ("user_id", "username", "email", "roles", "invited_by", "expires_at")
+		values($1, $2, $3, $4, $5, $6) returning "id", "created_at"`
+
+	err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, invitation.UserID, invitation.Username, invitation.Email,
+		pq.Array(invitation.Roles), invitation.InvitedBy, invitation.ExpiresAt).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return -1, err
+	}
+
+	return invitation.ID, nil
+}
+
+// Поиск приглашения по идентификатору
+func (repo *InvitationRepository) GetInvitation(ctx context.Context, id int64) (*Invitation, error) {
+	selectStmt := `select ` + invitationColumns + ` from "invitations" where "id" = $1`
+	return repo.queryRow(ctx, selectStmt, id)
+}
+
+// Непринятое и неотозванное приглашение пользователя
+func (repo *InvitationRepository) GetOpenInvitationByUserID(ctx context.Context, userID int64) (*Invitation, error) {
+	selectStmt := `select ` + invitationColumns + ` from "invitations"
+		where "user_id" = $1 and "accepted_at" is null and "revoked_at" is null`
+	return repo.queryRow(ctx, selectStmt, userID)
+}
+
+// Все приглашения, новые первыми
+func (repo *InvitationRepository) GetAllInvitations(ctx context.Context) (*[]Invitation, error) {
+	selectStmt := `select ` + invitationColumns + ` from "invitations" order by "created_at" desc, "id" desc`
+
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, *invitation)
+	}
+
+	return &invitations, nil
+}
+
+// Продление срока действия приглашения при повторной отправке
+func (repo *InvitationRepository) ExtendInvitation(ctx context.Context, id int64, expiresAt time.Time) error {
+	updateStmt := `update "invitations" set "expires_at" = $1 where "id" = $2`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, expiresAt, id)
+	return err
+}
+
+// Отметка о принятии приглашения
+func (repo *InvitationRepository) AcceptInvitation(ctx context.Context, id int64) error {
+	updateStmt := `update "invitations" set "accepted_at" = now() where "id" = $1`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, id)
+	return err
+}
+
+// Отзыв приглашения; false, если приглашение уже принято или отозвано
+func (repo *InvitationRepository) RevokeInvitation(ctx context.Context, id int64) (bool, error) {
+	updateStmt := `update "invitations" set "revoked_at" = now() where "id" = $1 and "accepted_at" is null and "revoked_at" is null`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, id)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+func (repo *InvitationRepository) queryRow(ctx context.Context, selectStmt string, args ...any) (*Invitation, error) {
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	return scanInvitation(rows)
+}
+
+func scanInvitation(rows *sql.Rows) (*Invitation, error) {
+	invitation := Invitation{}
+	err := rows.Scan(&invitation.ID, &invitation.UserID, &invitation.Username, &invitation.Email, pq.Array(&invitation.Roles),
+		&invitation.InvitedBy, &invitation.Status, &invitation.CreatedAt, &invitation.ExpiresAt, &invitation.AcceptedAt, &invitation.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
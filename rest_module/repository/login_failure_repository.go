@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	. "rest_module/model"
+	"time"
+)
+
+type LoginFailureRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitLoginFailureRepository(db *DBManager) *LoginFailureRepository {
+	repo := LoginFailureRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *LoginFailureRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Состояние счетчика неудачных попыток по ключу
+func (repo *LoginFailureRepository) GetStatus(ctx context.Context, key string) (*LockStatus, error) {
+	selectStmt := `select "failures", "locked_until" from "login_failures" where "key" = $1`
+
+	var failures int
+	var lockedUntil sql.NullTime
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, key).Scan(&failures, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return &LockStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := LockStatus{Failures: failures}
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		status.Locked = true
+		status.LockedUntil = &lockedUntil.Time
+	}
+
+	return &status, nil
+}
+
+// Учет неудачной попытки; счетчик начинается заново, если прошлая попытка старше window
+func (repo *LoginFailureRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	upsertStmt := `insert into "login_failures" ("key", "failures", "last_failure_at") values($1, 1, now())
+		on conflict ("key") do update set
+			"failures" = case when "login_failures"."last_failure_at" < now() - make_interval(secs => $2)
+				then 1 else "login_failures"."failures" + 1 end,
+			"last_failure_at" = now()
+		returning "failures"`
+
+	var failures int
+	err := repo.Database(ctx).QueryRowContext(ctx, upsertStmt, key, window.Seconds()).Scan(&failures)
+	return failures, err
+}
+
+// Блокировка входа по ключу до указанного времени
+func (repo *LoginFailureRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	updateStmt := `update "login_failures" set "locked_until" = $1 where "key" = $2`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, until, key)
+	return err
+}
+
+// Сброс счетчика и блокировки по ключу
+func (repo *LoginFailureRepository) Reset(ctx context.Context, key string) error {
+	deleteStmt := `delete from "login_failures" where "key" = $1`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, deleteStmt, key)
+	return err
+}
@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	. "rest_module/model"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Хранилище пользователей в памяти процесса для тестов и демонстраций.
+// Транзакции выполняются по одной и откатываются восстановлением снимка данных, поэтому
+// блокировка пользователя в транзакции не требуется
+type MemoryUserStore struct {
+	m      sync.RWMutex   // доступ к данным
+	tx     sync.Mutex     // очередь транзакций
+	users  map[int64]User // пользователи по идентификатору
+	nextID int64          // следующий идентификатор
+}
+
+// Ключ транзакции хранилища в контексте
+type memoryTxKey struct{}
+
+func MemoryUserStoreNewInstance() *MemoryUserStore {
+	store := MemoryUserStore{}
+	store.users = map[int64]User{}
+	store.nextID = 1
+	return &store
+}
+
+// Выполнение fn в транзакции; изменения, сделанные вне транзакции во время ее выполнения, откат не сохраняет
+func (store *MemoryUserStore) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) == store {
+		return fn(ctx)
+	}
+
+	store.tx.Lock()
+	defer store.tx.Unlock()
+
+	store.m.RLock()
+	users, nextID := maps.Clone(store.users), store.nextID
+	store.m.RUnlock()
+
+	committed := false
+	defer func() {
+		if !committed {
+			store.m.Lock()
+			store.users, store.nextID = users, nextID
+			store.m.Unlock()
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, store)); err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
+
+// Сохранение нового пользователя
+func (store *MemoryUserStore) InsertUser(ctx context.Context, user *User) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	if err := store.conflict(0, user); err != nil {
+		return -1, err
+	}
+
+	now := time.Now()
+	stored := copyUser(user)
+	stored.ID = store.nextID
+	stored.MFAEnabled = false
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	stored.Roles = slices.Compact(slices.Sorted(slices.Values(user.Roles)))
+	store.users[stored.ID] = stored
+	store.nextID++
+
+	return stored.ID, nil
+}
+
+// Поиск пользователя по идентификатору
+func (store *MemoryUserStore) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	return store.find(ctx, func(user *User) bool { return user.ID == id })
+}
+
+// Чтение пользователя; транзакции хранилища и так выполняются по одной
+func (store *MemoryUserStore) LockUserByID(ctx context.Context, id int64) (*User, error) {
+	return store.GetUserByID(ctx, id)
+}
+
+// Поиск пользователя по имени без учета регистра
+func (store *MemoryUserStore) GetUserByName(ctx context.Context, name string) (*User, error) {
+	return store.find(ctx, func(user *User) bool { return strings.EqualFold(user.Username, name) })
+}
+
+// Поиск пользователя по адресу почты
+func (store *MemoryUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return store.find(ctx, func(user *User) bool { return strings.EqualFold(user.Email, email) })
+}
+
+// Все пользователи по возрастанию идентификатора
+func (store *MemoryUserStore) GetAllUsers(ctx context.Context) (*[]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.m.RLock()
+	defer store.m.RUnlock()
+
+	var users []User
+	for _, user := range store.users {
+		users = append(users, copyUser(&user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return &users, nil
+}
+
+// Обновление пользователя
+func (store *MemoryUserStore) UpdateUser(ctx context.Context, id int64, user *User, pass string) error {
+	return store.updateUnique(ctx, id, user, func(stored *User) {
+		stored.Username = user.Username
+		stored.Password = pass
+		stored.Email = user.Email
+		stored.EmailVerified = user.EmailVerified
+		stored.UpdatedAt = time.Now()
+	})
+}
+
+// Обновление хеша пароля
+func (store *MemoryUserStore) UpdatePassword(ctx context.Context, id int64, pass string) error {
+	return store.update(ctx, id, func(stored *User) {
+		stored.Password = pass
+		stored.UpdatedAt = time.Now()
+	})
+}
+
+// Замена хеша того же пароля хешем текущего алгоритма
+func (store *MemoryUserStore) RehashPassword(ctx context.Context, id int64, oldHash, newHash string) error {
+	return store.update(ctx, id, func(stored *User) {
+		if stored.Password == oldHash {
+			stored.Password = newHash
+		}
+	})
+}
+
+// Обновление атрибутов, которыми управляет система провижининга
+func (store *MemoryUserStore) UpdateProvisioning(ctx context.Context, id int64, user *User) error {
+	return store.updateUnique(ctx, id, user, func(stored *User) {
+		stored.Username = user.Username
+		stored.Email = user.Email
+		stored.EmailVerified = user.EmailVerified
+		stored.Active = user.Active
+		stored.ExternalID = user.ExternalID
+		stored.UpdatedAt = time.Now()
+	})
+}
+
+// Включение учетной записи с подтверждением адреса почты
+func (store *MemoryUserStore) ActivateUser(ctx context.Context, id int64) error {
+	return store.update(ctx, id, func(stored *User) {
+		stored.Active = true
+		stored.EmailVerified = true
+		stored.UpdatedAt = time.Now()
+	})
+}
+
+// Отметка о подтверждении адреса почты
+func (store *MemoryUserStore) SetEmailVerified(ctx context.Context, id int64) error {
+	return store.update(ctx, id, func(stored *User) {
+		stored.EmailVerified = true
+	})
+}
+
+// Удаление пользователя
+func (store *MemoryUserStore) DeleteUserById(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	delete(store.users, id)
+	return nil
+}
+
+// Пользователь с наименьшим идентификатором, подходящий под match
+func (store *MemoryUserStore) find(ctx context.Context, match func(user *User) bool) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.m.RLock()
+	defer store.m.RUnlock()
+
+	var found *User
+	for _, user := range store.users {
+		if match(&user) && (found == nil || user.ID < found.ID) {
+			copied := copyUser(&user)
+			found = &copied
+		}
+	}
+
+	return found, nil
+}
+
+// Изменение сохраненного пользователя; отсутствующий пользователь не считается ошибкой, как и в SQL-хранилищах
+func (store *MemoryUserStore) update(ctx context.Context, id int64, change func(stored *User)) error {
+	return store.updateUnique(ctx, id, nil, change)
+}
+
+// Изменение пользователя с проверкой, что логин, адрес почты и внешний идентификатор user не заняты другими пользователями
+func (store *MemoryUserStore) updateUnique(ctx context.Context, id int64, user *User, change func(stored *User)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.m.Lock()
+	defer store.m.Unlock()
+
+	stored, ok := store.users[id]
+	if !ok {
+		return nil
+	}
+	if user != nil {
+		if err := store.conflict(id, user); err != nil {
+			return err
+		}
+	}
+	change(&stored)
+	store.users[id] = stored
+
+	return nil
+}
+
+// Проверка уникальности логина, адреса почты и внешнего идентификатора среди пользователей, кроме id; вызывается под store.m
+func (store *MemoryUserStore) conflict(id int64, user *User) error {
+	var emailTaken, externalIDTaken bool
+	for _, other := range store.users {
+		if other.ID == id {
+			continue
+		}
+		if strings.EqualFold(other.Username, user.Username) {
+			return ErrDuplicateUsername
+		}
+		emailTaken = emailTaken || strings.EqualFold(other.Email, user.Email)
+		externalIDTaken = externalIDTaken || (user.ExternalID != "" && other.ExternalID == user.ExternalID)
+	}
+
+	if emailTaken {
+		return ErrDuplicateEmail
+	}
+	if externalIDTaken {
+		return ErrDuplicateExternalID
+	}
+	return nil
+}
+
+// Копия пользователя, не разделяющая с оригиналом список ролей
+func copyUser(user *User) User {
+	copied := *user
+	copied.Roles = slices.Clone(user.Roles)
+	return copied
+}
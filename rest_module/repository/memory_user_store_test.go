@@ -0,0 +1,12 @@
+package repository_test
+
+import (
+	"testing"
+
+	"rest_module/repository"
+	"rest_module/repository/userstoretest"
+)
+
+func TestMemoryUserStore(t *testing.T) {
+	userstoretest.Run(t, func(t *testing.T) repository.UserStore { return repository.MemoryUserStoreNewInstance() })
+}
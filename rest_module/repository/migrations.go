@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/qustavo/dotsql"
+	log "github.com/sirupsen/logrus"
+)
+
+// Файлы миграций NNNN_описание.sql; запросы up и down размечены комментариями "-- name: up" и "-- name: down"
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+
+// Ключ рекомендательной блокировки, под которой реплики применяют миграции по очереди
+const migrationLockKey = 4781253209
+
+// Миграция схемы БД
+type Migration struct {
+	Version  int64  // номер версии
+	Name     string // описание из имени файла
+	Checksum string // sha256 файла миграции
+	up       string
+	down     string
+}
+
+// Состояние миграции
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time // время применения; nil, если не применена
+}
+
+// Применение и откат версионированных миграций схемы
+type Migrator struct {
+	Db         *DBManager  // база данных
+	migrations []Migration // миграции по возрастанию версии
+}
+
+// Конструктор мигратора; миграции читаются из встроенных файлов
+func NewMigrator(db *DBManager) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	migrator := Migrator{}
+	migrator.Db = db
+	migrator.migrations = migrations
+	return &migrator, nil
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения миграций %s", err.Error())
+	}
+
+	migrations := []Migration{}
+	versions := map[int64]string{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("Некорректное имя файла миграции %s", entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("Версия миграции %d повторяется в %s и %s", version, other, entry.Name())
+		}
+		versions[version] = entry.Name()
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка чтения миграции %s %s", entry.Name(), err.Error())
+		}
+
+		queries, err := dotsql.LoadFromString(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("Ошибка разбора миграции %s %s", entry.Name(), err.Error())
+		}
+		up, err := queries.Raw("up")
+		if err != nil || up == "" {
+			return nil, fmt.Errorf("В миграции %s нет запроса up", entry.Name())
+		}
+		// Без down миграция необратима
+		down, _ := queries.Raw("down")
+
+		checksum := sha256.Sum256(content)
+		migrations = append(migrations, Migration{Version: version, Name: match[2], Checksum: hex.EncodeToString(checksum[:]), up: up, down: down})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Выполнение fn на отдельном соединении под рекомендательной блокировкой миграций
+func (migrator *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	if migrator.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	ctx := context.Background()
+	// Рекомендательная блокировка принадлежит сеансу, поэтому все запросы идут через одно соединение
+	conn, err := migrator.Db.database.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к базе данных %s", err.Error())
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("Ошибка блокировки миграций %s", err.Error())
+	}
+	defer conn.ExecContext(ctx, `select pg_advisory_unlock($1)`, migrationLockKey)
+
+	createStmt := `create table if not exists "schema_migrations" (
+		"version" bigint primary key,
+		"name" varchar(255) not null,
+		"checksum" char(64) not null,
+		"applied_at" timestamptz not null default now()
+	)`
+	if _, err = conn.ExecContext(ctx, createStmt); err != nil {
+		return fmt.Errorf("Ошибка создания таблицы миграций %s", err.Error())
+	}
+
+	return fn(conn)
+}
+
+// Примененные миграции по версии
+func (migrator *Migrator) applied(conn *sql.Conn) (map[int64]MigrationStatus, error) {
+	selectStmt := `select "version", "name", "checksum", "applied_at" from "schema_migrations"`
+
+	rows, err := conn.QueryContext(context.Background(), selectStmt)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения примененных миграций %s", err.Error())
+	}
+	defer rows.Close()
+
+	applied := map[int64]MigrationStatus{}
+	for rows.Next() {
+		status := MigrationStatus{}
+		if err := rows.Scan(&status.Version, &status.Name, &status.Checksum, &status.AppliedAt); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения примененных миграций %s", err.Error())
+		}
+		applied[status.Version] = status
+	}
+
+	return applied, rows.Err()
+}
+
+// Проверка, что примененные миграции не изменены, не удалены и новые не вставлены перед ними
+func (migrator *Migrator) verify(applied map[int64]MigrationStatus) error {
+	known := map[int64]bool{}
+	var latest int64
+	for version := range applied {
+		latest = max(latest, version)
+	}
+
+	for _, migration := range migrator.migrations {
+		known[migration.Version] = true
+		status, ok := applied[migration.Version]
+		if !ok {
+			if migration.Version < latest {
+				return fmt.Errorf("Миграция %d_%s старее примененной версии %d", migration.Version, migration.Name, latest)
+			}
+			continue
+		}
+		if status.Checksum != migration.Checksum {
+			return fmt.Errorf("Контрольная сумма примененной миграции %d_%s изменилась", migration.Version, migration.Name)
+		}
+	}
+
+	for version, status := range applied {
+		if !known[version] {
+			return fmt.Errorf("Примененная миграция %d_%s не найдена", version, status.Name)
+		}
+	}
+
+	return nil
+}
+
+// Применение всех новых миграций
+func (migrator *Migrator) Up() error {
+	return migrator.withLock(func(conn *sql.Conn) error {
+		applied, err := migrator.applied(conn)
+		if err != nil {
+			return err
+		}
+		if err = migrator.verify(applied); err != nil {
+			return err
+		}
+
+		insertStmt := `insert into "schema_migrations" ("version", "name", "checksum") values($1, $2, $3)`
+		for _, migration := range migrator.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err := migrator.run(conn, migration.up, insertStmt, migration.Version, migration.Name, migration.Checksum)
+			if err != nil {
+				return fmt.Errorf("Ошибка применения миграции %d_%s %s", migration.Version, migration.Name, err.Error())
+			}
+			log.Printf("Миграция %d_%s применена", migration.Version, migration.Name)
+		}
+
+		return nil
+	})
+}
+
+// Откат steps последних примененных миграций
+func (migrator *Migrator) Down(steps int) error {
+	return migrator.withLock(func(conn *sql.Conn) error {
+		applied, err := migrator.applied(conn)
+		if err != nil {
+			return err
+		}
+		if err = migrator.verify(applied); err != nil {
+			return err
+		}
+
+		deleteStmt := `delete from "schema_migrations" where "version" = $1`
+		for i := len(migrator.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := migrator.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.down == "" {
+				return fmt.Errorf("Миграция %d_%s необратима", migration.Version, migration.Name)
+			}
+
+			if err := migrator.run(conn, migration.down, deleteStmt, migration.Version); err != nil {
+				return fmt.Errorf("Ошибка отката миграции %d_%s %s", migration.Version, migration.Name, err.Error())
+			}
+			log.Printf("Миграция %d_%s откачена", migration.Version, migration.Name)
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Состояние всех известных миграций
+func (migrator *Migrator) Status() ([]MigrationStatus, error) {
+	statuses := []MigrationStatus{}
+	err := migrator.withLock(func(conn *sql.Conn) error {
+		applied, err := migrator.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrator.migrations {
+			status := MigrationStatus{Migration: migration}
+			if record, ok := applied[migration.Version]; ok {
+				status.AppliedAt = record.AppliedAt
+			}
+			statuses = append(statuses, status)
+		}
+
+		return migrator.verify(applied)
+	})
+
+	return statuses, err
+}
+
+// Выполнение миграции и изменение записи о ней в одной транзакции
+func (migrator *Migrator) run(conn *sql.Conn, migration string, recordStmt string, args ...any) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, migration); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, recordStmt, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
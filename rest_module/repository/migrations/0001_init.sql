@@ -0,0 +1,323 @@
+-- Исходная схема. Выражения идемпотентны: базы, созданные прежним init_database.sql, принимают миграцию без изменений
+
+-- name: up
+-- Добавление таблицы пользователей
+create table if not exists users (
+    id bigserial primary key,
+    password varchar(255),
+    username varchar(50),
+    email varchar(50)
+);
+
+-- Роли пользователей
+create table if not exists roles (
+    id bigserial primary key,
+    name varchar(50) not null unique,
+    description varchar(255)
+);
+
+-- Разрешения, выдаваемые ролям
+create table if not exists permissions (
+    id bigserial primary key,
+    name varchar(50) not null unique,
+    description varchar(255)
+);
+
+create table if not exists role_permissions (
+    role_id bigint not null references roles(id) on delete cascade,
+    permission_id bigint not null references permissions(id) on delete cascade,
+    primary key (role_id, permission_id)
+);
+
+create table if not exists user_roles (
+    user_id bigint not null references users(id) on delete cascade,
+    role_id bigint not null references roles(id) on delete cascade,
+    primary key (user_id, role_id)
+);
+
+insert into roles (name, description) values
+    ('admin', 'Администратор'),
+    ('user', 'Пользователь')
+on conflict (name) do nothing;
+
+insert into permissions (name, description) values
+    ('users:read', 'Просмотр всех пользователей'),
+    ('users:write', 'Изменение любых пользователей'),
+    ('users:delete', 'Удаление пользователей'),
+    ('roles:manage', 'Назначение ролей'),
+    ('storage:read', 'Получение ссылок на объекты хранилища'),
+    ('storage:write', 'Загрузка объектов в хранилище')
+on conflict (name) do nothing;
+
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p where r.name = 'admin'
+on conflict do nothing;
+
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p where r.name = 'user' and p.name in ('storage:read')
+on conflict do nothing;
+
+-- Сессии входа, ключ - хеш токена обновления
+create table if not exists sessions (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    token_hash varchar(64) not null unique,
+    user_agent varchar(255) not null default '',
+    ip varchar(64) not null default '',
+    created_at timestamptz not null default now(),
+    last_used_at timestamptz not null default now(),
+    expires_at timestamptz not null,
+    revoked_at timestamptz
+);
+
+create index if not exists sessions_user_id_idx on sessions (user_id);
+
+-- Одноразовые токены пользователя (сброс пароля и т.п.)
+create table if not exists user_tokens (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    purpose varchar(32) not null,
+    token_hash varchar(64) not null unique,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz not null,
+    used_at timestamptz
+);
+
+create index if not exists user_tokens_user_id_idx on user_tokens (user_id, purpose);
+
+-- Подтверждение адреса почты; существующие пользователи считаются подтвержденными
+alter table users add column if not exists email_verified boolean not null default true;
+alter table users alter column email_verified set default false;
+
+-- Двухфакторная аутентификация TOTP; секрет хранится зашифрованным, коды восстановления - хешами
+alter table users add column if not exists totp_secret text;
+alter table users add column if not exists totp_enabled boolean not null default false;
+alter table users add column if not exists totp_last_step bigint not null default 0;
+alter table users add column if not exists recovery_codes text[] not null default '{}';
+
+-- Неудачные попытки входа по логину ('user:<логин>') и по адресу клиента ('ip:<адрес>')
+create table if not exists login_failures (
+    key varchar(128) primary key,
+    failures integer not null default 0,
+    last_failure_at timestamptz not null default now(),
+    locked_until timestamptz
+);
+
+-- История хешей паролей для запрета повторного использования
+create table if not exists password_history (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    password_hash varchar(255) not null,
+    created_at timestamptz not null default now()
+);
+
+create index if not exists password_history_user_id_idx on password_history (user_id, created_at desc);
+
+-- Ключи API пользователей и сервисных учетных записей; ключ хранится хешем, prefix - для поиска и отображения
+create table if not exists api_keys (
+    id bigserial primary key,
+    user_id bigint references users(id) on delete cascade,
+    service_name varchar(64),
+    name varchar(100) not null,
+    prefix varchar(16) not null unique,
+    key_hash varchar(64) not null,
+    scopes text[] not null default '{}',
+    created_at timestamptz not null default now(),
+    last_used_at timestamptz,
+    expires_at timestamptz not null,
+    revoked_at timestamptz,
+    check ((user_id is null) <> (service_name is null))
+);
+
+create index if not exists api_keys_user_id_idx on api_keys (user_id);
+
+insert into permissions (name, description) values
+    ('api_keys:manage', 'Управление ключами API сервисных учетных записей')
+on conflict (name) do nothing;
+
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p where r.name = 'admin' and p.name = 'api_keys:manage'
+on conflict do nothing;
+
+-- Клиенты OAuth2; у публичных клиентов нет секрета, секрет конфиденциальных хранится хешем
+create table if not exists oauth_clients (
+    id bigserial primary key,
+    client_id varchar(64) not null unique,
+    secret_hash varchar(64),
+    name varchar(100) not null,
+    redirect_uris text[] not null default '{}',
+    grant_types text[] not null default '{}',
+    scopes text[] not null default '{}',
+    created_at timestamptz not null default now(),
+    revoked_at timestamptz
+);
+
+-- Коды авторизации OAuth2 с параметрами PKCE
+create table if not exists oauth_codes (
+    id bigserial primary key,
+    code_hash varchar(64) not null unique,
+    client_id varchar(64) not null references oauth_clients(client_id) on delete cascade,
+    user_id bigint not null references users(id) on delete cascade,
+    redirect_uri text not null,
+    scopes text[] not null default '{}',
+    code_challenge varchar(128) not null,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz not null,
+    used_at timestamptz
+);
+
+-- Сессии, открытые клиентами OAuth2, ограничены выданными областями действия
+alter table sessions add column if not exists client_id varchar(64) references oauth_clients(client_id) on delete cascade;
+alter table sessions add column if not exists scopes text[];
+
+insert into permissions (name, description) values
+    ('oauth_clients:manage', 'Регистрация клиентов OAuth2')
+on conflict (name) do nothing;
+
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p where r.name = 'admin' and p.name = 'oauth_clients:manage'
+on conflict do nothing;
+
+-- Ключи подписи токенов (OpenID Connect); закрытый ключ хранится зашифрованным,
+-- выведенные из оборота ключи публикуются до истечения выданных ими токенов
+create table if not exists signing_keys (
+    id bigserial primary key,
+    kid varchar(64) not null unique,
+    algorithm varchar(16) not null,
+    private_key text not null,
+    created_at timestamptz not null default now(),
+    retired_at timestamptz
+);
+
+-- Параметр nonce запроса OpenID Connect переносится из кода авторизации в ID-токен
+alter table oauth_codes add column if not exists nonce varchar(255) not null default '';
+
+-- Учетные записи внешних провайдеров (SAML), связанные с пользователями
+create table if not exists user_identities (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    provider varchar(512) not null,
+    subject varchar(512) not null,
+    created_at timestamptz not null default now(),
+    unique (provider, subject)
+);
+
+create index if not exists user_identities_user_id_idx on user_identities (user_id);
+
+-- Отправленные запросы SAML AuthnRequest: ответ IdP принимается только на известный запрос
+create table if not exists saml_requests (
+    id varchar(128) primary key,
+    expires_at timestamptz not null
+);
+
+-- Принятые утверждения SAML, защита от повторного предъявления
+create table if not exists saml_assertions (
+    id varchar(256) primary key,
+    expires_at timestamptz not null
+);
+
+-- Провижининг по SCIM: отключение учетной записи, идентификатор во внешней системе и время изменения
+alter table users add column if not exists active boolean not null default true;
+alter table users add column if not exists external_id varchar(255);
+alter table users add column if not exists created_at timestamptz not null default now();
+alter table users add column if not exists updated_at timestamptz not null default now();
+
+create unique index if not exists users_external_id_idx on users (external_id) where external_id is not null;
+
+insert into permissions (name, description) values
+    ('scim:provision', 'Провижининг пользователей и групп по SCIM')
+on conflict (name) do nothing;
+
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p where r.name = 'admin' and p.name = 'scim:provision'
+on conflict do nothing;
+
+-- Приглашения пользователей; учетная запись создается отключенной и включается при принятии
+create table if not exists invitations (
+    id bigserial primary key,
+    user_id bigint references users(id) on delete set null,
+    username varchar(50) not null,
+    email varchar(255) not null,
+    roles text[] not null default '{}',
+    invited_by bigint references users(id) on delete set null,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz not null,
+    accepted_at timestamptz,
+    revoked_at timestamptz
+);
+
+create index if not exists invitations_user_id_idx on invitations (user_id);
+
+insert into permissions (name, description) values
+    ('invitations:manage', 'Приглашение пользователей')
+on conflict (name) do nothing;
+
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p where r.name = 'admin' and p.name = 'invitations:manage'
+on conflict do nothing;
+
+-- Ключи доступа WebAuthn (passkeys); открытый ключ хранится в формате COSE
+create table if not exists webauthn_credentials (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    credential_id varchar(1400) not null unique,
+    name varchar(100) not null,
+    public_key bytea not null,
+    sign_count bigint not null default 0,
+    transports text[] not null default '{}',
+    aaguid varchar(36) not null,
+    created_at timestamptz not null default now(),
+    last_used_at timestamptz
+);
+
+create index if not exists webauthn_credentials_user_id_idx on webauthn_credentials (user_id);
+
+-- Одноразовые вызовы церемоний WebAuthn; у вызова входа по ключу доступа без логина нет пользователя
+create table if not exists webauthn_challenges (
+    id bigserial primary key,
+    user_id bigint references users(id) on delete cascade,
+    purpose varchar(32) not null,
+    challenge_hash varchar(64) not null unique,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz not null
+);
+
+-- Журнал аудита: действия администраторов и события безопасности
+create table if not exists audit_log (
+    id bigserial primary key,
+    actor_id bigint references users(id) on delete set null,
+    user_id bigint references users(id) on delete set null,
+    action varchar(64) not null,
+    details jsonb not null default '{}',
+    ip varchar(64),
+    user_agent varchar(255),
+    created_at timestamptz not null default now()
+);
+
+create index if not exists audit_log_user_id_idx on audit_log (user_id, id);
+create index if not exists audit_log_actor_id_idx on audit_log (actor_id, id);
+
+-- Вход администраторов от имени пользователей; токен действует, пока запись не завершена и не истекла
+create table if not exists impersonations (
+    id bigserial primary key,
+    actor_id bigint references users(id) on delete set null,
+    user_id bigint not null references users(id) on delete cascade,
+    reason varchar(255) not null,
+    started_at timestamptz not null default now(),
+    expires_at timestamptz not null,
+    ended_at timestamptz
+);
+
+insert into permissions (name, description) values
+    ('users:impersonate', 'Вход от имени пользователя'),
+    ('audit:read', 'Чтение журнала аудита')
+on conflict (name) do nothing;
+
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p where r.name = 'admin' and p.name in ('users:impersonate', 'audit:read')
+on conflict do nothing;
+
+-- name: down
+drop table if exists impersonations, audit_log, webauthn_challenges, webauthn_credentials, invitations,
+    saml_assertions, saml_requests, user_identities, signing_keys, oauth_codes, api_keys, password_history,
+    login_failures, user_tokens, sessions, oauth_clients, user_roles, role_permissions, permissions, roles, users;
@@ -0,0 +1,10 @@
+-- Логин и адрес почты уникальны без учета регистра. Если в существующих данных есть дубликаты,
+-- миграция не применяется, пока они не устранены
+
+-- name: up
+create unique index if not exists users_username_lower_key on users (lower(username));
+create unique index if not exists users_email_lower_key on users (lower(email));
+
+-- name: down
+drop index if exists users_email_lower_key;
+drop index if exists users_username_lower_key;
@@ -0,0 +1,15 @@
+-- Счетчики ограничения частоты запросов в фиксированных окнах. Ограничения хранятся отдельно от
+-- счетчиков неудачных входов, чтобы сброс и блокировка входа не затрагивали их и наоборот
+
+-- name: up
+create table if not exists rate_limits (
+    key varchar(128) primary key,
+    hits integer not null default 0,
+    window_started_at timestamptz not null default now()
+);
+
+-- Ограничение запросов ссылки для входа прежде хранилось в login_failures
+delete from login_failures where key like 'magic_link:%';
+
+-- name: down
+drop table if exists rate_limits;
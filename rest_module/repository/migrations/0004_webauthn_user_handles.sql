@@ -0,0 +1,16 @@
+-- Идентификаторы пользователей для аутентификаторов WebAuthn: случайные, чтобы не раскрывать порядковый id.
+-- Пользователи с уже зарегистрированными ключами сохраняют прежний идентификатор (id в 8 байтах big-endian),
+-- записанный в их аутентификаторы
+
+-- name: up
+create table if not exists webauthn_user_handles (
+    user_id bigint primary key references users(id) on delete cascade,
+    handle bytea not null unique
+);
+
+insert into webauthn_user_handles (user_id, handle)
+    select distinct user_id, int8send(user_id) from webauthn_credentials
+    on conflict (user_id) do nothing;
+
+-- name: down
+drop table if exists webauthn_user_handles;
@@ -0,0 +1,13 @@
+-- Разрешение завершать входы от имени пользователя, начатые другими администраторами
+
+-- name: up
+insert into permissions (name, description) values
+    ('impersonations:end', 'Завершение любого входа от имени пользователя')
+on conflict (name) do nothing;
+
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p where r.name = 'admin' and p.name = 'impersonations:end'
+on conflict do nothing;
+
+-- name: down
+delete from permissions where name = 'impersonations:end';
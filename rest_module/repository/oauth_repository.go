@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	. "rest_module/model"
+
+	"github.com/lib/pq"
+)
+
+const oauthClientColumns = `"id", "client_id", "name", "redirect_uris", "grant_types", "scopes", "secret_hash" is not null, "created_at"`
+
+type OAuthRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitOAuthRepository(db *DBManager) *OAuthRepository {
+	repo := OAuthRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *OAuthRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение нового клиента; secretHash пуст для публичного клиента
+func (repo *OAuthRepository) InsertClient(ctx context.Context, client *OAuthClient, secretHash string) (int64, error) {
+	insertStmt := `insert into "oauth_clients" ("client_id", "secret_hash", "name", "redirect_uris", "grant_types", "scopes")
+		values($1, nullif($2, ''), $3, $4, $5, $6) returning "id"`
+
+	var id int64 = 0
+	err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, client.ClientID, secretHash, client.Name, pq.Array(client.RedirectURIs),
+		pq.Array(client.GrantTypes), pq.Array(client.Scopes)).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// Поиск действующего клиента; возвращает клиента и хеш секрета
+func (repo *OAuthRepository) GetClient(ctx context.Context, clientID string) (*OAuthClient, string, error) {
+	selectStmt := `select ` + oauthClientColumns + `, coalesce("secret_hash", '') from "oauth_clients"
+		where "client_id" = $1 and "revoked_at" is null`
+
+	client := OAuthClient{}
+	var secretHash string
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, clientID).Scan(&client.ID, &client.ClientID, &client.Name,
+		pq.Array(&client.RedirectURIs), pq.Array(&client.GrantTypes), pq.Array(&client.Scopes), &client.Confidential,
+		&client.CreatedAt, &secretHash)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &client, secretHash, nil
+}
+
+// Все действующие клиенты
+func (repo *OAuthRepository) GetAllClients(ctx context.Context) (*[]OAuthClient, error) {
+	selectStmt := `select ` + oauthClientColumns + ` from "oauth_clients" where "revoked_at" is null order by "name"`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		client := OAuthClient{}
+		err = rows.Scan(&client.ID, &client.ClientID, &client.Name, pq.Array(&client.RedirectURIs),
+			pq.Array(&client.GrantTypes), pq.Array(&client.Scopes), &client.Confidential, &client.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	return &clients, nil
+}
+
+// Отзыв клиента вместе с его сессиями; false, если клиент не найден
+func (repo *OAuthRepository) RevokeClient(ctx context.Context, clientID string) (bool, error) {
+	revoked := false
+	err := repo.Db.WithTx(ctx, func(ctx context.Context) error {
+		updateStmt := `update "oauth_clients" set "revoked_at" = now() where "client_id" = $1 and "revoked_at" is null`
+
+		result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, clientID)
+		if err != nil {
+			return err
+		}
+
+		count, err := result.RowsAffected()
+		if err != nil || count == 0 {
+			return err
+		}
+
+		revokeStmt := `update "sessions" set "revoked_at" = now() where "client_id" = $1 and "revoked_at" is null`
+		if _, err = repo.Database(ctx).ExecContext(ctx, revokeStmt, clientID); err != nil {
+			return err
+		}
+
+		revoked = true
+		return nil
+	})
+
+	return revoked && err == nil, err
+}
+
+// Сохранение кода авторизации
+func (repo *OAuthRepository) InsertCode(ctx context.Context, code *OAuthCode, codeHash string) error {
+	insertStmt := `insert into "oauth_codes" ("code_hash", "client_id", "user_id", "redirect_uri", "scopes", "code_challenge", "nonce", "expires_at")
+		values($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, insertStmt, codeHash, code.ClientID, code.UserID, code.RedirectURI,
+		pq.Array(code.Scopes), code.CodeChallenge, code.Nonce, code.ExpiresAt)
+	return err
+}
+
+// Погашение действующего кода авторизации; nil, если код не найден или уже использован
+func (repo *OAuthRepository) ConsumeCode(ctx context.Context, codeHash string) (*OAuthCode, error) {
+	updateStmt := `update "oauth_codes" set "used_at" = now()
+		where "code_hash" = $1 and "used_at" is null and "expires_at" > now()
+		returning "client_id", "user_id", "redirect_uri", "scopes", "code_challenge", "nonce", "expires_at"`
+
+	code := OAuthCode{}
+	err := repo.Database(ctx).QueryRowContext(ctx, updateStmt, codeHash).Scan(&code.ClientID, &code.UserID, &code.RedirectURI,
+		pq.Array(&code.Scopes), &code.CodeChallenge, &code.Nonce, &code.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
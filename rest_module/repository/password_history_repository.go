@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+)
+
+type PasswordHistoryRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitPasswordHistoryRepository(db *DBManager) *PasswordHistoryRepository {
+	repo := PasswordHistoryRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *PasswordHistoryRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение хеша пароля; хранятся только последние keep записей
+func (repo *PasswordHistoryRepository) InsertPasswordHash(ctx context.Context, userID int64, hash string, keep int) error {
+	insertStmt := `insert into "password_history" ("user_id", "password_hash") values($1, $2)`
+	if _, err := repo.Database(ctx).ExecContext(ctx, insertStmt, userID, hash); err != nil {
+		return err
+	}
+
+	deleteStmt := `delete from "password_history" where "user_id" = $1 and "id" not in (
+		select "id" from "password_history" where "user_id" = $1 order by "created_at" desc, "id" desc limit $2)`
+	_, err := repo.Database(ctx).ExecContext(ctx, deleteStmt, userID, keep)
+	return err
+}
+
+// Последние count хешей паролей пользователя
+func (repo *PasswordHistoryRepository) GetRecentHashes(ctx context.Context, userID int64, count int) ([]string, error) {
+	selectStmt := `select "password_hash" from "password_history" where "user_id" = $1 order by "created_at" desc, "id" desc limit $2`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, userID, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, nil
+}
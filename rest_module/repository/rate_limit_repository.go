@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type RateLimitRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitRateLimitRepository(db *DBManager) *RateLimitRepository {
+	repo := RateLimitRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *RateLimitRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Учет запроса в фиксированном окне длительностью window; окно, начатое раньше now() - window, начинается заново.
+// Возвращает число запросов в текущем окне и время его начала
+func (repo *RateLimitRepository) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	upsertStmt := `insert into "rate_limits" ("key", "hits", "window_started_at") values($1, 1, now())
+		on conflict ("key") do update set
+			"hits" = case when "rate_limits"."window_started_at" <= now() - make_interval(secs => $2)
+				then 1 else "rate_limits"."hits" + 1 end,
+			"window_started_at" = case when "rate_limits"."window_started_at" <= now() - make_interval(secs => $2)
+				then now() else "rate_limits"."window_started_at" end
+		returning "hits", "window_started_at"`
+
+	var hits int
+	var windowStartedAt time.Time
+	err := repo.Database(ctx).QueryRowContext(ctx, upsertStmt, key, window.Seconds()).Scan(&hits, &windowStartedAt)
+	return hits, windowStartedAt, err
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	. "rest_module/model"
+
+	"github.com/lib/pq"
+)
+
+type RoleRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitRoleRepository(db *DBManager) *RoleRepository {
+	repo := RoleRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *RoleRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Все роли с их разрешениями
+func (repo *RoleRepository) GetAllRoles(ctx context.Context) (*[]Role, error) {
+	selectStmt := `select r."id", r."name", coalesce(r."description", ''),
+		array(select p."name" from "role_permissions" rp join "permissions" p on p."id" = rp."permission_id" where rp."role_id" = r."id" order by p."name")
+		from "roles" r order by r."name"`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		role := Role{}
+		err = rows.Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return &roles, nil
+}
+
+// Поиск роли по имени
+func (repo *RoleRepository) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	selectStmt := `select "id", "name", coalesce("description", '') from "roles" where "name" = $1`
+
+	role := Role{}
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, name).Scan(&role.ID, &role.Name, &role.Description)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// Поиск роли по идентификатору
+func (repo *RoleRepository) GetRoleByID(ctx context.Context, id int64) (*Role, error) {
+	selectStmt := `select "id", "name", coalesce("description", '') from "roles" where "id" = $1`
+
+	role := Role{}
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, id).Scan(&role.ID, &role.Name, &role.Description)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// Сохранение новой роли без разрешений
+func (repo *RoleRepository) InsertRole(ctx context.Context, role *Role) (int64, error) {
+	insertStmt := `insert into "roles" ("name", "description") values($1, $2) returning "id"`
+
+	var id int64 = 0
+	err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, role.Name, role.Description).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// Переименование роли
+func (repo *RoleRepository) RenameRole(ctx context.Context, id int64, name string) error {
+	updateStmt := `update "roles" set "name" = $1 where "id" = $2`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, name, id)
+	return err
+}
+
+// Удаление роли вместе с ее назначениями
+func (repo *RoleRepository) DeleteRole(ctx context.Context, id int64) error {
+	deleteStmt := `delete from "roles" where "id" = $1`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, deleteStmt, id)
+	return err
+}
+
+// Пользователи с ролью; заполнены только идентификатор и логин
+func (repo *RoleRepository) GetRoleMembers(ctx context.Context, roleID int64) (*[]User, error) {
+	selectStmt := `select u."id", u."username" from "user_roles" ur join "users" u on u."id" = ur."user_id"
+		where ur."role_id" = $1 order by u."id"`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user := User{}
+		if err = rows.Scan(&user.ID, &user.Username); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return &users, nil
+}
+
+// Назначение роли пользователю
+func (repo *RoleRepository) AssignRole(ctx context.Context, userID int64, roleID int64) error {
+	insertStmt := `insert into "user_roles" ("user_id", "role_id") values($1, $2) on conflict do nothing`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, insertStmt, userID, roleID)
+	return err
+}
+
+// Снятие роли с пользователя
+func (repo *RoleRepository) RemoveRole(ctx context.Context, userID int64, roleID int64) error {
+	deleteStmt := `delete from "user_roles" where "user_id" = $1 and "role_id" = $2`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, deleteStmt, userID, roleID)
+	return err
+}
+
+// Имена разрешений, выданных пользователю через его роли
+func (repo *RoleRepository) GetUserPermissions(ctx context.Context, userID int64) ([]string, error) {
+	selectStmt := `select distinct p."name" from "user_roles" ur
+		join "role_permissions" rp on rp."role_id" = ur."role_id"
+		join "permissions" p on p."id" = rp."permission_id"
+		where ur."user_id" = $1 order by p."name"`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, name)
+	}
+
+	return permissions, nil
+}
+
+// Имена всех разрешений
+func (repo *RoleRepository) GetAllPermissions(ctx context.Context) ([]string, error) {
+	selectStmt := `select "name" from "permissions" order by "name"`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, name)
+	}
+
+	return permissions, nil
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Запросы аутентификации и принятые утверждения SAML
+type SAMLRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitSAMLRepository(db *DBManager) *SAMLRepository {
+	repo := SAMLRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *SAMLRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение идентификатора отправленного запроса AuthnRequest
+func (repo *SAMLRepository) InsertRequest(ctx context.Context, id string, expiresAt time.Time) error {
+	insertStmt := `insert into "saml_requests" ("id", "expires_at") values($1, $2)`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, insertStmt, id, expiresAt)
+	return err
+}
+
+// Погашение действующего запроса; false, если запрос не найден, истек или уже использован
+func (repo *SAMLRepository) ConsumeRequest(ctx context.Context, id string) (bool, error) {
+	deleteStmt := `delete from "saml_requests" where "id" = $1 and "expires_at" > now()`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, deleteStmt, id)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// Регистрация принятого утверждения; false, если утверждение уже принималось
+func (repo *SAMLRepository) InsertAssertion(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	// Истекшие утверждения повторно не пройдут проверку срока и больше не нужны
+	deleteStmt := `delete from "saml_assertions" where "expires_at" < now()`
+	if _, err := repo.Database(ctx).ExecContext(ctx, deleteStmt); err != nil {
+		return false, err
+	}
+
+	insertStmt := `insert into "saml_assertions" ("id", "expires_at") values($1, $2) on conflict ("id") do nothing`
+	result, err := repo.Database(ctx).ExecContext(ctx, insertStmt, id, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
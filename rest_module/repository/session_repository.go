@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	. "rest_module/model"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const sessionColumns = `"id", "user_id", "user_agent", "ip", "created_at", "last_used_at", "expires_at", coalesce("client_id", ''), "scopes"`
+
+type SessionRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitSessionRepository(db *DBManager) *SessionRepository {
+	repo := SessionRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *SessionRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение новой сессии
+func (repo *SessionRepository) InsertSession(ctx context.Context, session *Session, tokenHash string) (int64, error) {
+	insertStmt := `insert into "sessions" ("user_id", "token_hash", "user_agent", "ip", "expires_at", "client_id", "scopes")
+		values($1, $2, $3, $4, $5, nullif($6, ''), $7) returning "id"`
+
+	var scopes any
+	if session.Scopes != nil {
+		scopes = pq.Array(session.Scopes)
+	}
+
+	var id int64 = 0
+	err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, session.UserID, tokenHash, session.UserAgent, session.IP, session.ExpiresAt,
+		session.ClientID, scopes).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// Поиск действующей сессии по хешу токена обновления
+func (repo *SessionRepository) GetActiveSessionByHash(ctx context.Context, tokenHash string) (*Session, error) {
+	selectStmt := `select ` + sessionColumns + ` from "sessions"
+		where "token_hash" = $1 and "revoked_at" is null and "expires_at" > now()`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return scanSession(rows)
+	}
+
+	return nil, nil
+}
+
+// Замена токена обновления сессии; false, если сессия уже была обновлена или отозвана
+func (repo *SessionRepository) RotateSession(ctx context.Context, id int64, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	updateStmt := `update "sessions" set "token_hash" = $1, "expires_at" = $2, "last_used_at" = now()
+		where "id" = $3 and "token_hash" = $4 and "revoked_at" is null`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, newHash, expiresAt, id, oldHash)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+// Действующие сессии пользователя
+func (repo *SessionRepository) GetUserSessions(ctx context.Context, userID int64) (*[]Session, error) {
+	selectStmt := `select ` + sessionColumns + ` from "sessions"
+		where "user_id" = $1 and "revoked_at" is null and "expires_at" > now() order by "last_used_at" desc`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, *session)
+	}
+
+	return &sessions, nil
+}
+
+// Проверка, что сессия не отозвана и не истекла
+func (repo *SessionRepository) IsSessionActive(ctx context.Context, id int64) (bool, error) {
+	selectStmt := `select exists(select 1 from "sessions" where "id" = $1 and "revoked_at" is null and "expires_at" > now())`
+
+	var active bool
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, id).Scan(&active)
+	return active, err
+}
+
+// Отзыв сессии пользователя; false, если такой действующей сессии нет
+func (repo *SessionRepository) RevokeSession(ctx context.Context, userID int64, id int64) (bool, error) {
+	updateStmt := `update "sessions" set "revoked_at" = now() where "id" = $1 and "user_id" = $2 and "revoked_at" is null`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+// Отзыв всех сессий пользователя
+func (repo *SessionRepository) RevokeUserSessions(ctx context.Context, userID int64) error {
+	updateStmt := `update "sessions" set "revoked_at" = now() where "user_id" = $1 and "revoked_at" is null`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, userID)
+	return err
+}
+
+func scanSession(rows *sql.Rows) (*Session, error) {
+	session := Session{}
+	err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.ClientID, pq.Array(&session.Scopes))
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
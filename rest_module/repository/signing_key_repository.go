@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	. "rest_module/model"
+	"time"
+)
+
+type SigningKeyRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitSigningKeyRepository(db *DBManager) *SigningKeyRepository {
+	repo := SigningKeyRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *SigningKeyRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Действующие и недавно выведенные из оборота ключи, новые первыми
+func (repo *SigningKeyRepository) GetPublishedKeys(ctx context.Context, retention time.Duration) (*[]SigningKey, error) {
+	selectStmt := `select "id", "kid", "algorithm", "private_key", "created_at", "retired_at" from "signing_keys"
+		where "retired_at" is null or "retired_at" > now() - make_interval(secs => $1)
+		order by "retired_at" is null desc, "created_at" desc`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, retention.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		key := SigningKey{}
+		if err = rows.Scan(&key.ID, &key.KID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt, &key.RetiredAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return &keys, nil
+}
+
+// Сохранение первого ключа
+func (repo *SigningKeyRepository) InsertSigningKey(ctx context.Context, key *SigningKey) (int64, error) {
+	insertStmt := `insert into "signing_keys" ("kid", "algorithm", "private_key") values($1, $2, $3) returning "id"`
+
+	var id int64 = 0
+	err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, key.KID, key.Algorithm, key.PrivateKey).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// Замена действующего ключа новым в одной транзакции; false, если ключ уже заменен другим экземпляром
+func (repo *SigningKeyRepository) RotateSigningKey(ctx context.Context, currentID int64, key *SigningKey) (bool, error) {
+	rotated := false
+	err := repo.Db.WithTx(ctx, func(ctx context.Context) error {
+		updateStmt := `update "signing_keys" set "retired_at" = now() where "id" = $1 and "retired_at" is null`
+		result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, currentID)
+		if err != nil {
+			return err
+		}
+
+		count, err := result.RowsAffected()
+		if err != nil || count != 1 {
+			return err
+		}
+
+		insertStmt := `insert into "signing_keys" ("kid", "algorithm", "private_key") values($1, $2, $3)`
+		if _, err = repo.Database(ctx).ExecContext(ctx, insertStmt, key.KID, key.Algorithm, key.PrivateKey); err != nil {
+			return err
+		}
+
+		rotated = true
+		return nil
+	})
+
+	return rotated && err == nil, err
+}
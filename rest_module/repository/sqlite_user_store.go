@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	. "rest_module/model"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Схема хранилища SQLite, создается при открытии
+const sqliteUserSchema = `create table if not exists "users" (
+	"id" integer primary key autoincrement,
+	"username" text not null,
+	"password" text not null,
+	"email" text not null,
+	"email_verified" boolean not null default false,
+	"active" boolean not null default true,
+	"external_id" text,
+	"created_at" timestamp not null,
+	"updated_at" timestamp not null
+);
+create unique index if not exists "users_username_lower_key" on "users" (lower("username"));
+create unique index if not exists "users_email_lower_key" on "users" (lower("email"));
+create unique index if not exists "users_external_id_idx" on "users" ("external_id") where "external_id" is not null;
+create table if not exists "user_roles" (
+	"user_id" integer not null references "users" ("id") on delete cascade,
+	"role" text not null,
+	primary key ("user_id", "role")
+)`
+
+// Колонки пользователя для SQLite; роли собираются в массив JSON
+const sqliteUserColumns = `"id", "username", "password", "email", "email_verified", "active", coalesce("external_id", ''),
+	"created_at", "updated_at",
+	(select json_group_array("role") from (select "role" from "user_roles" where "user_id" = "users"."id" order by "role"))`
+
+// Хранилище пользователей во встроенной БД SQLite для локальной разработки
+type SQLiteUserStore struct {
+	Db *DBManager // база данных
+}
+
+// Открытие файла БД SQLite с созданием схемы
+func SQLiteUserStoreNewInstance(path string) (*SQLiteUserStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка открытия БД SQLite %s", err.Error())
+	}
+	// SQLite допускает одного писателя; единственное соединение исключает ошибки блокировки файла
+	// и выполняет транзакции по одной
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec(sqliteUserSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Ошибка создания схемы SQLite %s", err.Error())
+	}
+
+	store := SQLiteUserStore{}
+	store.Db = &DBManager{database: db}
+	return &store, nil
+}
+
+func (store *SQLiteUserStore) Database(ctx context.Context) Querier {
+	if store.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return store.Db.Querier(ctx)
+}
+
+// Выполнение fn в транзакции; транзакции SQLite сериализуемы
+func (store *SQLiteUserStore) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if store.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return store.Db.WithTx(ctx, fn)
+}
+
+// Сохранение нового пользователя вместе с ролями
+func (store *SQLiteUserStore) InsertUser(ctx context.Context, user *User) (int64, error) {
+	insertStmt := `insert into "users" ("username", "password", "email", "email_verified", "active", "external_id", "created_at", "updated_at")
+		values($1, $2, $3, $4, $5, nullif($6, ''), $7, $7) returning "id"`
+	rolesStmt := `insert into "user_roles" ("user_id", "role") values($1, $2) on conflict do nothing`
+
+	var id int64 = 0
+	err := store.WithTx(ctx, func(ctx context.Context) error {
+		err := store.Database(ctx).QueryRowContext(ctx, insertStmt, user.Username, user.Password, user.Email, user.EmailVerified, user.Active, user.ExternalID, time.Now()).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		for _, role := range user.Roles {
+			if _, err = store.Database(ctx).ExecContext(ctx, rolesStmt, id, role); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return -1, sqliteUserConflict(err)
+	}
+
+	return id, nil
+}
+
+// Поиск пользователя по идентификатору
+func (store *SQLiteUserStore) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	return store.findUser(ctx, `"id" = $1`, id)
+}
+
+// Чтение пользователя; транзакции выполняются по одной на единственном соединении
+func (store *SQLiteUserStore) LockUserByID(ctx context.Context, id int64) (*User, error) {
+	return store.GetUserByID(ctx, id)
+}
+
+// Поиск пользователя по имени без учета регистра
+func (store *SQLiteUserStore) GetUserByName(ctx context.Context, name string) (*User, error) {
+	return store.findUser(ctx, `lower("username") = lower($1)`, name)
+}
+
+// Поиск пользователя по адресу почты
+func (store *SQLiteUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return store.findUser(ctx, `lower("email") = lower($1)`, email)
+}
+
+// Все пользователи
+func (store *SQLiteUserStore) GetAllUsers(ctx context.Context) (*[]User, error) {
+	selectStmt := `select ` + sqliteUserColumns + ` from "users" order by "id"`
+	rows, err := store.Database(ctx).QueryContext(ctx, selectStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanSQLiteUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, *user)
+	}
+
+	return &users, rows.Err()
+}
+
+// Обновление пользователя
+func (store *SQLiteUserStore) UpdateUser(ctx context.Context, id int64, user *User, pass string) error {
+	updateStmt := `update "users" set "username"=$1, "password"=$2, "email"=$3, "email_verified"=$4, "updated_at"=$5 where "id" = $6`
+
+	_, err := store.Database(ctx).ExecContext(ctx, updateStmt, user.Username, pass, user.Email, user.EmailVerified, time.Now(), id)
+	return sqliteUserConflict(err)
+}
+
+// Обновление хеша пароля
+func (store *SQLiteUserStore) UpdatePassword(ctx context.Context, id int64, pass string) error {
+	updateStmt := `update "users" set "password"=$1, "updated_at"=$2 where "id" = $3`
+
+	_, err := store.Database(ctx).ExecContext(ctx, updateStmt, pass, time.Now(), id)
+	return err
+}
+
+// Замена хеша того же пароля хешем текущего алгоритма
+func (store *SQLiteUserStore) RehashPassword(ctx context.Context, id int64, oldHash, newHash string) error {
+	updateStmt := `update "users" set "password"=$1 where "id" = $2 and "password" = $3`
+
+	_, err := store.Database(ctx).ExecContext(ctx, updateStmt, newHash, id, oldHash)
+	return err
+}
+
+// Обновление атрибутов, которыми управляет система провижининга
+func (store *SQLiteUserStore) UpdateProvisioning(ctx context.Context, id int64, user *User) error {
+	updateStmt := `update "users" set "username"=$1, "email"=$2, "email_verified"=$3, "active"=$4, "external_id"=nullif($5, ''),
+		"updated_at"=$6 where "id" = $7`
+
+	_, err := store.Database(ctx).ExecContext(ctx, updateStmt, user.Username, user.Email, user.EmailVerified, user.Active, user.ExternalID, time.Now(), id)
+	return sqliteUserConflict(err)
+}
+
+// Включение учетной записи с подтверждением адреса почты
+func (store *SQLiteUserStore) ActivateUser(ctx context.Context, id int64) error {
+	updateStmt := `update "users" set "active"=true, "email_verified"=true, "updated_at"=$1 where "id" = $2`
+
+	_, err := store.Database(ctx).ExecContext(ctx, updateStmt, time.Now(), id)
+	return err
+}
+
+// Отметка о подтверждении адреса почты
+func (store *SQLiteUserStore) SetEmailVerified(ctx context.Context, id int64) error {
+	updateStmt := `update "users" set "email_verified"=true where "id" = $1`
+
+	_, err := store.Database(ctx).ExecContext(ctx, updateStmt, id)
+	return err
+}
+
+// Удаление пользователя; роли удаляются каскадно
+func (store *SQLiteUserStore) DeleteUserById(ctx context.Context, id int64) error {
+	deleteStmt := `delete from "users" where "id" = $1`
+
+	_, err := store.Database(ctx).ExecContext(ctx, deleteStmt, id)
+	return err
+}
+
+// Первый по идентификатору пользователь, подходящий под условие where
+func (store *SQLiteUserStore) findUser(ctx context.Context, where string, args ...any) (*User, error) {
+	selectStmt := `select ` + sqliteUserColumns + ` from "users" where ` + where + ` order by "id" limit 1`
+	rows, err := store.Database(ctx).QueryContext(ctx, selectStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return scanSQLiteUser(rows)
+	}
+
+	return nil, rows.Err()
+}
+
+// Нарушение уникального индекса логина или почты как ErrDuplicateUsername или ErrDuplicateEmail
+func sqliteUserConflict(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		switch {
+		case strings.Contains(sqliteErr.Error(), "users_username_lower_key"):
+			return ErrDuplicateUsername
+		case strings.Contains(sqliteErr.Error(), "users_email_lower_key"):
+			return ErrDuplicateEmail
+		case strings.Contains(sqliteErr.Error(), "users.external_id"):
+			return ErrDuplicateExternalID
+		}
+	}
+
+	return err
+}
+
+// Чтение пользователя из текущей строки выборки по sqliteUserColumns
+func scanSQLiteUser(rows *sql.Rows) (*User, error) {
+	user := User{}
+	var roles string
+
+	err := rows.Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.EmailVerified, &user.Active, &user.ExternalID,
+		&user.CreatedAt, &user.UpdatedAt, &roles)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(roles), &user.Roles); err != nil {
+		return nil, fmt.Errorf("Ошибка чтения ролей пользователя %s", err.Error())
+	}
+
+	return &user, nil
+}
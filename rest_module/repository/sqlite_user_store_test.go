@@ -0,0 +1,20 @@
+package repository_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"rest_module/repository"
+	"rest_module/repository/userstoretest"
+)
+
+func TestSQLiteUserStore(t *testing.T) {
+	userstoretest.Run(t, func(t *testing.T) repository.UserStore {
+		store, err := repository.SQLiteUserStoreNewInstance(filepath.Join(t.TempDir(), "users.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(store.Db.CloseConnection)
+		return store
+	})
+}
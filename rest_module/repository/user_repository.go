@@ -1,10 +1,57 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	. "rest_module/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+// Колонки пользователя вместе с именами его ролей; второй фактор - TOTP или ключ доступа
+const userColumns = `"id", "username", "password", "email", "email_verified",
+	("totp_enabled" or exists(select 1 from "webauthn_credentials" c where c."user_id" = "users"."id")),
+	"active", coalesce("external_id", ''), "created_at", "updated_at",
+	array(select r."name" from "user_roles" ur join "roles" r on r."id" = ur."role_id" where ur."user_id" = "users"."id" order by r."name")`
+
+// Поля, по которым возможен поиск пользователей, и их колонки
+var userQueryColumns = map[string]string{
+	"id":          `"id"`,
+	"username":    `lower("username")`,
+	"email":       `lower("email")`,
+	"external_id": `"external_id"`,
+	"active":      `"active"`,
+	"created_at":  `"created_at"`,
+	"updated_at":  `"updated_at"`,
+}
+
+// Операторы сравнения условий поиска
+var userQueryOperators = map[string]string{
+	"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<=",
+}
+
+// Условие поиска с полем или оператором, которые не поддерживаются
+var ErrUnsupportedQuery = errors.New("Условие поиска не поддерживается")
+
+// Условие поиска пользователей: поле из userQueryColumns, оператор (eq, ne, co, sw, ew, pr, gt, ge, lt, le) и значение
+type UserCondition struct {
+	Field    string
+	Operator string
+	Value    string
+}
+
+// Поиск пользователей: условия объединяются через "и", выдача постраничная
+type UserQuery struct {
+	Conditions []UserCondition
+	Offset     int
+	Limit      int
+}
+
 type UserRepository struct {
 	Db *DBManager // база данных
 }
@@ -15,105 +62,243 @@ func InitUserRepository(db *DBManager) *UserRepository {
 	return &repo
 }
 
-func (repo *UserRepository) Database() *sql.DB {
+func (repo *UserRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Выполнение fn в транзакции; согласованность изменений обеспечивают уникальные индексы и LockUserByID
+func (repo *UserRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
 	if repo.Db == nil {
 		panic("База данных не подключена!")
 	}
 
-	return repo.Db.database
+	return repo.Db.WithTx(ctx, fn)
 }
 
-// Сохранение нового пользователя в БД
-func (repo *UserRepository) InsertUser(user *User) (int64, error) {
-	insertStmt := `insert into "users" ("username", "password", "email") values($1, $2, $3) returning "id"`
+// Сохранение нового пользователя в БД; назначаются только существующие роли из user.Roles
+func (repo *UserRepository) InsertUser(ctx context.Context, user *User) (int64, error) {
+	insertStmt := `insert into "users" ("username", "password", "email", "email_verified", "active", "external_id")
+		values($1, $2, $3, $4, $5, nullif($6, '')) returning "id"`
+	rolesStmt := `insert into "user_roles" ("user_id", "role_id") select $1, "id" from "roles" where "name" = any($2) on conflict do nothing`
 
 	var id int64 = 0
-	err := repo.Database().QueryRow(insertStmt, user.Username, user.Password, user.Email).Scan(&id)
+	err := repo.WithTx(ctx, func(ctx context.Context) error {
+		err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, user.Username, user.Password, user.Email, user.EmailVerified, user.Active, user.ExternalID).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		if len(user.Roles) > 0 {
+			_, err = repo.Database(ctx).ExecContext(ctx, rolesStmt, id, pq.Array(user.Roles))
+		}
+		return err
+	})
 	if err != nil {
-		return -1, err
+		return -1, userConflict(err)
 	}
 
 	return id, nil
 }
 
 // Обновление пользователя
-func (repo *UserRepository) UpdateUser(id int64, user *User, pass string) error {
-	insertStmt := `update "users" set "username"=$1, "password"=$2, "email"=$3 where "id" = $1`
+func (repo *UserRepository) UpdateUser(ctx context.Context, id int64, user *User, pass string) error {
+	insertStmt := `update "users" set "username"=$1, "password"=$2, "email"=$3, "email_verified"=$4, "updated_at"=now() where "id" = $5`
 
-	_, err := repo.Database().Exec(insertStmt, user.Username, pass, user.Email, id)
+	_, err := repo.Database(ctx).ExecContext(ctx, insertStmt, user.Username, pass, user.Email, user.EmailVerified, id)
 	if err != nil {
-		return err
+		return userConflict(err)
 	}
 
 	return nil
 }
 
+// Обновление хеша пароля
+func (repo *UserRepository) UpdatePassword(ctx context.Context, id int64, pass string) error {
+	updateStmt := `update "users" set "password"=$1, "updated_at"=now() where "id" = $2`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, pass, id)
+	return err
+}
+
+// Замена хеша того же пароля хешем текущего алгоритма; хеш не меняется, если пароль уже сменен
+func (repo *UserRepository) RehashPassword(ctx context.Context, id int64, oldHash, newHash string) error {
+	updateStmt := `update "users" set "password"=$1 where "id" = $2 and "password" = $3`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, newHash, id, oldHash)
+	return err
+}
+
+// Обновление атрибутов, которыми управляет система провижининга
+func (repo *UserRepository) UpdateProvisioning(ctx context.Context, id int64, user *User) error {
+	updateStmt := `update "users" set "username"=$1, "email"=$2, "email_verified"=$3, "active"=$4, "external_id"=nullif($5, ''),
+		"updated_at"=now() where "id" = $6`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, user.Username, user.Email, user.EmailVerified, user.Active, user.ExternalID, id)
+	return userConflict(err)
+}
+
+// Включение учетной записи с подтверждением адреса почты
+func (repo *UserRepository) ActivateUser(ctx context.Context, id int64) error {
+	updateStmt := `update "users" set "active"=true, "email_verified"=true, "updated_at"=now() where "id" = $1`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, id)
+	return err
+}
+
+// Отметка о подтверждении адреса почты
+func (repo *UserRepository) SetEmailVerified(ctx context.Context, id int64) error {
+	updateStmt := `update "users" set "email_verified"=true where "id" = $1`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, id)
+	return err
+}
+
+// Настройки TOTP пользователя
+func (repo *UserRepository) GetTOTPSettings(ctx context.Context, id int64) (*TOTPSettings, error) {
+	selectStmt := `select coalesce("totp_secret", ''), "totp_enabled", "totp_last_step", "recovery_codes" from "users" where "id" = $1`
+
+	settings := TOTPSettings{}
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, id).Scan(&settings.Secret, &settings.Enabled, &settings.LastStep, pq.Array(&settings.RecoveryCodes))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// Сохранение нового секрета TOTP до подтверждения подключения
+func (repo *UserRepository) SetTOTPSecret(ctx context.Context, id int64, secret string) error {
+	updateStmt := `update "users" set "totp_secret"=$1, "totp_enabled"=false, "totp_last_step"=0, "recovery_codes"='{}' where "id" = $2`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, secret, id)
+	return err
+}
+
+// Включение TOTP с первым принятым шагом и кодами восстановления
+func (repo *UserRepository) EnableTOTP(ctx context.Context, id int64, step int64, recoveryCodes []string) error {
+	updateStmt := `update "users" set "totp_enabled"=true, "totp_last_step"=$1, "recovery_codes"=$2 where "id" = $3`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, step, pq.Array(recoveryCodes), id)
+	return err
+}
+
+// Отключение TOTP
+func (repo *UserRepository) DisableTOTP(ctx context.Context, id int64) error {
+	updateStmt := `update "users" set "totp_secret"=null, "totp_enabled"=false, "totp_last_step"=0, "recovery_codes"='{}' where "id" = $1`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, id)
+	return err
+}
+
+// Фиксация принятого шага TOTP; false, если код этого шага уже использован
+func (repo *UserRepository) AdvanceTOTPStep(ctx context.Context, id int64, step int64) (bool, error) {
+	updateStmt := `update "users" set "totp_last_step"=$1 where "id" = $2 and "totp_last_step" < $1`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, step, id)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+// Замена набора кодов восстановления
+func (repo *UserRepository) SetRecoveryCodes(ctx context.Context, id int64, recoveryCodes []string) error {
+	updateStmt := `update "users" set "recovery_codes"=$1 where "id" = $2`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, pq.Array(recoveryCodes), id)
+	return err
+}
+
+// Погашение кода восстановления по его хешу; false, если код уже использован
+func (repo *UserRepository) RemoveRecoveryCode(ctx context.Context, id int64, codeHash string) (bool, error) {
+	updateStmt := `update "users" set "recovery_codes"=array_remove("recovery_codes", $1) where "id" = $2 and $1 = any("recovery_codes")`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, codeHash, id)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
 // Поиск пользователя по идентификатору
-func (repo *UserRepository) GetUserByID(id int64) (*User, error) {
-	selectStmt := `select "id", "username", "password", "email" from "users" where "id" = $1`
-	rows, err := repo.Database().Query(selectStmt, id)
+func (repo *UserRepository) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	selectStmt := `select ` + userColumns + ` from "users" where "id" = $1`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if rows.Next() {
-		var id int64
-		var username string
-		var password string
-		var email string
+		return scanUser(rows)
+	}
 
-		err = rows.Scan(&id, &username, &password, &email)
-		if err != nil {
-			return nil, err
-		}
+	return nil, nil
+}
+
+// Блокировка пользователя до конца транзакции с чтением
+func (repo *UserRepository) LockUserByID(ctx context.Context, id int64) (*User, error) {
+	selectStmt := `select ` + userColumns + ` from "users" where "id" = $1 for update`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		return &User{
-			ID:       id,
-			Username: username,
-			Password: password,
-			Email:    email,
-		}, nil
+	if rows.Next() {
+		return scanUser(rows)
 	}
 
 	return nil, nil
 }
 
-// Поиск пользователя по имени
-func (repo *UserRepository) GetUserByName(name string) (*User, error) {
-	selectStmt := `select "id", "username", "password", "email" from "users" where "username" ~ $1`
-	rows, err := repo.Database().Query(selectStmt, name)
+// Поиск пользователя по имени без учета регистра
+func (repo *UserRepository) GetUserByName(ctx context.Context, name string) (*User, error) {
+	selectStmt := `select ` + userColumns + ` from "users" where lower("username") = lower($1)`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, name)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if rows.Next() {
-		var id int64
-		var username string
-		var password string
-		var email string
+		return scanUser(rows)
+	}
 
-		err = rows.Scan(&id, &username, &password, &email)
-		if err != nil {
-			return nil, err
-		}
+	return nil, nil
+}
+
+// Поиск пользователя по адресу почты
+func (repo *UserRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	selectStmt := `select ` + userColumns + ` from "users" where lower("email") = lower($1) order by "id" limit 1`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		return &User{
-			ID:       id,
-			Username: username,
-			Password: password,
-			Email:    email,
-		}, nil
+	if rows.Next() {
+		return scanUser(rows)
 	}
 
 	return nil, nil
 }
 
 // Все пользователи
-func (repo *UserRepository) GetAllUsers() (*[]User, error) {
-	selectStmt := `select "id", "username", "password", "email" from "users"`
-	rows, err := repo.Database().Query(selectStmt)
+func (repo *UserRepository) GetAllUsers(ctx context.Context) (*[]User, error) {
+	selectStmt := `select ` + userColumns + ` from "users"`
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt)
 	if err != nil {
 		return nil, err
 	}
@@ -121,37 +306,158 @@ func (repo *UserRepository) GetAllUsers() (*[]User, error) {
 
 	var users []User
 	for rows.Next() {
-		var id int64
-		var username string
-		var password string
-		var email string
-
-		err = rows.Scan(&id, &username, &password, &email)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		user := User{
-			ID:       id,
-			Username: username,
-			Password: password,
-			Email:    email,
+		users = append(users, *user)
+	}
+
+	return &users, nil
+}
+
+// Поиск пользователей по условиям; возвращает страницу и общее число найденных
+func (repo *UserRepository) SearchUsers(ctx context.Context, query *UserQuery) (*[]User, int, error) {
+	where := []string{"true"}
+	args := []interface{}{}
+	for _, condition := range query.Conditions {
+		column, ok := userQueryColumns[condition.Field]
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: поиск по полю %s", ErrUnsupportedQuery, condition.Field)
+		}
+
+		value := condition.Value
+		if condition.Field == "username" || condition.Field == "email" {
+			value = strings.ToLower(value)
 		}
 
-		users = append(users, user)
+		placeholder := "$" + strconv.Itoa(len(args)+1)
+		switch condition.Operator {
+		case "pr":
+			where = append(where, column+" is not null")
+			continue
+		case "co", "sw", "ew":
+			pattern := escapeLike(value)
+			switch condition.Operator {
+			case "co":
+				pattern = "%" + pattern + "%"
+			case "sw":
+				pattern = pattern + "%"
+			default:
+				pattern = "%" + pattern
+			}
+			where = append(where, column+"::text like "+placeholder)
+			args = append(args, pattern)
+		default:
+			operator, ok := userQueryOperators[condition.Operator]
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: оператор %s", ErrUnsupportedQuery, condition.Operator)
+			}
+			where = append(where, column+" "+operator+" "+placeholder)
+			args = append(args, value)
+		}
 	}
+	clause := strings.Join(where, " and ")
 
-	return &users, nil
+	countStmt := `select count(*) from "users"` + ` where ` + clause
+	var total int
+	if err := repo.Database(ctx).QueryRowContext(ctx, countStmt, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	selectStmt := `select ` + userColumns + ` from "users" where ` + clause + ` order by "id"` +
+		` offset $` + strconv.Itoa(len(args)+1) + ` limit $` + strconv.Itoa(len(args)+2)
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, append(args, query.Offset, query.Limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		users = append(users, *user)
+	}
+
+	return &users, total, nil
 }
 
 // Удаление пользователя
-func (repo *UserRepository) DeleteUserById(id int64) error {
-	insertStmt := `delete from "users" where "username" ~ $1`
+func (repo *UserRepository) DeleteUserById(ctx context.Context, id int64) error {
+	deleteStmt := `delete from "users" where "id" = $1`
 
-	err := repo.Database().QueryRow(insertStmt).Scan(&id)
+	_, err := repo.Database(ctx).ExecContext(ctx, deleteStmt, id)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Чтение пользователя из текущей строки выборки по userColumns
+func scanUser(rows *sql.Rows) (*User, error) {
+	var id int64
+	var username string
+	var password string
+	var email string
+	var emailVerified bool
+	var mfaEnabled bool
+	var active bool
+	var externalID string
+	var createdAt time.Time
+	var updatedAt time.Time
+	var roles []string
+
+	err := rows.Scan(&id, &username, &password, &email, &emailVerified, &mfaEnabled, &active, &externalID, &createdAt, &updatedAt, pq.Array(&roles))
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{
+		ID:            id,
+		Username:      username,
+		Password:      password,
+		Email:         email,
+		EmailVerified: emailVerified,
+		MFAEnabled:    mfaEnabled,
+		Active:        active,
+		ExternalID:    externalID,
+		CreatedAt:     createdAt,
+		UpdatedAt:     updatedAt,
+		Roles:         roles,
+	}, nil
+}
+
+// Нарушение уникального индекса логина, почты или внешнего идентификатора как ErrDuplicateUsername,
+// ErrDuplicateEmail или ErrDuplicateExternalID
+func userConflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_username_lower_key":
+			return ErrDuplicateUsername
+		case "users_email_lower_key":
+			return ErrDuplicateEmail
+		case "users_external_id_idx":
+			return ErrDuplicateExternalID
+		}
+	}
+
+	return err
+}
+
+// Экранирование спецсимволов шаблона like
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// Некорректное условие поиска: неподдерживаемое поле или оператор либо значение, не приводимое к типу колонки
+func IsInvalidQuery(err error) bool {
+	var pqErr *pq.Error
+	return errors.Is(err, ErrUnsupportedQuery) || (errors.As(err, &pqErr) && pqErr.Code.Class() == "22")
+}
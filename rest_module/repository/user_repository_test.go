@@ -0,0 +1,71 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"rest_module/repository"
+	"rest_module/repository/userstoretest"
+)
+
+// Проверка хранилища PostgreSQL из TEST_DATABASE_URL; без переменной тест пропускается.
+// Каждая проверка получает отдельную схему с примененными миграциями, которая удаляется после проверки
+func TestUserRepository(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задан")
+	}
+
+	admin, err := repository.OpenDBManager(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(admin.CloseConnection)
+
+	userstoretest.Run(t, func(t *testing.T) repository.UserStore {
+		schema := fmt.Sprintf("userstore_test_%d", time.Now().UnixNano())
+		ctx := context.Background()
+		if _, err := admin.Querier(ctx).ExecContext(ctx, fmt.Sprintf("create schema %s", schema)); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { admin.Querier(ctx).ExecContext(ctx, fmt.Sprintf("drop schema %s cascade", schema)) })
+
+		db, err := repository.OpenDBManager(withSearchPath(t, dsn, schema))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(db.CloseConnection)
+
+		migrator, err := repository.NewMigrator(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = migrator.Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		return repository.InitUserRepository(db)
+	})
+}
+
+// Строка подключения с search_path; lib/pq передает неизвестные параметры серверу
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	t.Helper()
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	query.Set("search_path", schema)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	. "rest_module/model"
+)
+
+// Нарушение уникальности логина, адреса почты или идентификатора в системе провижининга;
+// логин и адрес почты сравниваются без учета регистра
+var (
+	ErrDuplicateUsername   = errors.New("Пользователь с таким логином уже есть")
+	ErrDuplicateEmail      = errors.New("Пользователь с таким адресом почты уже есть")
+	ErrDuplicateExternalID = errors.New("Пользователь с таким externalId уже есть")
+)
+
+// Хранилище учетных записей пользователей, с которым работают UserManager и другие сервисы.
+// Методы, вызванные с контекстом из WithTx, выполняются в транзакции хранилища
+type UserStore interface {
+	// Выполнение fn в транзакции; ошибка fn откатывает изменения, вложенный вызов присоединяется к внешней транзакции
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// Сохранение нового пользователя вместе с ролями user.Roles; возвращает идентификатор.
+	// Занятый логин, адрес почты или внешний идентификатор - ErrDuplicateUsername, ErrDuplicateEmail или ErrDuplicateExternalID
+	InsertUser(ctx context.Context, user *User) (int64, error)
+	// Поиск по идентификатору; nil, если пользователь не найден
+	GetUserByID(ctx context.Context, id int64) (*User, error)
+	// Чтение пользователя с блокировкой записи до конца транзакции: параллельные транзакции,
+	// блокирующие того же пользователя, ждут ее завершения
+	LockUserByID(ctx context.Context, id int64) (*User, error)
+	// Поиск по логину без учета регистра; nil, если пользователь не найден
+	GetUserByName(ctx context.Context, name string) (*User, error)
+	// Поиск по адресу почты без учета регистра, при совпадениях - с наименьшим идентификатором; nil, если не найден
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// Все пользователи
+	GetAllUsers(ctx context.Context) (*[]User, error)
+
+	// Обновление логина, почты, подтверждения почты и хеша пароля pass; ошибки уникальности как у InsertUser
+	UpdateUser(ctx context.Context, id int64, user *User, pass string) error
+	// Обновление хеша пароля
+	UpdatePassword(ctx context.Context, id int64, pass string) error
+	// Замена хеша oldHash на newHash; хеш не меняется, если пароль уже сменен
+	RehashPassword(ctx context.Context, id int64, oldHash, newHash string) error
+	// Обновление атрибутов, которыми управляет система провижининга; ошибки уникальности как у InsertUser
+	UpdateProvisioning(ctx context.Context, id int64, user *User) error
+	// Включение учетной записи с подтверждением адреса почты
+	ActivateUser(ctx context.Context, id int64) error
+	// Отметка о подтверждении адреса почты
+	SetEmailVerified(ctx context.Context, id int64) error
+	// Удаление пользователя
+	DeleteUserById(ctx context.Context, id int64) error
+}
+
+var (
+	_ UserStore = (*UserRepository)(nil)
+	_ UserStore = (*MemoryUserStore)(nil)
+	_ UserStore = (*SQLiteUserStore)(nil)
+)
+
+// Настройки второго фактора пользователей
+type TOTPStore interface {
+	// Настройки TOTP пользователя; nil, если пользователь не найден
+	GetTOTPSettings(ctx context.Context, id int64) (*TOTPSettings, error)
+	// Сохранение нового секрета TOTP до подтверждения подключения
+	SetTOTPSecret(ctx context.Context, id int64, secret string) error
+	// Включение TOTP с первым принятым шагом и хешами кодов восстановления
+	EnableTOTP(ctx context.Context, id int64, step int64, recoveryCodes []string) error
+	// Отключение TOTP с удалением секрета и кодов восстановления
+	DisableTOTP(ctx context.Context, id int64) error
+	// Фиксация принятого шага TOTP; false, если код этого шага уже использован
+	AdvanceTOTPStep(ctx context.Context, id int64, step int64) (bool, error)
+	// Замена набора кодов восстановления
+	SetRecoveryCodes(ctx context.Context, id int64, recoveryCodes []string) error
+	// Погашение кода восстановления по его хешу; false, если код уже использован
+	RemoveRecoveryCode(ctx context.Context, id int64, codeHash string) (bool, error)
+}
+
+// Поиск пользователей по условиям
+type UserSearchStore interface {
+	// Страница найденных пользователей и общее число найденных; неподдерживаемое условие - ErrUnsupportedQuery
+	SearchUsers(ctx context.Context, query *UserQuery) (*[]User, int, error)
+}
+
+var (
+	_ TOTPStore       = (*UserRepository)(nil)
+	_ UserSearchStore = (*UserRepository)(nil)
+)
+
+// Ошибка нарушения уникальности логина, адреса почты или внешнего идентификатора
+func IsUserConflict(err error) bool {
+	return errors.Is(err, ErrDuplicateUsername) || errors.Is(err, ErrDuplicateEmail) || errors.Is(err, ErrDuplicateExternalID)
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type UserTokenRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitUserTokenRepository(db *DBManager) *UserTokenRepository {
+	repo := UserTokenRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *UserTokenRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение хеша одноразового токена
+func (repo *UserTokenRepository) InsertToken(ctx context.Context, userID int64, purpose, tokenHash string, expiresAt time.Time) error {
+	insertStmt := `insert into "user_tokens" ("user_id", "purpose", "token_hash", "expires_at") values($1, $2, $3, $4)`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, insertStmt, userID, purpose, tokenHash, expiresAt)
+	return err
+}
+
+// Владелец действующего токена без его погашения; 0, если токен не найден
+func (repo *UserTokenRepository) GetTokenUserID(ctx context.Context, purpose, tokenHash string) (int64, error) {
+	selectStmt := `select "user_id" from "user_tokens"
+		where "token_hash" = $1 and "purpose" = $2 and "used_at" is null and "expires_at" > now()`
+
+	var userID int64
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, tokenHash, purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return userID, err
+}
+
+// Погашение токена; 0, если токен не найден, истек или уже использован
+func (repo *UserTokenRepository) ConsumeToken(ctx context.Context, purpose, tokenHash string) (int64, error) {
+	updateStmt := `update "user_tokens" set "used_at" = now()
+		where "token_hash" = $1 and "purpose" = $2 and "used_at" is null and "expires_at" > now()
+		returning "user_id"`
+
+	var userID int64
+	err := repo.Database(ctx).QueryRowContext(ctx, updateStmt, tokenHash, purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return userID, err
+}
+
+// Погашение всех действующих токенов пользователя с указанным назначением
+func (repo *UserTokenRepository) InvalidateUserTokens(ctx context.Context, userID int64, purpose string) error {
+	updateStmt := `update "user_tokens" set "used_at" = now() where "user_id" = $1 and "purpose" = $2 and "used_at" is null`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, updateStmt, userID, purpose)
+	return err
+}
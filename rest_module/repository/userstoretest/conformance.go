@@ -0,0 +1,499 @@
+// Общий набор проверок реализаций repository.UserStore.
+//
+// Тест реализации вызывает Run с фабрикой пустых хранилищ:
+//
+//	userstoretest.Run(t, func(t *testing.T) repository.UserStore { return repository.MemoryUserStoreNewInstance() })
+package userstoretest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rest_module/repository"
+	"slices"
+	"sync"
+	"testing"
+
+	. "rest_module/model"
+)
+
+// Роль, которая есть во всех хранилищах: в PostgreSQL ее создают миграции
+const userRole = "user"
+
+// Проверка хранилища; newStore должен возвращать новое пустое хранилище для каждой проверки
+func Run(t *testing.T, newStore func(t *testing.T) repository.UserStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store repository.UserStore)
+	}{
+		{"InsertAndGet", testInsertAndGet},
+		{"Lookup", testLookup},
+		{"Uniqueness", testUniqueness},
+		{"ExternalIDUniqueness", testExternalIDUniqueness},
+		{"GetAllUsers", testGetAllUsers},
+		{"UpdateUser", testUpdateUser},
+		{"Passwords", testPasswords},
+		{"Provisioning", testProvisioning},
+		{"Activation", testActivation},
+		{"Delete", testDelete},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+		{"ConcurrentInserts", testConcurrentInserts},
+		{"ConcurrentDuplicates", testConcurrentDuplicates},
+		{"LockUser", testLockUser},
+		{"CanceledContext", testCanceledContext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// Сохранение пользователя с проверкой ошибки
+func insert(t *testing.T, store repository.UserStore, user User) int64 {
+	t.Helper()
+	id, err := store.InsertUser(context.Background(), &user)
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	return id
+}
+
+// Чтение существующего пользователя
+func get(t *testing.T, store repository.UserStore, id int64) *User {
+	t.Helper()
+	user, err := store.GetUserByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if user == nil {
+		t.Fatalf("GetUserByID(%d): пользователь не найден", id)
+	}
+	return user
+}
+
+func testInsertAndGet(t *testing.T, store repository.UserStore) {
+	id := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com", Active: true, ExternalID: "ext-1", Roles: []string{userRole}})
+	if id <= 0 {
+		t.Fatalf("InsertUser: некорректный идентификатор %d", id)
+	}
+
+	user := get(t, store, id)
+	if user.ID != id || user.Username != "alice" || user.Password != "hash" || user.Email != "alice@example.com" {
+		t.Errorf("GetUserByID: %+v", user)
+	}
+	if !user.Active || user.EmailVerified || user.MFAEnabled || user.ExternalID != "ext-1" {
+		t.Errorf("GetUserByID: флаги %+v", user)
+	}
+	if user.CreatedAt.IsZero() || user.UpdatedAt.IsZero() {
+		t.Errorf("GetUserByID: не заполнено время создания или изменения %+v", user)
+	}
+	if !slices.Equal(user.Roles, []string{userRole}) {
+		t.Errorf("GetUserByID: роли %v", user.Roles)
+	}
+
+	other := insert(t, store, User{Username: "bob", Password: "hash", Email: "bob@example.com"})
+	if other == id {
+		t.Errorf("InsertUser: идентификатор %d повторяется", id)
+	}
+	if user := get(t, store, other); user.Active || len(user.Roles) != 0 || user.ExternalID != "" {
+		t.Errorf("GetUserByID: %+v", user)
+	}
+
+	missing, err := store.GetUserByID(context.Background(), id+other+100)
+	if err != nil || missing != nil {
+		t.Errorf("GetUserByID отсутствующего: %+v, %v", missing, err)
+	}
+}
+
+func testLookup(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	first := insert(t, store, User{Username: "Alice", Password: "hash", Email: "Alice@Example.com"})
+	insert(t, store, User{Username: "bob", Password: "hash", Email: "bob@example.com"})
+
+	// Логин и почта сравниваются без учета регистра
+	user, err := store.GetUserByName(ctx, "alice")
+	if err != nil || user == nil || user.ID != first || user.Username != "Alice" {
+		t.Errorf("GetUserByName: %+v, %v", user, err)
+	}
+	if user, err = store.GetUserByName(ctx, "carol"); err != nil || user != nil {
+		t.Errorf("GetUserByName отсутствующего: %+v, %v", user, err)
+	}
+
+	user, err = store.GetUserByEmail(ctx, "ALICE@example.COM")
+	if err != nil || user == nil || user.ID != first || user.Email != "Alice@Example.com" {
+		t.Errorf("GetUserByEmail: %+v, %v", user, err)
+	}
+	if user, err = store.GetUserByEmail(ctx, "carol@example.com"); err != nil || user != nil {
+		t.Errorf("GetUserByEmail отсутствующего: %+v, %v", user, err)
+	}
+}
+
+func testUniqueness(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	alice := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com"})
+	bob := insert(t, store, User{Username: "bob", Password: "hash", Email: "bob@example.com"})
+
+	_, err := store.InsertUser(ctx, &User{Username: "ALICE", Password: "hash", Email: "other@example.com"})
+	if !errors.Is(err, repository.ErrDuplicateUsername) {
+		t.Errorf("InsertUser с занятым логином: %v", err)
+	}
+	_, err = store.InsertUser(ctx, &User{Username: "carol", Password: "hash", Email: "Bob@Example.com"})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("InsertUser с занятым адресом почты: %v", err)
+	}
+
+	if err = store.UpdateUser(ctx, bob, &User{Username: "Alice", Email: "bob@example.com"}, "hash"); !errors.Is(err, repository.ErrDuplicateUsername) {
+		t.Errorf("UpdateUser с занятым логином: %v", err)
+	}
+	if err = store.UpdateProvisioning(ctx, bob, &User{Username: "bob", Email: "ALICE@example.com"}); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("UpdateProvisioning с занятым адресом почты: %v", err)
+	}
+	if user := get(t, store, bob); user.Username != "bob" || user.Email != "bob@example.com" {
+		t.Errorf("Пользователь изменен несмотря на конфликт: %+v", user)
+	}
+
+	// Свои логин и адрес почты не конфликтуют, в том числе при смене регистра
+	if err = store.UpdateUser(ctx, alice, &User{Username: "Alice", Email: "Alice@example.com"}, "hash"); err != nil {
+		t.Errorf("UpdateUser со своим логином: %v", err)
+	}
+
+	// Логин удаленного пользователя снова свободен
+	if err = store.DeleteUserById(ctx, bob); err != nil {
+		t.Fatalf("DeleteUserById: %v", err)
+	}
+	insert(t, store, User{Username: "bob", Password: "hash", Email: "bob@example.com"})
+}
+
+// Внешний идентификатор уникален; пользователи без него не конфликтуют
+func testExternalIDUniqueness(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com", ExternalID: "ext-1"})
+	bob := insert(t, store, User{Username: "bob", Password: "hash", Email: "bob@example.com"})
+	insert(t, store, User{Username: "carol", Password: "hash", Email: "carol@example.com"})
+
+	_, err := store.InsertUser(ctx, &User{Username: "dave", Password: "hash", Email: "dave@example.com", ExternalID: "ext-1"})
+	if !errors.Is(err, repository.ErrDuplicateExternalID) || !repository.IsUserConflict(err) {
+		t.Errorf("InsertUser с занятым externalId: %v", err)
+	}
+	if err = store.UpdateProvisioning(ctx, bob, &User{Username: "bob", Email: "bob@example.com", ExternalID: "ext-1"}); !errors.Is(err, repository.ErrDuplicateExternalID) {
+		t.Errorf("UpdateProvisioning с занятым externalId: %v", err)
+	}
+	if user := get(t, store, bob); user.ExternalID != "" {
+		t.Errorf("Пользователь изменен несмотря на конфликт: %+v", user)
+	}
+
+	if err = store.UpdateProvisioning(ctx, bob, &User{Username: "bob", Email: "bob@example.com", ExternalID: "ext-2"}); err != nil {
+		t.Errorf("UpdateProvisioning со свободным externalId: %v", err)
+	}
+}
+
+func testGetAllUsers(t *testing.T, store repository.UserStore) {
+	users, err := store.GetAllUsers(context.Background())
+	if err != nil || users == nil || len(*users) != 0 {
+		t.Fatalf("GetAllUsers пустого хранилища: %v, %v", users, err)
+	}
+
+	want := map[int64]string{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		want[insert(t, store, User{Username: name, Password: "hash", Email: name + "@example.com"})] = name
+	}
+
+	users, err = store.GetAllUsers(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllUsers: %v", err)
+	}
+	if len(*users) != len(want) {
+		t.Fatalf("GetAllUsers: %d пользователей, ожидалось %d", len(*users), len(want))
+	}
+	for _, user := range *users {
+		if want[user.ID] != user.Username {
+			t.Errorf("GetAllUsers: неожиданный пользователь %+v", user)
+		}
+	}
+}
+
+func testUpdateUser(t *testing.T, store repository.UserStore) {
+	id := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com", Active: true, Roles: []string{userRole}})
+	before := get(t, store, id)
+
+	update := User{Username: "alice2", Email: "alice2@example.com", EmailVerified: true}
+	if err := store.UpdateUser(context.Background(), id, &update, "new-hash"); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	user := get(t, store, id)
+	if user.Username != "alice2" || user.Email != "alice2@example.com" || !user.EmailVerified || user.Password != "new-hash" {
+		t.Errorf("UpdateUser: %+v", user)
+	}
+	if !user.Active || !slices.Equal(user.Roles, []string{userRole}) || !user.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("UpdateUser изменил лишние поля: %+v", user)
+	}
+	if user.UpdatedAt.Before(before.UpdatedAt) {
+		t.Errorf("UpdateUser: время изменения %v раньше %v", user.UpdatedAt, before.UpdatedAt)
+	}
+}
+
+func testPasswords(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	id := insert(t, store, User{Username: "alice", Password: "hash-1", Email: "alice@example.com"})
+
+	if err := store.UpdatePassword(ctx, id, "hash-2"); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	if user := get(t, store, id); user.Password != "hash-2" {
+		t.Errorf("UpdatePassword: хеш %s", user.Password)
+	}
+
+	// Хеш уже смененного пароля не пересчитывается
+	if err := store.RehashPassword(ctx, id, "hash-1", "rehash-1"); err != nil {
+		t.Fatalf("RehashPassword: %v", err)
+	}
+	if user := get(t, store, id); user.Password != "hash-2" {
+		t.Errorf("RehashPassword заменил хеш смененного пароля: %s", user.Password)
+	}
+
+	if err := store.RehashPassword(ctx, id, "hash-2", "rehash-2"); err != nil {
+		t.Fatalf("RehashPassword: %v", err)
+	}
+	if user := get(t, store, id); user.Password != "rehash-2" {
+		t.Errorf("RehashPassword: хеш %s", user.Password)
+	}
+}
+
+func testProvisioning(t *testing.T, store repository.UserStore) {
+	id := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com", Active: true, ExternalID: "ext-1"})
+
+	update := User{Username: "alice2", Email: "alice2@example.com", EmailVerified: true, Active: false}
+	if err := store.UpdateProvisioning(context.Background(), id, &update); err != nil {
+		t.Fatalf("UpdateProvisioning: %v", err)
+	}
+
+	user := get(t, store, id)
+	if user.Username != "alice2" || user.Email != "alice2@example.com" || !user.EmailVerified || user.Active || user.ExternalID != "" {
+		t.Errorf("UpdateProvisioning: %+v", user)
+	}
+	if user.Password != "hash" {
+		t.Errorf("UpdateProvisioning изменил хеш пароля: %s", user.Password)
+	}
+}
+
+func testActivation(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	verified := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com", Active: true})
+	invited := insert(t, store, User{Username: "bob", Password: "hash", Email: "bob@example.com"})
+
+	if err := store.SetEmailVerified(ctx, verified); err != nil {
+		t.Fatalf("SetEmailVerified: %v", err)
+	}
+	if user := get(t, store, verified); !user.EmailVerified || !user.Active {
+		t.Errorf("SetEmailVerified: %+v", user)
+	}
+
+	if err := store.ActivateUser(ctx, invited); err != nil {
+		t.Fatalf("ActivateUser: %v", err)
+	}
+	if user := get(t, store, invited); !user.EmailVerified || !user.Active {
+		t.Errorf("ActivateUser: %+v", user)
+	}
+}
+
+func testDelete(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	id := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com", Roles: []string{userRole}})
+	kept := insert(t, store, User{Username: "bob", Password: "hash", Email: "bob@example.com"})
+
+	if err := store.DeleteUserById(ctx, id); err != nil {
+		t.Fatalf("DeleteUserById: %v", err)
+	}
+	if user, err := store.GetUserByID(ctx, id); err != nil || user != nil {
+		t.Errorf("GetUserByID удаленного: %+v, %v", user, err)
+	}
+	if user, err := store.GetUserByName(ctx, "alice"); err != nil || user != nil {
+		t.Errorf("GetUserByName удаленного: %+v, %v", user, err)
+	}
+	get(t, store, kept)
+
+	// Повторное удаление не ошибка
+	if err := store.DeleteUserById(ctx, id); err != nil {
+		t.Errorf("DeleteUserById удаленного: %v", err)
+	}
+}
+
+func testTxCommit(t *testing.T, store repository.UserStore) {
+	var id int64
+	err := store.WithTx(context.Background(), func(ctx context.Context) error {
+		var err error
+		if id, err = store.InsertUser(ctx, &User{Username: "alice", Password: "hash", Email: "alice@example.com"}); err != nil {
+			return err
+		}
+
+		// Вложенная транзакция присоединяется к внешней и видит ее изменения
+		return store.WithTx(ctx, func(ctx context.Context) error {
+			user, err := store.GetUserByName(ctx, "alice")
+			if err != nil {
+				return err
+			}
+			if user == nil || user.ID != id {
+				return fmt.Errorf("изменения транзакции не видны: %+v", user)
+			}
+			return store.SetEmailVerified(ctx, id)
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	if user := get(t, store, id); !user.EmailVerified {
+		t.Errorf("WithTx: изменения не подтверждены %+v", user)
+	}
+}
+
+func testTxRollback(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	id := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com"})
+
+	failure := errors.New("ошибка в транзакции")
+	err := store.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := store.InsertUser(ctx, &User{Username: "bob", Password: "hash", Email: "bob@example.com"}); err != nil {
+			return err
+		}
+		if err := store.UpdatePassword(ctx, id, "new-hash"); err != nil {
+			return err
+		}
+		if err := store.DeleteUserById(ctx, id); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithTx: ошибка %v, ожидалась %v", err, failure)
+	}
+
+	if user := get(t, store, id); user.Password != "hash" {
+		t.Errorf("WithTx: изменения не откачены %+v", user)
+	}
+	if user, err := store.GetUserByName(ctx, "bob"); err != nil || user != nil {
+		t.Errorf("WithTx: вставка не откачена %+v, %v", user, err)
+	}
+}
+
+func testConcurrentInserts(t *testing.T, store repository.UserStore) {
+	const count = 20
+
+	var wg sync.WaitGroup
+	ids := make([]int64, count)
+	errs := make([]error, count)
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("user%d", i)
+			ids[i], errs[i] = store.InsertUser(context.Background(), &User{Username: name, Password: "hash", Email: name + "@example.com"})
+		}()
+	}
+	wg.Wait()
+
+	seen := map[int64]bool{}
+	for i := range count {
+		if errs[i] != nil {
+			t.Fatalf("InsertUser: %v", errs[i])
+		}
+		if seen[ids[i]] {
+			t.Errorf("InsertUser: идентификатор %d повторяется", ids[i])
+		}
+		seen[ids[i]] = true
+	}
+
+	users, err := store.GetAllUsers(context.Background())
+	if err != nil || len(*users) != count {
+		t.Errorf("GetAllUsers: %v, %v", users, err)
+	}
+}
+
+func testConcurrentDuplicates(t *testing.T, store repository.UserStore) {
+	const count = 10
+
+	var wg sync.WaitGroup
+	errs := make([]error, count)
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = store.InsertUser(context.Background(), &User{Username: "alice", Password: "hash", Email: fmt.Sprintf("alice%d@example.com", i)})
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, repository.ErrDuplicateUsername):
+			t.Errorf("InsertUser: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("InsertUser: создано %d пользователей с одним логином", created)
+	}
+}
+
+func testLockUser(t *testing.T, store repository.UserStore) {
+	id := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com"})
+
+	// Параллельные транзакции читают и изменяют пользователя по очереди, ни одно изменение не теряется
+	const count = 10
+	var wg sync.WaitGroup
+	errs := make([]error, count)
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = store.WithTx(context.Background(), func(ctx context.Context) error {
+				user, err := store.LockUserByID(ctx, id)
+				if err != nil {
+					return err
+				}
+				if user == nil {
+					return fmt.Errorf("пользователь %d не найден", id)
+				}
+				return store.UpdatePassword(ctx, id, user.Password+"+")
+			})
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("WithTx: %v", err)
+		}
+	}
+	if user := get(t, store, id); len(user.Password) != len("hash")+count {
+		t.Errorf("LockUserByID: потеряны изменения, хеш %s", user.Password)
+	}
+
+	missing, err := store.LockUserByID(context.Background(), id+100)
+	if err != nil || missing != nil {
+		t.Errorf("LockUserByID отсутствующего: %+v, %v", missing, err)
+	}
+}
+
+func testCanceledContext(t *testing.T, store repository.UserStore) {
+	id := insert(t, store, User{Username: "alice", Password: "hash", Email: "alice@example.com"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := store.GetUserByID(ctx, id); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetUserByID с отмененным контекстом: %v", err)
+	}
+	if _, err := store.InsertUser(ctx, &User{Username: "bob", Password: "hash", Email: "bob@example.com"}); err == nil {
+		t.Errorf("InsertUser с отмененным контекстом выполнен")
+	}
+	if err := store.UpdatePassword(ctx, id, "new-hash"); err == nil {
+		t.Errorf("UpdatePassword с отмененным контекстом выполнен")
+	}
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	. "rest_module/model"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const webAuthnCredentialColumns = `"id", "user_id", "credential_id", "name", "public_key", "sign_count", "transports", "aaguid", "created_at", "last_used_at"`
+
+// Ключи доступа WebAuthn и вызовы церемоний
+type WebAuthnRepository struct {
+	Db *DBManager // база данных
+}
+
+func InitWebAuthnRepository(db *DBManager) *WebAuthnRepository {
+	repo := WebAuthnRepository{}
+	repo.Db = db
+	return &repo
+}
+
+func (repo *WebAuthnRepository) Database(ctx context.Context) Querier {
+	if repo.Db == nil {
+		panic("База данных не подключена!")
+	}
+
+	return repo.Db.Querier(ctx)
+}
+
+// Сохранение вызова церемонии; userID равен nil для входа без логина
+func (repo *WebAuthnRepository) InsertChallenge(ctx context.Context, userID *int64, purpose, challengeHash string, expiresAt time.Time) error {
+	// Истекшие вызовы уже не пройдут проверку и больше не нужны
+	deleteStmt := `delete from "webauthn_challenges" where "expires_at" < now()`
+	if _, err := repo.Database(ctx).ExecContext(ctx, deleteStmt); err != nil {
+		return err
+	}
+
+	insertStmt := `insert into "webauthn_challenges" ("user_id", "purpose", "challenge_hash", "expires_at") values($1, $2, $3, $4)`
+
+	_, err := repo.Database(ctx).ExecContext(ctx, insertStmt, userID, purpose, challengeHash, expiresAt)
+	return err
+}
+
+// Погашение действующего вызова; found равно false, если вызов не найден, истек или уже использован
+func (repo *WebAuthnRepository) ConsumeChallenge(ctx context.Context, purpose, challengeHash string) (userID *int64, found bool, err error) {
+	deleteStmt := `delete from "webauthn_challenges"
+		where "challenge_hash" = $1 and "purpose" = $2 and "expires_at" > now()
+		returning "user_id"`
+
+	err = repo.Database(ctx).QueryRowContext(ctx, deleteStmt, challengeHash, purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return userID, true, nil
+}
+
+// Идентификатор пользователя для аутентификаторов; handle сохраняется, если у пользователя еще нет идентификатора
+func (repo *WebAuthnRepository) GetOrInsertUserHandle(ctx context.Context, userID int64, handle []byte) ([]byte, error) {
+	// Пустое обновление при конфликте возвращает уже сохраненный идентификатор
+	upsertStmt := `insert into "webauthn_user_handles" ("user_id", "handle") values($1, $2)
+		on conflict ("user_id") do update set "user_id" = excluded."user_id"
+		returning "handle"`
+
+	var stored []byte
+	err := repo.Database(ctx).QueryRowContext(ctx, upsertStmt, userID, handle).Scan(&stored)
+	return stored, err
+}
+
+// Пользователь по идентификатору для аутентификаторов; 0, если идентификатор не выдавался
+func (repo *WebAuthnRepository) GetUserIDByHandle(ctx context.Context, handle []byte) (int64, error) {
+	selectStmt := `select "user_id" from "webauthn_user_handles" where "handle" = $1`
+
+	var userID int64
+	err := repo.Database(ctx).QueryRowContext(ctx, selectStmt, handle).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return userID, err
+}
+
+// Сохранение ключа доступа
+func (repo *WebAuthnRepository) InsertCredential(ctx context.Context, credential *WebAuthnCredential) (int64, error) {
+	insertStmt := `insert into "webauthn_credentials" ("user_id", "credential_id", "name", "public_key", "sign_count", "transports", "aaguid")
+		values($1, $2, $3, $4, $5, $6, $7) returning "id", "created_at"`
+
+	err := repo.Database(ctx).QueryRowContext(ctx, insertStmt, credential.UserID, credential.CredentialID, credential.Name, credential.PublicKey,
+		int64(credential.SignCount), pq.Array(credential.Transports), credential.AAGUID).Scan(&credential.ID, &credential.CreatedAt)
+	if err != nil {
+		return -1, err
+	}
+
+	return credential.ID, nil
+}
+
+// Поиск ключа доступа по идентификатору в base64url; nil, если ключ не найден
+func (repo *WebAuthnRepository) GetCredential(ctx context.Context, credentialID string) (*WebAuthnCredential, error) {
+	selectStmt := `select ` + webAuthnCredentialColumns + ` from "webauthn_credentials" where "credential_id" = $1`
+
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, credentialID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	return scanWebAuthnCredential(rows)
+}
+
+// Ключи доступа пользователя
+func (repo *WebAuthnRepository) GetUserCredentials(ctx context.Context, userID int64) (*[]WebAuthnCredential, error) {
+	selectStmt := `select ` + webAuthnCredentialColumns + ` from "webauthn_credentials" where "user_id" = $1 order by "id"`
+
+	rows, err := repo.Database(ctx).QueryContext(ctx, selectStmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []WebAuthnCredential{}
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials = append(credentials, *credential)
+	}
+
+	return &credentials, nil
+}
+
+// Сохранение счетчика подписей после входа. Счетчик меняется только вперед,
+// поэтому из двух одновременных входов с одним значением проходит один; false - счетчик уже изменен
+func (repo *WebAuthnRepository) UpdateSignCount(ctx context.Context, id int64, previous, signCount uint32) (bool, error) {
+	updateStmt := `update "webauthn_credentials" set "sign_count" = $1, "last_used_at" = now()
+		where "id" = $2 and "sign_count" = $3`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, updateStmt, int64(signCount), id, int64(previous))
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+// Удаление ключа доступа пользователя; false, если ключ не найден
+func (repo *WebAuthnRepository) DeleteCredential(ctx context.Context, userID, id int64) (bool, error) {
+	deleteStmt := `delete from "webauthn_credentials" where "id" = $1 and "user_id" = $2`
+
+	result, err := repo.Database(ctx).ExecContext(ctx, deleteStmt, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+func scanWebAuthnCredential(rows *sql.Rows) (*WebAuthnCredential, error) {
+	credential := WebAuthnCredential{}
+	var signCount int64
+	err := rows.Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.Name, &credential.PublicKey,
+		&signCount, pq.Array(&credential.Transports), &credential.AAGUID, &credential.CreatedAt, &credential.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	credential.SignCount = uint32(signCount)
+	return &credential, nil
+}
@@ -0,0 +1,109 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type apiKeyRequest struct {
+	ServiceName      string   `json:"service_name,omitempty"`
+	Name             string   `json:"name"`
+	Scopes           []string `json:"scopes"`
+	ExpiresInSeconds int64    `json:"expires_in,omitempty"`
+}
+
+func (req *apiKeyRequest) ttl() time.Duration {
+	return time.Duration(req.ExpiresInSeconds) * time.Second
+}
+
+// Endpoint списка ключей API пользователя
+func (api *API) UserAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	keys, err := api.apiKeys.UserKeys(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// Endpoint выпуска ключа API пользователя; ключ возвращается только в этом ответе
+func (api *API) IssueUserAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key, err := api.apiKeys.IssueUserKey(r.Context(), id, req.Name, req.Scopes, req.ttl())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// Endpoint отзыва ключа API пользователя
+func (api *API) RevokeUserAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	kid, err := pathID(r, "kid")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = api.apiKeys.RevokeUserKey(r.Context(), id, kid); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint списка ключей API сервисных учетных записей
+func (api *API) ServiceAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := api.apiKeys.ServiceKeys(r.Context())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// Endpoint выпуска ключа API сервисной учетной записи; ключ возвращается только в этом ответе
+func (api *API) IssueServiceAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key, err := api.apiKeys.IssueServiceKey(r.Context(), req.ServiceName, req.Name, req.Scopes, req.ttl())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// Endpoint отзыва ключа API сервисной учетной записи
+func (api *API) RevokeServiceAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	kid, err := pathID(r, "kid")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = api.apiKeys.RevokeServiceKey(r.Context(), kid); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,245 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"rest_module/repository"
+	"strconv"
+
+	. "rest_module/service"
+)
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type magicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Endpoint входа по логину и паролю
+func (api *API) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.auth.Login(r.Context(), req.Username, req.Password, clientInfo(r))
+	if err != nil {
+		writeAuthError(w, err)
+		go log.Println("Login", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Endpoint обмена токена обновления на новую пару токенов
+func (api *API) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	pair, err := api.auth.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeAuthError(w, err)
+		go log.Println("Refresh", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pair)
+}
+
+// Endpoint завершения текущей сессии
+func (api *API) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := api.auth.Logout(r.Context(), PrincipalFromContext(r.Context()), clientInfo(r)); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint запроса ссылки для сброса пароля
+func (api *API) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Ответ одинаков для известных и неизвестных адресов
+	api.auth.ForgotPassword(req.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Endpoint запроса ссылки для входа без пароля
+func (api *API) MagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var req magicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Ответ одинаков для известных и неизвестных адресов
+	if err := api.auth.RequestMagicLink(r.Context(), req.Email); err != nil {
+		writeAuthError(w, err)
+		go log.Println("MagicLink", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Endpoint входа по ссылке из письма; токен в параметре token или в теле запроса
+func (api *API) MagicLinkLoginHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var req verifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		token = req.Token
+	}
+
+	result, err := api.auth.LoginMagicLink(r.Context(), token, clientInfo(r))
+	if err != nil {
+		writeAuthError(w, err)
+		go log.Println("MagicLinkLogin", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Endpoint установки нового пароля по токену из письма
+func (api *API) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := api.auth.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		writeUserError(w, err)
+		go log.Println("ResetPassword", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint подтверждения адреса почты; токен в параметре token или в теле запроса
+func (api *API) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var req verifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		token = req.Token
+	}
+
+	user, err := api.userManager.VerifyEmail(r.Context(), token)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+// Endpoint повторной отправки письма подтверждения адреса почты
+func (api *API) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	if err := api.userManager.ResendEmailVerification(r.Context(), id); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Ответ с телом в формате JSON
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// Ошибка данных пользователя: нарушения парольной политики возвращаются списком
+func writeUserError(w http.ResponseWriter, err error) {
+	var policy *PasswordPolicyError
+	if errors.As(err, &policy) {
+		writeJSON(w, http.StatusBadRequest, policy)
+		return
+	}
+	writeError(w, err, http.StatusBadRequest)
+}
+
+// Статус ответа на запрос, отмененный клиентом до завершения; сам ответ клиент уже не получит
+const statusClientClosedRequest = 499
+
+// Ответ с ошибкой: истекший срок операции с БД - 504, недоступная БД или непроверенная парольная политика - 503,
+// отмена клиентом - 499, остальные ошибки - status
+func writeError(w http.ResponseWriter, err error, status int) {
+	switch {
+	case repository.IsCanceled(err):
+		http.Error(w, "Запрос отменен клиентом", statusClientClosedRequest)
+	case repository.IsTimeout(err):
+		http.Error(w, "Превышено время ожидания ответа базы данных", http.StatusGatewayTimeout)
+	case repository.IsUnavailable(err):
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "База данных временно недоступна", http.StatusServiceUnavailable)
+	case errors.Is(err, ErrPasswordCheckUnavailable):
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, ErrPasswordCheckUnavailable.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// Ошибки аутентификации отдаются как 401/403/429, недоступность БД как 503/504, остальные как 500
+func writeAuthError(w http.ResponseWriter, err error) {
+	var lockout *LockoutError
+	if errors.As(err, &lockout) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(lockout.RetryAfter.Seconds())+1))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrInvalidPasskey) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrUserDisabled) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	writeError(w, err, http.StatusInternalServerError)
+}
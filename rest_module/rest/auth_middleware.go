@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	. "rest_module/service"
+)
+
+type contextKey string
+
+const principalKey contextKey = "principal"
+
+// Проверка ключа API из заголовка X-API-Key или Authorization: Bearer umk_...
+func (api *API) apiKeyMiddleware(next http.Handler) http.Handler { // Для Gorilla Mux (http.Handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if token, ok := bearerToken(r); ok && IsAPIKey(token) {
+			key = token
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := api.apiKeys.Authorize(r.Context(), key)
+		if err != nil {
+			writeError(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Проверка токена доступа из заголовка Authorization: Bearer <token>
+func (api *API) authMiddleware(next http.Handler) http.Handler { // Для Gorilla Mux (http.Handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Субъект уже определен по ключу API
+		if PrincipalFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		principal, err := api.auth.Authorize(r.Context(), token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Субъект текущего запроса, установленный authMiddleware
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey).(*Principal)
+	return principal
+}
+
+// Числовой параметр пути запроса
+func pathID(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// Доступ только при наличии разрешения
+func (api *API) requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := PrincipalFromContext(r.Context())
+		if principal == nil || !principal.HasPermission(permission) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Доступ только к собственной записи пользователя {id}; по ключу API недоступен
+func (api *API) requireSelf(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := PrincipalFromContext(r.Context())
+		if principal == nil || !isSelf(r, principal) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Доступ к собственной записи пользователя {id} либо при наличии разрешения
+func (api *API) requireSelfOrPermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := PrincipalFromContext(r.Context())
+		if principal == nil || (!isSelf(r, principal) && !principal.HasPermission(permission)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Запрет действий с учетными данными и удаления учетной записи при входе от имени пользователя
+func (api *API) forbidImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := PrincipalFromContext(r.Context())
+		if principal == nil || principal.IsImpersonated() {
+			http.Error(w, "Действие недоступно при входе от имени пользователя", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Запрос к собственной записи {id}; ключ API действует только в пределах своих областей
+func isSelf(r *http.Request, principal *Principal) bool {
+	id, err := pathID(r, "id")
+	return err == nil && principal.UserID == id && principal.IsInteractive()
+}
@@ -0,0 +1,75 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	. "rest_module/service"
+)
+
+type impersonationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Endpoint входа администратора от имени пользователя
+func (api *API) ImpersonateHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	var req impersonationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.impersonation.Start(r.Context(), PrincipalFromContext(r.Context()), id, req.Reason, clientInfo(r))
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// Endpoint досрочного завершения входа от имени пользователя
+func (api *API) StopImpersonationHandler(w http.ResponseWriter, r *http.Request) {
+	iid, err := pathID(r, "iid")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = api.impersonation.Stop(r.Context(), PrincipalFromContext(r.Context()), iid, clientInfo(r)); err != nil {
+		if errors.Is(err, ErrForbidden) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint журнала аудита; фильтры user_id и action, размер выдачи limit
+func (api *API) AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var userID int64
+	if value := query.Get("user_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		userID = id
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	events, err := api.audit.Events(r.Context(), userID, query.Get("action"), limit)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type invitationRequest struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles"`
+}
+
+// Endpoint списка приглашений; фильтр по статусу в параметре status
+func (api *API) InvitationListHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := api.invitations.ListInvitations(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, invitations)
+}
+
+// Endpoint приглашения пользователя
+func (api *API) CreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req invitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var invitedBy int64
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		invitedBy = principal.UserID
+	}
+
+	invitation, err := api.invitations.Invite(r.Context(), req.Username, req.Email, req.Roles, invitedBy)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, invitation)
+}
+
+// Endpoint повторной отправки приглашения
+func (api *API) ResendInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	invitation, err := api.invitations.Resend(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, invitation)
+}
+
+// Endpoint отзыва приглашения
+func (api *API) RevokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	if err := api.invitations.Revoke(r.Context(), id); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint принятия приглашения с установкой пароля
+func (api *API) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := api.invitations.Accept(r.Context(), req.Token, req.Password)
+	if err != nil {
+		writeUserError(w, err)
+		go log.Println("AcceptInvitation", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,40 @@
+package rest
+
+import (
+	"net/http"
+)
+
+// Endpoint состояния блокировки входа пользователя
+func (api *API) LockStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	user, err := api.userManager.FindUserById(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	status, err := api.guard.Status(r.Context(), user.Username)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Endpoint снятия блокировки входа пользователя
+func (api *API) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	user, err := api.userManager.FindUserById(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = api.guard.Unlock(r.Context(), user.Username); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -46,16 +45,47 @@ type API struct {
 	r               *mux.Router  // маршрутизатор запросов
 	userManager     *UserManager // сервис пользователей
 	integration     *IntegrationService
+	auth            *AuthService             // сервис аутентификации
+	roles           *RoleManager             // сервис ролей
+	sessions        *SessionManager          // сервис сессий
+	mfa             *MFAManager              // двухфакторная аутентификация
+	guard           *LoginGuard              // защита от подбора пароля
+	apiKeys         *APIKeyManager           // ключи API
+	oauth           *OAuthServer             // сервер авторизации OAuth2
+	keys            *KeyManager              // ключи подписи токенов
+	saml            *SAMLServiceProvider     // вход через SAML
+	scim            *SCIMService             // провижининг по SCIM
+	invitations     *InvitationService       // приглашения пользователей
+	webauthn        *WebAuthnManager         // ключи доступа WebAuthn
+	impersonation   *ImpersonationService    // вход администратора от имени пользователя
+	audit           *AuditLog                // журнал аудита
 	totalRequests   *prometheus.CounterVec   // счетчик запросов
 	requestDuration *prometheus.HistogramVec // метрика длительности запросов
 	limiter         *rate.Limiter
 }
 
 // Конструктор API.
-func ApiNewInstance(userManager *UserManager, integration *IntegrationService) *API {
+func ApiNewInstance(userManager *UserManager, integration *IntegrationService, auth *AuthService, roles *RoleManager, sessions *SessionManager,
+	mfa *MFAManager, guard *LoginGuard, apiKeys *APIKeyManager, oauth *OAuthServer,
+	keys *KeyManager, saml *SAMLServiceProvider, scim *SCIMService, invitations *InvitationService,
+	webauthn *WebAuthnManager, impersonation *ImpersonationService, audit *AuditLog) *API {
 	api := API{}
 	api.userManager = userManager
 	api.integration = integration
+	api.auth = auth
+	api.roles = roles
+	api.sessions = sessions
+	api.mfa = mfa
+	api.guard = guard
+	api.apiKeys = apiKeys
+	api.oauth = oauth
+	api.keys = keys
+	api.saml = saml
+	api.scim = scim
+	api.invitations = invitations
+	api.webauthn = webauthn
+	api.impersonation = impersonation
+	api.audit = audit
 	api.r = mux.NewRouter()
 	api.endpoints()
 	api.totalRequests = prometheus.NewCounterVec( // Consistent имя
@@ -107,23 +137,121 @@ func (api *API) rateLimitMiddleware(next http.Handler) http.Handler { // Для
 
 // Регистрация методов API в маршрутизаторе запросов.
 func (api *API) endpoints() {
+	// Public routes
+	public := api.Router().NewRoute().Subrouter()
+	public.Use(api.metricsMiddleware)
+	public.Use(api.rateLimitMiddleware)
+
+	public.HandleFunc("/health", api.healthHandler).Methods(http.MethodGet)
+	public.Handle("/prometheus", promhttp.Handler()).Methods(http.MethodGet)
+
+	public.HandleFunc("/api/auth/login", api.LoginHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/login/mfa", api.LoginMFAHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/login/mfa/webauthn/options", api.MFAPasskeyOptionsHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/login/mfa/webauthn", api.LoginMFAPasskeyHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/passkey/options", api.PasskeyOptionsHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/passkey", api.LoginPasskeyHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/refresh", api.RefreshHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/password/forgot", api.ForgotPasswordHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/password/reset", api.ResetPasswordHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/email/verify", api.VerifyEmailHandler).Methods(http.MethodGet, http.MethodPost)
+	public.HandleFunc("/api/auth/magic-link", api.MagicLinkHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/auth/magic-link/verify", api.MagicLinkLoginHandler).Methods(http.MethodGet, http.MethodPost)
+	public.HandleFunc("/api/users", api.RegisterUserHandler).Methods(http.MethodPost)
+	public.HandleFunc("/api/invitations/accept", api.AcceptInvitationHandler).Methods(http.MethodPost)
+
+	public.HandleFunc("/oauth/authorize", api.OAuthAuthorizeHandler).Methods(http.MethodGet, http.MethodPost)
+	public.HandleFunc("/oauth/token", api.OAuthTokenHandler).Methods(http.MethodPost)
+	public.HandleFunc("/oauth/introspect", api.OAuthIntrospectHandler).Methods(http.MethodPost)
+	public.HandleFunc("/oauth/revoke", api.OAuthRevokeHandler).Methods(http.MethodPost)
+
+	public.HandleFunc("/.well-known/openid-configuration", api.DiscoveryHandler).Methods(http.MethodGet)
+	public.HandleFunc("/jwks.json", api.JWKSHandler).Methods(http.MethodGet)
+	public.HandleFunc("/saml/metadata", api.SAMLMetadataHandler).Methods(http.MethodGet)
+	public.HandleFunc("/saml/login", api.SAMLLoginHandler).Methods(http.MethodGet)
+	public.HandleFunc("/saml/acs", api.SAMLACSHandler).Methods(http.MethodPost)
+	public.HandleFunc("/scim/v2/ServiceProviderConfig", api.SCIMServiceProviderConfigHandler).Methods(http.MethodGet)
+	public.HandleFunc("/scim/v2/ResourceTypes", api.SCIMResourceTypesHandler).Methods(http.MethodGet)
+	public.HandleFunc("/scim/v2/ResourceTypes/{name}", api.SCIMResourceTypeHandler).Methods(http.MethodGet)
+	public.HandleFunc("/scim/v2/Schemas", api.SCIMSchemasHandler).Methods(http.MethodGet)
+	public.HandleFunc("/scim/v2/Schemas/{id}", api.SCIMSchemaHandler).Methods(http.MethodGet)
+
 	// Protected routes
-	router := api.Router().PathPrefix("/").Subrouter()
+	router := api.Router().NewRoute().Subrouter()
 	router.Use(api.metricsMiddleware)
 	router.Use(api.rateLimitMiddleware)
-
-	// Public routes
-	router.HandleFunc("/health", api.healthHandler).Methods(http.MethodGet)
-	router.Handle("/prometheus", promhttp.Handler()).Methods(http.MethodGet)
-
-	router.HandleFunc("/api/users", api.UserListHandler).Methods(http.MethodGet)
-	router.HandleFunc("/api/users/{id:[0-9]+}", api.UserInfoHandler).Methods(http.MethodGet)
-	router.HandleFunc("/api/users", api.RegisterUserHandler).Methods(http.MethodPost)
-	router.HandleFunc("/api/users/{id:[0-9]+}", api.UserUpdateHandler).Methods(http.MethodPut)
-	router.HandleFunc("/api/users/{id:[0-9]+}", api.UserDeleteHandler).Methods(http.MethodDelete)
-
-	router.HandleFunc("/storage/objects", api.UploadObject).Methods(http.MethodPost)
-	router.HandleFunc("/storage/presign", api.GetPresignedURL).Methods(http.MethodPost)
+	router.Use(api.apiKeyMiddleware)
+	router.Use(api.authMiddleware)
+
+	router.HandleFunc("/api/auth/logout", api.LogoutHandler).Methods(http.MethodPost)
+	router.HandleFunc("/userinfo", api.OIDCUserInfoHandler).Methods(http.MethodGet, http.MethodPost)
+
+	router.HandleFunc("/api/users", api.requirePermission(PermissionUsersRead, api.UserListHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{id:[0-9]+}", api.requireSelfOrPermission(PermissionUsersRead, api.UserInfoHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{id:[0-9]+}", api.requireSelfOrPermission(PermissionUsersWrite, api.forbidImpersonation(api.UserUpdateHandler))).Methods(http.MethodPut)
+	router.HandleFunc("/api/users/{id:[0-9]+}", api.requirePermission(PermissionUsersDelete, api.forbidImpersonation(api.UserDeleteHandler))).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/users/{id:[0-9]+}/email/verification", api.requireSelfOrPermission(PermissionUsersWrite, api.ResendVerificationHandler)).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/users/{id:[0-9]+}/mfa/totp", api.requireSelf(api.forbidImpersonation(api.EnrollTOTPHandler))).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{id:[0-9]+}/mfa/totp/confirm", api.requireSelf(api.forbidImpersonation(api.ConfirmTOTPHandler))).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{id:[0-9]+}/mfa/totp", api.requireSelfOrPermission(PermissionUsersWrite, api.forbidImpersonation(api.DisableTOTPHandler))).Methods(http.MethodDelete)
+	router.HandleFunc("/api/users/{id:[0-9]+}/mfa/recovery-codes", api.requireSelf(api.forbidImpersonation(api.RecoveryCodesHandler))).Methods(http.MethodPost)
+
+	router.HandleFunc("/api/users/{id:[0-9]+}/webauthn/credentials", api.requireSelfOrPermission(PermissionUsersRead, api.WebAuthnCredentialsHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{id:[0-9]+}/webauthn/credentials/options", api.requireSelf(api.forbidImpersonation(api.WebAuthnRegistrationOptionsHandler))).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{id:[0-9]+}/webauthn/credentials", api.requireSelf(api.forbidImpersonation(api.RegisterWebAuthnCredentialHandler))).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{id:[0-9]+}/webauthn/credentials/{cid:[0-9]+}", api.requireSelfOrPermission(PermissionUsersWrite, api.forbidImpersonation(api.RemoveWebAuthnCredentialHandler))).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/users/{id:[0-9]+}/lock", api.requirePermission(PermissionUsersRead, api.LockStatusHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{id:[0-9]+}/lock", api.requirePermission(PermissionUsersWrite, api.UnlockHandler)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/users/{id:[0-9]+}/impersonate", api.requirePermission(PermissionUsersImpersonate, api.forbidImpersonation(api.ImpersonateHandler))).Methods(http.MethodPost)
+	// Права на завершение проверяет сервис: токен входа завершает сам себя, администратор - начатые им входы
+	router.HandleFunc("/api/impersonations/{iid:[0-9]+}", api.StopImpersonationHandler).Methods(http.MethodDelete)
+	router.HandleFunc("/api/audit-log", api.requirePermission(PermissionAuditRead, api.AuditLogHandler)).Methods(http.MethodGet)
+
+	router.HandleFunc("/api/roles", api.requirePermission(PermissionRolesManage, api.RoleListHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{id:[0-9]+}/roles", api.requireSelfOrPermission(PermissionUsersRead, api.UserRolesHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{id:[0-9]+}/roles", api.requirePermission(PermissionRolesManage, api.AssignRoleHandler)).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{id:[0-9]+}/roles/{role}", api.requirePermission(PermissionRolesManage, api.RemoveRoleHandler)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/users/{id:[0-9]+}/sessions", api.requireSelfOrPermission(PermissionUsersRead, api.UserSessionsHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{id:[0-9]+}/sessions", api.requireSelfOrPermission(PermissionUsersWrite, api.forbidImpersonation(api.RevokeAllSessionsHandler))).Methods(http.MethodDelete)
+	router.HandleFunc("/api/users/{id:[0-9]+}/sessions/{sid:[0-9]+}", api.requireSelfOrPermission(PermissionUsersWrite, api.RevokeSessionHandler)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/users/{id:[0-9]+}/api-keys", api.requireSelfOrPermission(PermissionUsersRead, api.UserAPIKeysHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/{id:[0-9]+}/api-keys", api.requireSelf(api.forbidImpersonation(api.IssueUserAPIKeyHandler))).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/{id:[0-9]+}/api-keys/{kid:[0-9]+}", api.requireSelfOrPermission(PermissionUsersWrite, api.RevokeUserAPIKeyHandler)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/api-keys", api.requirePermission(PermissionAPIKeysManage, api.ServiceAPIKeysHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/api-keys", api.requirePermission(PermissionAPIKeysManage, api.IssueServiceAPIKeyHandler)).Methods(http.MethodPost)
+	router.HandleFunc("/api/api-keys/{kid:[0-9]+}", api.requirePermission(PermissionAPIKeysManage, api.RevokeServiceAPIKeyHandler)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/invitations", api.requirePermission(PermissionInvitationsManage, api.InvitationListHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/invitations", api.requirePermission(PermissionInvitationsManage, api.CreateInvitationHandler)).Methods(http.MethodPost)
+	router.HandleFunc("/api/invitations/{id:[0-9]+}/resend", api.requirePermission(PermissionInvitationsManage, api.ResendInvitationHandler)).Methods(http.MethodPost)
+	router.HandleFunc("/api/invitations/{id:[0-9]+}", api.requirePermission(PermissionInvitationsManage, api.RevokeInvitationHandler)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/api/oauth/clients", api.requirePermission(PermissionOAuthClientsManage, api.OAuthClientListHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/api/oauth/clients", api.requirePermission(PermissionOAuthClientsManage, api.RegisterOAuthClientHandler)).Methods(http.MethodPost)
+	router.HandleFunc("/api/oauth/clients/{client_id}", api.requirePermission(PermissionOAuthClientsManage, api.RevokeOAuthClientHandler)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/scim/v2/Users", api.requirePermission(PermissionSCIMProvision, api.SCIMUserListHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/scim/v2/Users", api.requirePermission(PermissionSCIMProvision, api.SCIMCreateUserHandler)).Methods(http.MethodPost)
+	router.HandleFunc("/scim/v2/Users/{id}", api.requirePermission(PermissionSCIMProvision, api.SCIMUserHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/scim/v2/Users/{id}", api.requirePermission(PermissionSCIMProvision, api.SCIMReplaceUserHandler)).Methods(http.MethodPut)
+	router.HandleFunc("/scim/v2/Users/{id}", api.requirePermission(PermissionSCIMProvision, api.SCIMPatchUserHandler)).Methods(http.MethodPatch)
+	router.HandleFunc("/scim/v2/Users/{id}", api.requirePermission(PermissionSCIMProvision, api.SCIMDeleteUserHandler)).Methods(http.MethodDelete)
+	router.HandleFunc("/scim/v2/Groups", api.requirePermission(PermissionSCIMProvision, api.SCIMGroupListHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/scim/v2/Groups", api.requirePermission(PermissionSCIMProvision, api.SCIMCreateGroupHandler)).Methods(http.MethodPost)
+	router.HandleFunc("/scim/v2/Groups/{id}", api.requirePermission(PermissionSCIMProvision, api.SCIMGroupHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/scim/v2/Groups/{id}", api.requirePermission(PermissionSCIMProvision, api.SCIMReplaceGroupHandler)).Methods(http.MethodPut)
+	router.HandleFunc("/scim/v2/Groups/{id}", api.requirePermission(PermissionSCIMProvision, api.SCIMPatchGroupHandler)).Methods(http.MethodPatch)
+	router.HandleFunc("/scim/v2/Groups/{id}", api.requirePermission(PermissionSCIMProvision, api.SCIMDeleteGroupHandler)).Methods(http.MethodDelete)
+
+	router.HandleFunc("/storage/objects", api.requirePermission(PermissionStorageWrite, api.UploadObject)).Methods(http.MethodPost)
+	router.HandleFunc("/storage/presign", api.requirePermission(PermissionStorageRead, api.GetPresignedURL)).Methods(http.MethodPost)
 }
 
 // Router возвращает маршрутизатор запросов.
@@ -141,7 +269,7 @@ func (api *API) RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Проверяем наличие ошибок
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
@@ -151,14 +279,14 @@ func (api *API) RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Проверяем наличие ошибок
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	user, err := api.userManager.AddUser(request.Username, request.Password, request.Email)
+	user, err := api.userManager.AddUser(r.Context(), request.Username, request.Password, request.Email)
 	// Проверяем наличие ошибок
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeUserError(w, err)
 		return
 	}
 
@@ -168,9 +296,9 @@ func (api *API) RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
 
 // Endpoint списка счетов пользователя
 func (api *API) UserListHandler(w http.ResponseWriter, r *http.Request) {
-	users, err := api.userManager.FindAllUsers()
+	users, err := api.userManager.FindAllUsers(r.Context())
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
@@ -180,10 +308,10 @@ func (api *API) UserListHandler(w http.ResponseWriter, r *http.Request) {
 
 // Endpoint информации о пользователе
 func (api *API) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(r.Context().Value("id").(string))
-	user, err := api.userManager.FindUserById(int64(id))
+	id, _ := pathID(r, "id")
+	user, err := api.userManager.FindUserById(r.Context(), id)
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
@@ -193,10 +321,10 @@ func (api *API) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
 
 // Endpoint обновления информации о пользователе
 func (api *API) UserUpdateHandler(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(r.Context().Value("id").(string))
-	user, err := api.userManager.FindUserById(int64(id))
+	id, _ := pathID(r, "id")
+	user, err := api.userManager.FindUserById(r.Context(), id)
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
@@ -208,7 +336,7 @@ func (api *API) UserUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Проверяем наличие ошибок
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
@@ -218,14 +346,14 @@ func (api *API) UserUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Проверяем наличие ошибок
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	user, err = api.userManager.UpdateUser(int64(id), request.Username, request.Password, request.Email)
+	user, err = api.userManager.UpdateUser(r.Context(), id, request.Username, request.Password, request.Email)
 	// Проверяем наличие ошибок
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeUserError(w, err)
 		return
 	}
 
@@ -236,10 +364,10 @@ func (api *API) UserUpdateHandler(w http.ResponseWriter, r *http.Request) {
 // Endpoint удаления пользователя
 func (api *API) UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	// api.totalRequests.WithLabelValues("delete_user_label").Inc()
-	id, _ := strconv.Atoi(r.Context().Value("id").(string))
-	err := api.userManager.DeleteUserById(int64(id))
+	id, _ := pathID(r, "id")
+	err := api.userManager.DeleteUserById(r.Context(), id)
 	if err != nil {
-		go http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 
@@ -249,7 +377,7 @@ func (api *API) UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 func (api *API) UploadObject(w http.ResponseWriter, r *http.Request) {
 	var req uploadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		go log.Println("UploadObject", err)
 		return
 	}
@@ -257,7 +385,7 @@ func (api *API) UploadObject(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 	info, err := api.integration.UploadObject(ctx, req.Bucket, req.ObjectName, []byte(req.Content), req.ContentType)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		go log.Println("UploadObject", err)
 		return
 	}
@@ -276,7 +404,7 @@ func (api *API) UploadObject(w http.ResponseWriter, r *http.Request) {
 func (api *API) GetPresignedURL(w http.ResponseWriter, r *http.Request) {
 	var req presignRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		go log.Println("GetPresignedURL", err)
 		return
 	}
@@ -285,7 +413,7 @@ func (api *API) GetPresignedURL(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 	url, err := api.integration.PresignedURL(ctx, req.Bucket, req.ObjectName, expiry)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 		go log.Println("GetPresignedURL", err)
 		return
 	}
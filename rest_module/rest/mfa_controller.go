@@ -0,0 +1,104 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type loginMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Endpoint второго шага входа
+func (api *API) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var req loginMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	pair, err := api.auth.LoginMFA(r.Context(), req.MFAToken, req.Code, clientInfo(r))
+	if err != nil {
+		writeAuthError(w, err)
+		go log.Println("LoginMFA", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pair)
+}
+
+// Endpoint начала подключения TOTP
+func (api *API) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	enrollment, err := api.mfa.EnrollTOTP(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, enrollment)
+}
+
+// Endpoint подтверждения подключения TOTP
+func (api *API) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := api.mfa.ConfirmTOTP(r.Context(), id, req.Code)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Endpoint отключения TOTP; сам пользователь подтверждает отключение кодом
+func (api *API) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	if PrincipalFromContext(r.Context()).UserID == id {
+		var req mfaCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := api.mfa.Verify(r.Context(), id, req.Code); err != nil {
+			writeError(w, err, http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := api.mfa.DisableTOTP(r.Context(), id); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint выпуска нового набора кодов восстановления
+func (api *API) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	codes, err := api.mfa.RegenerateRecoveryCodes(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
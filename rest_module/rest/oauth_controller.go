@@ -0,0 +1,239 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	. "rest_module/model"
+	. "rest_module/service"
+)
+
+type oauthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+	Confidential bool     `json:"confidential"`
+}
+
+// Страница входа и согласия на доступ клиента
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Вход</title></head>
+<body>
+<h1>Вход в {{.Client}}</h1>
+{{if .Scopes}}<p>Приложение запрашивает доступ: {{range .Scopes}}<code>{{.}}</code> {{end}}</p>{{end}}
+{{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+<form method="post">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<p><label>Логин <input name="username" value="{{.Username}}" autocomplete="username" required></label></p>
+<p><label>Пароль <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><label>Код подтверждения <input name="otp" autocomplete="one-time-code"></label></p>
+<p><button type="submit">Разрешить</button></p>
+</form>
+</body>
+</html>
+`))
+
+type authorizeView struct {
+	Client   string
+	Scopes   []string
+	Params   map[string]string
+	Username string
+	Error    string
+}
+
+// Endpoint авторизации OAuth2: GET показывает страницу входа, POST проверяет учетные данные
+func (api *API) OAuthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	req := AuthorizeRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Nonce:               r.Form.Get("nonce"),
+	}
+
+	ctx, err := api.oauth.ValidateAuthorize(r.Context(), &req)
+	if ctx == nil {
+		// Клиенту нельзя доверять адрес возврата, ошибка показывается пользователю
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		http.Redirect(w, r, ctx.ErrorRedirect(oauthErr), http.StatusFound)
+		return
+	}
+
+	view := authorizeView{
+		Client: ctx.Client.Name,
+		Scopes: ctx.Scopes,
+		Params: map[string]string{
+			"response_type":         req.ResponseType,
+			"client_id":             req.ClientID,
+			"redirect_uri":          req.RedirectURI,
+			"scope":                 req.Scope,
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": req.CodeChallengeMethod,
+			"nonce":                 req.Nonce,
+		},
+	}
+
+	if r.Method == http.MethodPost {
+		view.Username = r.PostForm.Get("username")
+		target, err := api.oauth.Authorize(r.Context(), &req, view.Username, r.PostForm.Get("password"), r.PostForm.Get("otp"), clientInfo(r))
+		if err == nil {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+
+		go log.Println("OAuthAuthorize", err)
+		view.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	authorizePage.Execute(w, &view)
+}
+
+// Endpoint выпуска токенов OAuth2
+func (api *API) OAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	client, ok := api.oauthClient(w, r)
+	if !ok {
+		return
+	}
+
+	pair, err := api.oauth.Token(r.Context(), client, r.PostForm, clientInfo(r))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, pair)
+}
+
+// Endpoint интроспекции токена (RFC 7662)
+func (api *API) OAuthIntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	client, ok := api.oauthClient(w, r)
+	if !ok {
+		return
+	}
+
+	introspection, err := api.oauth.Introspect(r.Context(), client, r.PostForm.Get("token"))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, introspection)
+}
+
+// Endpoint отзыва токена (RFC 7009)
+func (api *API) OAuthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	client, ok := api.oauthClient(w, r)
+	if !ok {
+		return
+	}
+
+	if err := api.oauth.Revoke(r.Context(), client, r.PostForm.Get("token")); err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Endpoint списка клиентов OAuth2
+func (api *API) OAuthClientListHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := api.oauth.FindAllClients(r.Context())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, clients)
+}
+
+// Endpoint регистрации клиента OAuth2; секрет возвращается только в этом ответе
+func (api *API) RegisterOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var req oauthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	client, err := api.oauth.RegisterClient(r.Context(), req.Name, req.RedirectURIs, req.GrantTypes, req.Scopes, req.Confidential)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, client)
+}
+
+// Endpoint отзыва клиента OAuth2
+func (api *API) RevokeOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	if err := api.oauth.RevokeClient(r.Context(), mux.Vars(r)["client_id"]); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Аутентификация клиента по HTTP Basic или параметрам client_id/client_secret
+func (api *API) oauthClient(w http.ResponseWriter, r *http.Request) (*OAuthClient, bool) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &OAuthError{Code: OAuthInvalidRequest, Description: err.Error()})
+		return nil, false
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	client, err := api.oauth.AuthenticateClient(r.Context(), clientID, clientSecret)
+	if err != nil {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeOAuthError(w, err)
+		return nil, false
+	}
+
+	return client, true
+}
+
+// Ошибки протокола отдаются в формате RFC 6749, раздел 5.2
+func writeOAuthError(w http.ResponseWriter, err error) {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		go log.Println("OAuth", err)
+		writeJSON(w, http.StatusInternalServerError, &OAuthError{Code: "server_error"})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == OAuthInvalidClient {
+		status = http.StatusUnauthorized
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, oauthErr)
+}
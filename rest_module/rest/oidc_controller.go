@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"net/http"
+)
+
+// Endpoint описания провайдера OpenID Connect
+func (api *API) DiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	metadata, err := api.oauth.Discovery(r.Context())
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+// Endpoint открытых ключей подписи токенов
+func (api *API) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	// Короткий срок кеширования, чтобы новые ключи после ротации подхватывались быстро
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, api.keys.JWKS())
+}
+
+// Endpoint сведений о пользователе по токену доступа
+func (api *API) OIDCUserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := api.oauth.UserInfo(r.Context(), PrincipalFromContext(r.Context()))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		writeError(w, err, http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
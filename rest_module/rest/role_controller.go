@@ -0,0 +1,65 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// Endpoint списка ролей с разрешениями
+func (api *API) RoleListHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := api.roles.FindAllRoles(r.Context())
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roles)
+}
+
+// Endpoint ролей пользователя
+func (api *API) UserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	roles, err := api.roles.UserRoles(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roles)
+}
+
+// Endpoint назначения роли пользователю
+func (api *API) AssignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	roles, err := api.roles.AssignRole(r.Context(), id, req.Role)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roles)
+}
+
+// Endpoint снятия роли с пользователя
+func (api *API) RemoveRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	roles, err := api.roles.RemoveRole(r.Context(), id, mux.Vars(r)["role"])
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roles)
+}
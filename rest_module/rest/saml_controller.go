@@ -0,0 +1,69 @@
+package rest
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	. "rest_module/service"
+)
+
+type samlLoginResponse struct {
+	*LoginResult
+	RelayState string `json:"relay_state,omitempty"`
+}
+
+// Endpoint метаданных поставщика услуг SAML
+func (api *API) SAMLMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	metadata, err := api.saml.Metadata()
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(metadata)
+}
+
+// Endpoint перехода на страницу входа IdP
+func (api *API) SAMLLoginHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := api.saml.LoginURL(r.Context(), r.URL.Query().Get("relay_state"))
+	if err != nil {
+		writeSAMLError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// Endpoint приема ответа IdP (Assertion Consumer Service)
+func (api *API) SAMLACSHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.saml.Login(r.Context(), r.PostForm.Get("SAMLResponse"), clientInfo(r))
+	if err != nil {
+		go log.Println("SAMLLogin", err)
+		writeSAMLError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, &samlLoginResponse{LoginResult: result, RelayState: r.PostForm.Get("RelayState")})
+}
+
+func writeSAMLError(w http.ResponseWriter, err error) {
+	var samlErr *SAMLError
+	switch {
+	case errors.Is(err, ErrSAMLDisabled):
+		writeError(w, err, http.StatusNotFound)
+	case errors.As(err, &samlErr):
+		writeError(w, err, http.StatusUnauthorized)
+	default:
+		writeError(w, err, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,258 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	. "rest_module/service"
+)
+
+// Размер страницы SCIM по умолчанию
+const scimDefaultCount = 100
+
+// Endpoint поиска пользователей SCIM
+func (api *API) SCIMUserListHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := api.scim.ListUsers(r.Context(), scimSearch(r))
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, list)
+}
+
+// Endpoint пользователя SCIM
+func (api *API) SCIMUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := api.scim.GetUser(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, user)
+}
+
+// Endpoint создания пользователя SCIM
+func (api *API) SCIMCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var resource SCIMUser
+	if !readSCIM(w, r, &resource) {
+		return
+	}
+
+	user, err := api.scim.CreateUser(r.Context(), &resource)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", user.Meta.Location)
+	writeSCIM(w, http.StatusCreated, user)
+}
+
+// Endpoint замены пользователя SCIM
+func (api *API) SCIMReplaceUserHandler(w http.ResponseWriter, r *http.Request) {
+	var resource SCIMUser
+	if !readSCIM(w, r, &resource) {
+		return
+	}
+
+	user, err := api.scim.ReplaceUser(r.Context(), mux.Vars(r)["id"], &resource)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, user)
+}
+
+// Endpoint частичного изменения пользователя SCIM
+func (api *API) SCIMPatchUserHandler(w http.ResponseWriter, r *http.Request) {
+	var patch SCIMPatchRequest
+	if !readSCIM(w, r, &patch) {
+		return
+	}
+
+	user, err := api.scim.PatchUser(r.Context(), mux.Vars(r)["id"], &patch)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, user)
+}
+
+// Endpoint удаления пользователя SCIM
+func (api *API) SCIMDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	if err := api.scim.DeleteUser(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint поиска групп SCIM
+func (api *API) SCIMGroupListHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := api.scim.ListGroups(r.Context(), scimSearch(r))
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, list)
+}
+
+// Endpoint группы SCIM
+func (api *API) SCIMGroupHandler(w http.ResponseWriter, r *http.Request) {
+	group, err := api.scim.GetGroup(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, group)
+}
+
+// Endpoint создания группы SCIM
+func (api *API) SCIMCreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var resource SCIMGroup
+	if !readSCIM(w, r, &resource) {
+		return
+	}
+
+	group, err := api.scim.CreateGroup(r.Context(), &resource)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", group.Meta.Location)
+	writeSCIM(w, http.StatusCreated, group)
+}
+
+// Endpoint замены группы SCIM
+func (api *API) SCIMReplaceGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var resource SCIMGroup
+	if !readSCIM(w, r, &resource) {
+		return
+	}
+
+	group, err := api.scim.ReplaceGroup(r.Context(), mux.Vars(r)["id"], &resource)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, group)
+}
+
+// Endpoint частичного изменения группы SCIM
+func (api *API) SCIMPatchGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var patch SCIMPatchRequest
+	if !readSCIM(w, r, &patch) {
+		return
+	}
+
+	group, err := api.scim.PatchGroup(r.Context(), mux.Vars(r)["id"], &patch)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, group)
+}
+
+// Endpoint удаления группы SCIM
+func (api *API) SCIMDeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if err := api.scim.DeleteGroup(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint возможностей сервиса SCIM
+func (api *API) SCIMServiceProviderConfigHandler(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, api.scim.ServiceProviderConfig())
+}
+
+// Endpoint типов ресурсов SCIM
+func (api *API) SCIMResourceTypesHandler(w http.ResponseWriter, r *http.Request) {
+	resourceTypes := api.scim.ResourceTypes()
+	writeSCIM(w, http.StatusOK, &SCIMListResponse{Schemas: []string{SCIMSchemaListResponse}, TotalResults: len(resourceTypes),
+		StartIndex: 1, ItemsPerPage: len(resourceTypes), Resources: resourceTypes})
+}
+
+// Endpoint типа ресурса SCIM
+func (api *API) SCIMResourceTypeHandler(w http.ResponseWriter, r *http.Request) {
+	resourceType, err := api.scim.ResourceType(mux.Vars(r)["name"])
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, resourceType)
+}
+
+// Endpoint схем SCIM
+func (api *API) SCIMSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	schemas := api.scim.Schemas()
+	writeSCIM(w, http.StatusOK, &SCIMListResponse{Schemas: []string{SCIMSchemaListResponse}, TotalResults: len(schemas),
+		StartIndex: 1, ItemsPerPage: len(schemas), Resources: schemas})
+}
+
+// Endpoint схемы SCIM
+func (api *API) SCIMSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	schema, err := api.scim.Schema(mux.Vars(r)["id"])
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, schema)
+}
+
+// Параметры поиска из строки запроса
+func scimSearch(r *http.Request) *SCIMSearch {
+	query := r.URL.Query()
+	search := SCIMSearch{Filter: query.Get("filter"), StartIndex: 1, Count: scimDefaultCount, ExcludedAttributes: query.Get("excludedAttributes")}
+	if value, err := strconv.Atoi(query.Get("startIndex")); err == nil {
+		search.StartIndex = value
+	}
+	if value, err := strconv.Atoi(query.Get("count")); err == nil {
+		search.Count = value
+	}
+
+	return &search
+}
+
+func readSCIM(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		writeSCIMError(w, &SCIMError{Schemas: []string{SCIMSchemaError}, Status: "400", SCIMType: "invalidSyntax",
+			Detail: err.Error(), HTTPStatus: http.StatusBadRequest})
+		return false
+	}
+
+	return true
+}
+
+func writeSCIM(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeSCIMError(w http.ResponseWriter, err error) {
+	var scimErr *SCIMError
+	if !errors.As(err, &scimErr) {
+		scimErr = &SCIMError{Schemas: []string{SCIMSchemaError}, Status: "400", Detail: err.Error(), HTTPStatus: http.StatusBadRequest}
+	}
+
+	writeSCIM(w, scimErr.HTTPStatus, scimErr)
+}
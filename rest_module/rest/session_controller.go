@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	. "rest_module/service"
+	. "rest_module/utils"
+)
+
+// Endpoint списка сессий (устройств) пользователя
+func (api *API) UserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	sessions, err := api.sessions.UserSessions(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// Endpoint завершения сессии пользователя
+func (api *API) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	sid, err := pathID(r, "sid")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = api.sessions.Revoke(r.Context(), id, sid); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Endpoint выхода на всех устройствах
+func (api *API) RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	if err := api.sessions.RevokeAll(r.Context(), id); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Сведения о клиенте для новой сессии
+func clientInfo(r *http.Request) ClientInfo {
+	return ClientInfo{UserAgent: r.UserAgent(), IP: clientIP(r)}
+}
+
+// IP-адрес клиента; заголовки прокси учитываются только при TRUST_PROXY=true
+func clientIP(r *http.Request) string {
+	if GetEnv("TRUST_PROXY", "false") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return realIP
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
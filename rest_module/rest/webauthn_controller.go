@@ -0,0 +1,148 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	. "rest_module/service"
+)
+
+type mfaTokenRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+type loginMFAPasskeyRequest struct {
+	MFAToken   string            `json:"mfa_token"`
+	Credential WebAuthnAssertion `json:"credential"`
+}
+
+type webAuthnRegistrationRequest struct {
+	Name       string              `json:"name"`
+	Credential WebAuthnAttestation `json:"credential"`
+}
+
+// Endpoint параметров входа ключом доступа без логина
+func (api *API) PasskeyOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := api.auth.PasskeyOptions(r.Context())
+	if err != nil {
+		writeAuthError(w, err)
+		go log.Println("PasskeyOptions", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, options)
+}
+
+// Endpoint входа ключом доступа; тело - результат navigator.credentials.get()
+func (api *API) LoginPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req WebAuthnAssertion
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	pair, err := api.auth.LoginPasskey(r.Context(), &req, clientInfo(r))
+	if err != nil {
+		writeAuthError(w, err)
+		go log.Println("LoginPasskey", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pair)
+}
+
+// Endpoint параметров второго шага входа ключом доступа
+func (api *API) MFAPasskeyOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	var req mfaTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	options, err := api.auth.MFAPasskeyOptions(r.Context(), req.MFAToken, clientInfo(r))
+	if err != nil {
+		writeAuthError(w, err)
+		go log.Println("MFAPasskeyOptions", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, options)
+}
+
+// Endpoint второго шага входа ключом доступа
+func (api *API) LoginMFAPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req loginMFAPasskeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	pair, err := api.auth.LoginMFAPasskey(r.Context(), req.MFAToken, &req.Credential, clientInfo(r))
+	if err != nil {
+		writeAuthError(w, err)
+		go log.Println("LoginMFAPasskey", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pair)
+}
+
+// Endpoint списка ключей доступа пользователя
+func (api *API) WebAuthnCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	credentials, err := api.webauthn.Credentials(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, credentials)
+}
+
+// Endpoint параметров регистрации ключа доступа
+func (api *API) WebAuthnRegistrationOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	options, err := api.webauthn.BeginRegistration(r.Context(), id)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, options)
+}
+
+// Endpoint регистрации ключа доступа; credential - результат navigator.credentials.create()
+func (api *API) RegisterWebAuthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	var req webAuthnRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	credential, err := api.webauthn.FinishRegistration(r.Context(), id, req.Name, &req.Credential)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, credential)
+}
+
+// Endpoint удаления ключа доступа
+func (api *API) RemoveWebAuthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := pathID(r, "id")
+	cid, err := pathID(r, "cid")
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err = api.webauthn.RemoveCredential(r.Context(), id, cid); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"rest_module/repository"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Ключи имеют вид umk_<префикс>_<секрет>
+const apiKeyScheme = "umk_"
+
+// Выпущенный ключ; значение ключа возвращается только один раз
+type IssuedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// Сервис ключей API
+type APIKeyManager struct {
+	repository *repository.APIKeyRepository // репозиторий ключей
+	users      repository.UserStore         // хранилище пользователей
+	roles      *RoleManager                 // сервис ролей
+	defaultTTL time.Duration                // срок действия ключа по умолчанию
+	maxTTL     time.Duration                // предельный срок действия ключа
+	dbTimeout  operationTimeout             // ограничение времени операции с БД
+}
+
+// Конструктор сервиса ключей API
+func APIKeyManagerNewInstance(repository *repository.APIKeyRepository, users repository.UserStore, roles *RoleManager) *APIKeyManager {
+	manager := APIKeyManager{}
+	manager.repository = repository
+	manager.users = users
+	manager.roles = roles
+	manager.defaultTTL = GetEnvDuration("API_KEY_TTL", 90*24*time.Hour)
+	manager.maxTTL = GetEnvDuration("API_KEY_MAX_TTL", 365*24*time.Hour)
+	manager.dbTimeout = operationTimeoutFromEnv()
+	return &manager
+}
+
+// Выпуск ключа пользователя; области действия ограничены разрешениями пользователя
+func (manager *APIKeyManager) IssueUserKey(ctx context.Context, userID int64, name string, scopes []string, ttl time.Duration) (*IssuedAPIKey, error) {
+	go log.Println("Выпуск ключа API пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	user, err := manager.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("Пользователь с таким идентификатором не найден")
+	}
+
+	permissions, err := manager.roles.UserPermissions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return manager.issue(ctx, &APIKey{UserID: &user.ID, Name: name, Scopes: scopes}, permissions, ttl)
+}
+
+// Выпуск ключа сервисной учетной записи
+func (manager *APIKeyManager) IssueServiceKey(ctx context.Context, serviceName, name string, scopes []string, ttl time.Duration) (*IssuedAPIKey, error) {
+	go log.Println("Выпуск ключа API сервиса")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	serviceName = strings.TrimSpace(serviceName)
+	if serviceName == "" || len(serviceName) > 64 {
+		return nil, fmt.Errorf("Имя сервиса должно содержать от 1 до 64 символов")
+	}
+
+	permissions, err := manager.roles.AllPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return manager.issue(ctx, &APIKey{ServiceName: serviceName, Name: name, Scopes: scopes}, permissions, ttl)
+}
+
+// Действующие ключи пользователя
+func (manager *APIKeyManager) UserKeys(ctx context.Context, userID int64) (*[]APIKey, error) {
+	go log.Println("Чтение ключей API пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	keys, err := manager.repository.GetUserAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения ключей API %w", err)
+	}
+
+	return keys, nil
+}
+
+// Действующие ключи сервисных учетных записей
+func (manager *APIKeyManager) ServiceKeys(ctx context.Context) (*[]APIKey, error) {
+	go log.Println("Чтение ключей API сервисов")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	keys, err := manager.repository.GetServiceAPIKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения ключей API %w", err)
+	}
+
+	return keys, nil
+}
+
+// Отзыв ключа пользователя
+func (manager *APIKeyManager) RevokeUserKey(ctx context.Context, userID int64, keyID int64) error {
+	go log.Println("Отзыв ключа API пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	revoked, err := manager.repository.RevokeUserAPIKey(ctx, userID, keyID)
+	return revokeResult(revoked, err)
+}
+
+// Отзыв ключа сервисной учетной записи
+func (manager *APIKeyManager) RevokeServiceKey(ctx context.Context, keyID int64) error {
+	go log.Println("Отзыв ключа API сервиса")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	revoked, err := manager.repository.RevokeServiceAPIKey(ctx, keyID)
+	return revokeResult(revoked, err)
+}
+
+// Проверка ключа и получение субъекта запроса
+func (manager *APIKeyManager) Authorize(ctx context.Context, rawKey string) (*Principal, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	prefix, ok := apiKeyPrefix(rawKey)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	key, hash, err := manager.repository.GetActiveAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка проверки ключа API %w", err)
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(rawKey))) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	if err = manager.repository.TouchAPIKey(ctx, key.ID); err != nil {
+		log.Println("APIKeyManager", err)
+	}
+
+	if key.UserID == nil {
+		return &Principal{Username: "service:" + key.ServiceName, Permissions: key.Scopes, APIKeyID: key.ID}, nil
+	}
+
+	user, err := manager.users.GetUserByID(ctx, *key.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска владельца ключа API %w", err)
+	}
+	if user == nil || !user.Active {
+		return nil, ErrInvalidToken
+	}
+
+	// Ключ не дает больше, чем у пользователя есть сейчас
+	permissions, err := manager.roles.UserPermissions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		UserID:      user.ID,
+		Username:    user.Username,
+		Roles:       user.Roles,
+		Permissions: intersect(key.Scopes, permissions),
+		APIKeyID:    key.ID,
+	}, nil
+}
+
+// Признак ключа API среди значений заголовка Authorization
+func IsAPIKey(value string) bool {
+	return strings.HasPrefix(value, apiKeyScheme)
+}
+
+func (manager *APIKeyManager) issue(ctx context.Context, key *APIKey, allowed []string, ttl time.Duration) (*IssuedAPIKey, error) {
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" || len(key.Name) > 100 {
+		return nil, fmt.Errorf("Название ключа должно содержать от 1 до 100 символов")
+	}
+
+	if len(key.Scopes) == 0 {
+		return nil, fmt.Errorf("Не указаны области действия ключа")
+	}
+	for _, scope := range key.Scopes {
+		if !slices.Contains(allowed, scope) {
+			return nil, fmt.Errorf("Область действия %s недоступна", scope)
+		}
+	}
+
+	if ttl <= 0 {
+		ttl = manager.defaultTTL
+	}
+	if ttl > manager.maxTTL {
+		return nil, fmt.Errorf("Срок действия ключа не может превышать %s", manager.maxTTL)
+	}
+
+	key.Prefix = hex.EncodeToString(randomBytes(6))
+	key.CreatedAt = time.Now()
+	key.ExpiresAt = key.CreatedAt.Add(ttl)
+	rawKey := apiKeyScheme + key.Prefix + "_" + randomToken(32)
+
+	id, err := manager.repository.InsertAPIKey(ctx, key, hashToken(rawKey))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения ключа API %w", err)
+	}
+	key.ID = id
+
+	return &IssuedAPIKey{APIKey: key, Key: rawKey}, nil
+}
+
+func apiKeyPrefix(rawKey string) (string, bool) {
+	rest, found := strings.CutPrefix(rawKey, apiKeyScheme)
+	if !found {
+		return "", false
+	}
+
+	prefix, secret, found := strings.Cut(rest, "_")
+	return prefix, found && prefix != "" && secret != ""
+}
+
+func revokeResult(revoked bool, err error) error {
+	if err != nil {
+		return fmt.Errorf("Ошибка отзыва ключа API %w", err)
+	}
+	if !revoked {
+		return fmt.Errorf("Ключ API не найден")
+	}
+
+	return nil
+}
+
+func intersect(values []string, allowed []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if slices.Contains(allowed, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"rest_module/repository"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+)
+
+// Действия, записываемые в журнал аудита
+const (
+	AuditImpersonationStart = "impersonation.start"
+	AuditImpersonationEnd   = "impersonation.end"
+)
+
+const auditDefaultLimit = 100
+
+// Журнал аудита
+type AuditLog struct {
+	repository *repository.AuditRepository // репозиторий журнала
+	dbTimeout  operationTimeout            // ограничение времени операции с БД
+}
+
+// Конструктор журнала аудита
+func AuditLogNewInstance(repository *repository.AuditRepository) *AuditLog {
+	audit := AuditLog{}
+	audit.repository = repository
+	audit.dbTimeout = operationTimeoutFromEnv()
+	return &audit
+}
+
+// Запись события; actorID и userID равны 0, если неизвестны
+func (audit *AuditLog) Record(ctx context.Context, action string, actorID, userID int64, details map[string]any, client ClientInfo) error {
+	ctx, cancel := audit.dbTimeout.apply(ctx)
+	defer cancel()
+	event := AuditEvent{Action: action, Details: details, IP: truncate(client.IP, 64), UserAgent: truncate(client.UserAgent, 255)}
+	if actorID != 0 {
+		event.ActorID = &actorID
+	}
+	if userID != 0 {
+		event.UserID = &userID
+	}
+	if event.Details == nil {
+		event.Details = map[string]any{}
+	}
+
+	if _, err := audit.repository.InsertAuditEvent(ctx, &event); err != nil {
+		return fmt.Errorf("Ошибка записи в журнал аудита %w", err)
+	}
+
+	return nil
+}
+
+// Записи журнала, новые первыми; userID - участник события или 0, action - действие или пусто
+func (audit *AuditLog) Events(ctx context.Context, userID int64, action string, limit int) (*[]AuditEvent, error) {
+	go log.Println("Чтение журнала аудита")
+	ctx, cancel := audit.dbTimeout.apply(ctx)
+	defer cancel()
+	if limit <= 0 || limit > auditDefaultLimit {
+		limit = auditDefaultLimit
+	}
+
+	events, err := audit.repository.GetAuditEvents(ctx, userID, action, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения журнала аудита %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,498 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rest_module/repository"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Результат входа: пара токенов либо запрос второго фактора
+type LoginResult struct {
+	*TokenPair
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// Сервис аутентификации
+type AuthService struct {
+	users         *UserManager                // сервис пользователей
+	authenticator Authenticator               // проверка логина и пароля
+	roles         *RoleManager                // сервис ролей
+	sessions      *SessionManager             // сервис сессий
+	tokens        *TokenService               // сервис токенов
+	links         *UserTokenManager           // одноразовые токены для ссылок из писем
+	notifier      *Notifier                   // письма пользователям
+	mfa           *MFAManager                 // двухфакторная аутентификация
+	guard         *LoginGuard                 // защита от подбора пароля
+	webauthn      *WebAuthnManager            // ключи доступа WebAuthn
+	impersonation *ImpersonationService       // вход администратора от имени пользователя
+	clients       *repository.OAuthRepository // клиенты OAuth2
+	resetTTL      time.Duration               // срок действия ссылки сброса пароля
+	magicLinkTTL  time.Duration               // срок действия ссылки для входа
+	dbTimeout     operationTimeout            // ограничение времени операции с БД
+}
+
+// Конструктор сервиса аутентификации
+func AuthServiceNewInstance(users *UserManager, authenticator Authenticator, roles *RoleManager, sessions *SessionManager, tokens *TokenService,
+	links *UserTokenManager, notifier *Notifier, mfa *MFAManager, guard *LoginGuard, webauthn *WebAuthnManager,
+	impersonation *ImpersonationService, clients *repository.OAuthRepository) *AuthService {
+	service := AuthService{}
+	service.users = users
+	service.authenticator = authenticator
+	service.roles = roles
+	service.sessions = sessions
+	service.tokens = tokens
+	service.links = links
+	service.notifier = notifier
+	service.mfa = mfa
+	service.guard = guard
+	service.webauthn = webauthn
+	service.impersonation = impersonation
+	service.clients = clients
+	service.resetTTL = GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+	service.magicLinkTTL = GetEnvDuration("MAGIC_LINK_TTL", 15*time.Minute)
+	service.dbTimeout = operationTimeoutFromEnv()
+	return &service
+}
+
+// Вход по логину и паролю; при подключенной двухфакторной аутентификации выдается токен для второго шага
+func (service *AuthService) Login(ctx context.Context, username, password string, client ClientInfo) (*LoginResult, error) {
+	go log.Println("Вход пользователя")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := service.guard.Check(ctx, username, client.IP); err != nil {
+		return nil, err
+	}
+
+	user, err := service.authenticator.Authenticate(ctx, username, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		service.guard.RecordFailure(ctx, username, client.IP, FailureInvalidCredentials)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return service.completeFirstFactor(ctx, user, client)
+}
+
+// Проверка логина, пароля и, если подключена двухфакторная аутентификация, кода за один шаг
+func (service *AuthService) VerifyCredentials(ctx context.Context, username, password, code string, client ClientInfo) (*User, error) {
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := service.guard.Check(ctx, username, client.IP); err != nil {
+		return nil, err
+	}
+
+	user, err := service.authenticator.Authenticate(ctx, username, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		service.guard.RecordFailure(ctx, username, client.IP, FailureInvalidCredentials)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		if strings.TrimSpace(code) == "" {
+			return nil, ErrMFARequired
+		}
+
+		err = service.mfa.Verify(ctx, user.ID, code)
+		if errors.Is(err, ErrInvalidMFACode) {
+			service.guard.RecordFailure(ctx, username, client.IP, FailureInvalidMFACode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		service.users.CompleteRehash(ctx, user)
+	}
+
+	service.guard.RecordSuccess(ctx, user.Username)
+	return user, nil
+}
+
+// Второй шаг входа: проверка кода TOTP или кода восстановления
+func (service *AuthService) LoginMFA(ctx context.Context, mfaToken, code string, client ClientInfo) (*TokenPair, error) {
+	go log.Println("Проверка второго фактора")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	claims, id, err := service.checkMFAToken(ctx, mfaToken, client)
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.mfa.Verify(ctx, id, code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		service.guard.RecordFailure(ctx, claims.Username, client.IP, FailureInvalidMFACode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return service.completeSecondFactor(ctx, id, client)
+}
+
+// Параметры второго шага входа ключом доступа
+func (service *AuthService) MFAPasskeyOptions(ctx context.Context, mfaToken string, client ClientInfo) (*PublicKeyCredentialRequestOptions, error) {
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	_, id, err := service.checkMFAToken(ctx, mfaToken, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.webauthn.BeginLogin(ctx, id, userVerificationPreferred)
+}
+
+// Второй шаг входа: проверка утверждения ключа доступа
+func (service *AuthService) LoginMFAPasskey(ctx context.Context, mfaToken string, assertion *WebAuthnAssertion, client ClientInfo) (*TokenPair, error) {
+	go log.Println("Проверка второго фактора ключом доступа")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	claims, id, err := service.checkMFAToken(ctx, mfaToken, client)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = service.webauthn.FinishLogin(ctx, id, assertion, false)
+	if errors.Is(err, ErrInvalidPasskey) {
+		service.guard.RecordFailure(ctx, claims.Username, client.IP, FailureInvalidMFACode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return service.completeSecondFactor(ctx, id, client)
+}
+
+// Параметры входа ключом доступа без логина и пароля
+func (service *AuthService) PasskeyOptions(ctx context.Context) (*PublicKeyCredentialRequestOptions, error) {
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	return service.webauthn.BeginLogin(ctx, 0, userVerificationRequired)
+}
+
+// Вход ключом доступа без логина и пароля. Ключ с проверкой пользователя (PIN, биометрия)
+// сам является двумя факторами, поэтому второй шаг не запрашивается
+func (service *AuthService) LoginPasskey(ctx context.Context, assertion *WebAuthnAssertion, client ClientInfo) (*TokenPair, error) {
+	go log.Println("Вход ключом доступа")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	id, err := service.webauthn.FinishLogin(ctx, 0, assertion, true)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := service.users.FindUserById(ctx, id)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+	if err = service.guard.Check(ctx, user.Username, client.IP); err != nil {
+		return nil, err
+	}
+	if !user.Active {
+		return nil, ErrUserDisabled
+	}
+
+	service.users.CompleteRehash(ctx, user)
+	service.guard.RecordSuccess(ctx, user.Username)
+	return service.startSession(ctx, user, client)
+}
+
+// Обмен токена обновления на новую пару токенов
+func (service *AuthService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	go log.Println("Обновление токенов")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	session, token, err := service.sessions.Rotate(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := service.users.FindUserById(ctx, session.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Отключенный после входа пользователь не продлевает сессию, как не смог бы войти заново
+	if !user.Active {
+		return nil, ErrUserDisabled
+	}
+
+	return service.issueTokens(ctx, user, session.ID, token)
+}
+
+// Завершение текущей сессии субъекта; выход из-под чужой учетной записи завершает вход от имени пользователя
+func (service *AuthService) Logout(ctx context.Context, principal *Principal, client ClientInfo) error {
+	go log.Println("Выход пользователя")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if principal.IsImpersonated() {
+		return service.impersonation.Stop(ctx, principal, principal.Actor.ImpersonationID, client)
+	}
+	if principal.SessionID == 0 {
+		return nil
+	}
+
+	return service.sessions.Revoke(ctx, principal.UserID, principal.SessionID)
+}
+
+// Запрос сброса пароля; результат не раскрывает, зарегистрирован ли адрес
+func (service *AuthService) ForgotPassword(email string) {
+	go log.Println("Запрос сброса пароля")
+	// Поиск и отправка выполняются в фоне, чтобы время ответа было одинаковым
+	go func() {
+		ctx, cancel := service.dbTimeout.apply(context.Background())
+		defer cancel()
+		user, err := service.users.FindUserByEmail(ctx, email)
+		if err != nil {
+			return
+		}
+
+		token, err := service.links.Issue(ctx, user.ID, TokenPurposePasswordReset, service.resetTTL)
+		if err != nil {
+			log.Println("ForgotPassword", err)
+			return
+		}
+
+		if err = service.notifier.SendPasswordReset(user, token); err != nil {
+			log.Println("ForgotPassword", err)
+		}
+	}()
+}
+
+// Запрос ссылки для входа без пароля; число запросов на один адрес ограничено
+func (service *AuthService) RequestMagicLink(ctx context.Context, email string) error {
+	go log.Println("Запрос ссылки для входа")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := service.guard.LimitMagicLink(ctx, email); err != nil {
+		return err
+	}
+
+	// Поиск и отправка выполняются в фоне, чтобы время ответа было одинаковым
+	go func() {
+		ctx, cancel := service.dbTimeout.apply(context.Background())
+		defer cancel()
+		user, err := service.users.FindUserByEmail(ctx, email)
+		if err != nil || !user.Active {
+			return
+		}
+
+		token, err := service.links.Issue(ctx, user.ID, TokenPurposeMagicLink, service.magicLinkTTL)
+		if err != nil {
+			log.Println("RequestMagicLink", err)
+			return
+		}
+
+		if err = service.notifier.SendMagicLink(user, token, service.magicLinkTTL); err != nil {
+			log.Println("RequestMagicLink", err)
+		}
+	}()
+
+	return nil
+}
+
+// Вход по ссылке из письма; ссылка погашается при первом переходе.
+// При подключенной двухфакторной аутентификации выдается токен для второго шага
+func (service *AuthService) LoginMagicLink(ctx context.Context, token string, client ClientInfo) (*LoginResult, error) {
+	go log.Println("Вход по ссылке из письма")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	userID, err := service.links.Consume(ctx, token, TokenPurposeMagicLink)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := service.users.FindUserById(ctx, userID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !user.Active {
+		return nil, ErrUserDisabled
+	}
+
+	// Переход по ссылке из письма подтверждает владение адресом
+	if err = service.users.confirmEmail(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return service.completeFirstFactor(ctx, user, client)
+}
+
+// Установка нового пароля по токену из письма
+func (service *AuthService) ResetPassword(ctx context.Context, token, password string) error {
+	go log.Println("Сброс пароля")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	userID, err := service.links.Lookup(ctx, token, TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	user, err := service.users.FindUserById(ctx, userID)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	// Пароль проверяется до погашения токена, чтобы ошибка ввода не сжигала ссылку
+	if err = service.users.ValidatePassword(ctx, user, password); err != nil {
+		return err
+	}
+
+	if _, err = service.links.Consume(ctx, token, TokenPurposePasswordReset); err != nil {
+		return err
+	}
+
+	return service.users.SetPassword(ctx, userID, password)
+}
+
+// Проверка токена доступа и получение субъекта запроса
+func (service *AuthService) Authorize(ctx context.Context, accessToken string) (*Principal, error) {
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	claims, err := service.tokens.ParseAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	principal, err := claims.Principal()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = service.checkActive(ctx, principal); err != nil {
+		return nil, err
+	}
+
+	return principal, nil
+}
+
+// Проверка, что токен субъекта не отозван; отозванный токен - ErrInvalidToken
+func (service *AuthService) checkActive(ctx context.Context, principal *Principal) error {
+	// Токен доступа отклоняется сразу после завершения его сессии
+	if principal.SessionID != 0 {
+		active, err := service.sessions.IsActive(ctx, principal.SessionID)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return ErrInvalidToken
+		}
+	}
+
+	// Токен входа от имени пользователя отклоняется сразу после завершения входа
+	if principal.IsImpersonated() {
+		active, err := service.impersonation.IsActive(ctx, principal.Actor.ImpersonationID)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return ErrInvalidToken
+		}
+	}
+
+	// Токен клиента OAuth2 от его собственного имени отклоняется сразу после отзыва клиента
+	if principal.UserID == 0 && principal.ClientID != "" {
+		client, _, err := service.clients.GetClient(ctx, principal.ClientID)
+		if err != nil {
+			return err
+		}
+		if client == nil {
+			return ErrInvalidToken
+		}
+	}
+
+	return nil
+}
+
+// Вход пользователя, аутентифицированного внешним провайдером; второй фактор запрашивается, если подключен
+func (service *AuthService) CompleteExternalLogin(ctx context.Context, user *User, client ClientInfo) (*LoginResult, error) {
+	go log.Println("Вход через внешнего провайдера")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if !user.Active {
+		return nil, ErrUserDisabled
+	}
+
+	return service.completeFirstFactor(ctx, user, client)
+}
+
+// Завершение входа после проверки первого фактора
+func (service *AuthService) completeFirstFactor(ctx context.Context, user *User, client ClientInfo) (*LoginResult, error) {
+	if user.MFAEnabled {
+		token, err := service.tokens.IssueMFAToken(user)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: token}, nil
+	}
+
+	service.guard.RecordSuccess(ctx, user.Username)
+
+	pair, err := service.startSession(ctx, user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{TokenPair: pair}, nil
+}
+
+// Проверка токена второго шага и блокировки входа
+func (service *AuthService) checkMFAToken(ctx context.Context, mfaToken string, client ClientInfo) (*TokenClaims, int64, error) {
+	claims, err := service.tokens.ParseMFAToken(mfaToken)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	id, err := claims.UserID()
+	if err != nil {
+		return nil, 0, ErrInvalidToken
+	}
+
+	if err = service.guard.Check(ctx, claims.Username, client.IP); err != nil {
+		return nil, 0, err
+	}
+
+	return claims, id, nil
+}
+
+// Завершение входа после проверки второго фактора
+func (service *AuthService) completeSecondFactor(ctx context.Context, id int64, client ClientInfo) (*TokenPair, error) {
+	user, err := service.users.FindUserById(ctx, id)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !user.Active {
+		return nil, ErrUserDisabled
+	}
+
+	service.users.CompleteRehash(ctx, user)
+	service.guard.RecordSuccess(ctx, user.Username)
+	return service.startSession(ctx, user, client)
+}
+
+// Открытие сессии и выпуск токенов для пользователя
+func (service *AuthService) startSession(ctx context.Context, user *User, client ClientInfo) (*TokenPair, error) {
+	session, token, err := service.sessions.Create(ctx, user.ID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.issueTokens(ctx, user, session.ID, token)
+}
+
+func (service *AuthService) issueTokens(ctx context.Context, user *User, sessionID int64, refreshToken string) (*TokenPair, error) {
+	permissions, err := service.roles.UserPermissions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.tokens.IssuePair(user, permissions, sessionID, refreshToken)
+}
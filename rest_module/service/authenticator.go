@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rest_module/repository"
+	"strings"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Проверка логина и пароля. Неверные учетные данные возвращаются как ErrInvalidCredentials,
+// остальные ошибки прерывают вход
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*User, error)
+}
+
+// Проверка пароля по хешу из таблицы users
+var _ Authenticator = (*UserManager)(nil)
+
+// Цепочка способов проверки: учетные данные проверяются по очереди до первого успеха
+type ChainAuthenticator struct {
+	authenticators []Authenticator
+}
+
+func NewChainAuthenticator(authenticators ...Authenticator) *ChainAuthenticator {
+	return &ChainAuthenticator{authenticators: authenticators}
+}
+
+func (chain *ChainAuthenticator) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	for _, authenticator := range chain.authenticators {
+		user, err := authenticator.Authenticate(ctx, username, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+// Цепочка по переменной AUTH_BACKENDS: password - пароль из БД, ldap - каталог LDAP
+func AuthenticatorFromEnv(users *UserManager, identities repository.IdentityStore) (Authenticator, error) {
+	authenticators := []Authenticator{}
+	for _, backend := range strings.Split(GetEnv("AUTH_BACKENDS", "password"), ",") {
+		switch strings.TrimSpace(backend) {
+		case "password":
+			authenticators = append(authenticators, users)
+		case "ldap":
+			authenticators = append(authenticators, NewLDAPAuthenticator(LDAPConfigFromEnv(), users, identities))
+		default:
+			return nil, fmt.Errorf("Неизвестный способ проверки учетных данных %s", backend)
+		}
+	}
+
+	return NewChainAuthenticator(authenticators...), nil
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"os"
+	"testing"
+
+	"rest_module/repository"
+)
+
+// БД PostgreSQL для тестов из TEST_DATABASE_URL с примененными миграциями; без переменной тест пропускается
+func testDatabase(t *testing.T) *repository.DBManager {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задан")
+	}
+
+	db, err := repository.OpenDBManager(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.CloseConnection)
+
+	migrator, err := repository.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	. "rest_module/utils"
+)
+
+// Ограничение времени операции с БД
+type operationTimeout time.Duration
+
+// Ограничение из DB_OPERATION_TIMEOUT
+func operationTimeoutFromEnv() operationTimeout {
+	return operationTimeout(GetEnvDuration("DB_OPERATION_TIMEOUT", 5*time.Second))
+}
+
+// Контекст операции, ограниченный сроком; более короткий срок ctx сохраняется
+func (timeout operationTimeout) apply(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(timeout))
+}
@@ -0,0 +1,18 @@
+package service
+
+import "errors"
+
+var (
+	ErrInvalidCredentials = errors.New("Неверный логин или пароль")
+	ErrInvalidToken       = errors.New("Недействительный или просроченный токен")
+	ErrEmailNotVerified   = errors.New("Адрес почты не подтвержден")
+	ErrInvalidMFACode     = errors.New("Неверный код подтверждения")
+	ErrMFARequired        = errors.New("Требуется код подтверждения")
+	ErrSAMLDisabled       = errors.New("Вход через SAML не настроен")
+	ErrUserDisabled       = errors.New("Учетная запись отключена")
+	ErrInvalidPasskey     = errors.New("Ключ доступа не принят")
+	ErrForbidden          = errors.New("Недостаточно прав для действия")
+
+	// Правило парольной политики не удалось проверить, например, история паролей не прочитана
+	ErrPasswordCheckUnavailable = errors.New("Проверка пароля временно недоступна")
+)
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"rest_module/repository"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Результат начала входа от имени пользователя
+type ImpersonationResult struct {
+	*TokenPair
+	ImpersonationID int64     `json:"impersonation_id"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// Вход администратора от имени пользователя: ограниченный по времени токен с утверждением act.
+// Начало и завершение записываются в журнал аудита
+type ImpersonationService struct {
+	repository *repository.ImpersonationRepository // репозиторий входов от имени пользователя
+	users      *UserManager                        // сервис пользователей
+	roles      *RoleManager                        // сервис ролей
+	tokens     *TokenService                       // сервис токенов
+	audit      *AuditLog                           // журнал аудита
+	ttl        time.Duration                       // срок действия токена
+	dbTimeout  operationTimeout                    // ограничение времени операции с БД
+}
+
+// Конструктор сервиса входа от имени пользователя
+func ImpersonationServiceNewInstance(repository *repository.ImpersonationRepository, users *UserManager, roles *RoleManager,
+	tokens *TokenService, audit *AuditLog) *ImpersonationService {
+	service := ImpersonationService{}
+	service.repository = repository
+	service.users = users
+	service.roles = roles
+	service.tokens = tokens
+	service.audit = audit
+	service.ttl = GetEnvDuration("IMPERSONATION_TTL", 30*time.Minute)
+	service.dbTimeout = operationTimeoutFromEnv()
+	return &service
+}
+
+// Начало входа от имени пользователя userID; reason - основание, например номер обращения
+func (service *ImpersonationService) Start(ctx context.Context, actor *Principal, userID int64, reason string, client ClientInfo) (*ImpersonationResult, error) {
+	go log.Println("Вход от имени пользователя")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("Не указано основание входа от имени пользователя")
+	}
+	if actor.IsImpersonated() || !actor.IsInteractive() {
+		return nil, fmt.Errorf("Вход от имени пользователя доступен только администратору, вошедшему от своего имени")
+	}
+	if actor.UserID == userID {
+		return nil, fmt.Errorf("Нельзя войти от имени самого себя")
+	}
+
+	admin, err := service.users.FindUserById(ctx, actor.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := service.users.FindUserById(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Active {
+		return nil, ErrUserDisabled
+	}
+
+	permissions, err := service.roles.UserPermissions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Иначе через учетную запись другого администратора можно получить его полномочия без следа в его журнале
+	if slices.Contains(permissions, PermissionUsersImpersonate) {
+		return nil, fmt.Errorf("Нельзя войти от имени пользователя, которому доступен вход от имени других")
+	}
+
+	impersonation := Impersonation{ActorID: &admin.ID, UserID: user.ID, Reason: truncate(reason, 255), ExpiresAt: time.Now().Add(service.ttl)}
+	if _, err = service.repository.InsertImpersonation(ctx, &impersonation); err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения входа от имени пользователя %w", err)
+	}
+
+	// Без записи в журнале токен не выдается
+	details := map[string]any{"impersonation_id": impersonation.ID, "reason": impersonation.Reason, "expires_at": impersonation.ExpiresAt}
+	if err = service.audit.Record(ctx, AuditImpersonationStart, admin.ID, user.ID, details, client); err != nil {
+		service.repository.EndImpersonation(ctx, impersonation.ID)
+		return nil, err
+	}
+
+	pair, err := service.tokens.IssueImpersonationToken(user, permissions, admin, impersonation.ID, time.Until(impersonation.ExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImpersonationResult{TokenPair: pair, ImpersonationID: impersonation.ID, ExpiresAt: impersonation.ExpiresAt}, nil
+}
+
+// Завершение входа от имени пользователя. Завершить вход может сам токен входа, начавший его администратор
+// или администратор с разрешением impersonations:end, действующий от своего имени
+func (service *ImpersonationService) Stop(ctx context.Context, principal *Principal, id int64, client ClientInfo) error {
+	go log.Println("Завершение входа от имени пользователя")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	impersonation, err := service.repository.GetImpersonation(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Ошибка поиска входа от имени пользователя %w", err)
+	}
+	if impersonation == nil {
+		return fmt.Errorf("Вход от имени пользователя не найден")
+	}
+
+	actorID := principal.UserID
+	if principal.IsImpersonated() {
+		actorID = principal.Actor.UserID
+	}
+	own := (principal.IsImpersonated() && principal.Actor.ImpersonationID == id) || (impersonation.ActorID != nil && *impersonation.ActorID == actorID)
+	if !own && (principal.IsImpersonated() || !principal.HasPermission(PermissionImpersonationsEnd)) {
+		return ErrForbidden
+	}
+
+	ended, err := service.repository.EndImpersonation(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Ошибка завершения входа от имени пользователя %w", err)
+	}
+	if !ended {
+		return fmt.Errorf("Вход от имени пользователя уже завершен")
+	}
+
+	return service.audit.Record(ctx, AuditImpersonationEnd, actorID, impersonation.UserID, map[string]any{"impersonation_id": id}, client)
+}
+
+// Вход от имени пользователя не завершен и не истек
+func (service *ImpersonationService) IsActive(ctx context.Context, id int64) (bool, error) {
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	active, err := service.repository.IsActive(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("Ошибка проверки входа от имени пользователя %w", err)
+	}
+
+	return active, nil
+}
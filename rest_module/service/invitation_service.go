@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"rest_module/repository"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Статусы приглашения
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// Приглашения пользователей администраторами: приглашенный сам задает пароль при принятии
+type InvitationService struct {
+	repository *repository.InvitationRepository // репозиторий приглашений
+	users      *UserManager                     // сервис пользователей
+	roles      *RoleManager                     // сервис ролей
+	links      *UserTokenManager                // одноразовые токены для ссылок из писем
+	notifier   *Notifier                        // письма пользователям
+	ttl        time.Duration                    // срок действия приглашения
+	dbTimeout  operationTimeout                 // ограничение времени операции с БД
+}
+
+// Конструктор сервиса приглашений
+func InvitationServiceNewInstance(repository *repository.InvitationRepository, users *UserManager, roles *RoleManager,
+	links *UserTokenManager, notifier *Notifier) *InvitationService {
+	service := InvitationService{}
+	service.repository = repository
+	service.users = users
+	service.roles = roles
+	service.links = links
+	service.notifier = notifier
+	service.ttl = GetEnvDuration("INVITATION_TTL", 7*24*time.Hour)
+	service.dbTimeout = operationTimeoutFromEnv()
+	return &service
+}
+
+// Приглашение пользователя: создание отключенной учетной записи с ролями и отправка ссылки.
+// invitedBy - пригласивший пользователь или 0
+func (service *InvitationService) Invite(ctx context.Context, username, email string, roles []string, invitedBy int64) (*Invitation, error) {
+	go log.Println("Приглашение пользователя")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	roles = slices.Compact(slices.Sorted(slices.Values(roles)))
+	for _, name := range roles {
+		if _, err := service.roles.findRole(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := service.users.AddInvitedUser(ctx, username, email)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := Invitation{UserID: &user.ID, Username: user.Username, Email: user.Email, Roles: roles,
+		Status: InvitationPending, ExpiresAt: time.Now().Add(service.ttl)}
+	if invitedBy != 0 {
+		invitation.InvitedBy = &invitedBy
+	}
+
+	for _, name := range roles {
+		if _, err = service.roles.AssignRole(ctx, user.ID, name); err != nil {
+			service.discardUser(ctx, user.ID)
+			return nil, err
+		}
+	}
+
+	if _, err = service.repository.InsertInvitation(ctx, &invitation); err != nil {
+		service.discardUser(ctx, user.ID)
+		return nil, fmt.Errorf("Ошибка сохранения приглашения %w", err)
+	}
+
+	// Письмо можно отправить повторно, поэтому ошибка отправки не отменяет приглашение
+	if err = service.send(ctx, user, invitation.ExpiresAt); err != nil {
+		log.Warnf("Приглашение %d не отправлено: %v", invitation.ID, err)
+	}
+
+	return &invitation, nil
+}
+
+// Приглашения; status - pending, accepted, revoked, expired или пусто для всех
+func (service *InvitationService) ListInvitations(ctx context.Context, status string) (*[]Invitation, error) {
+	go log.Println("Чтение приглашений")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if status != "" && !slices.Contains([]string{InvitationPending, InvitationAccepted, InvitationRevoked, InvitationExpired}, status) {
+		return nil, fmt.Errorf("Неизвестный статус приглашения %s", status)
+	}
+
+	invitations, err := service.repository.GetAllInvitations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения приглашений %w", err)
+	}
+
+	if status != "" {
+		filtered := slices.DeleteFunc(*invitations, func(invitation Invitation) bool { return invitation.Status != status })
+		invitations = &filtered
+	}
+
+	return invitations, nil
+}
+
+// Повторная отправка приглашения с новым сроком действия; прежняя ссылка перестает действовать
+func (service *InvitationService) Resend(ctx context.Context, id int64) (*Invitation, error) {
+	go log.Println("Повторная отправка приглашения")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	invitation, err := service.findOpenInvitation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := service.users.FindUserById(ctx, *invitation.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(service.ttl)
+	if err = service.repository.ExtendInvitation(ctx, id, expiresAt); err != nil {
+		return nil, fmt.Errorf("Ошибка продления приглашения %w", err)
+	}
+
+	if err = service.send(ctx, user, expiresAt); err != nil {
+		return nil, err
+	}
+
+	invitation.ExpiresAt, invitation.Status = expiresAt, InvitationPending
+	return invitation, nil
+}
+
+// Отзыв приглашения; созданная для него учетная запись удаляется
+func (service *InvitationService) Revoke(ctx context.Context, id int64) error {
+	go log.Println("Отзыв приглашения")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	invitation, err := service.findOpenInvitation(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := service.repository.RevokeInvitation(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Ошибка отзыва приглашения %w", err)
+	}
+	if !revoked {
+		return fmt.Errorf("Приглашение уже принято или отозвано")
+	}
+
+	return service.users.DeleteUserById(ctx, *invitation.UserID)
+}
+
+// Принятие приглашения по токену из письма с установкой пароля
+func (service *InvitationService) Accept(ctx context.Context, token, password string) (*User, error) {
+	go log.Println("Принятие приглашения")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	userID, err := service.links.Lookup(ctx, token, TokenPurposeInvitation)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation, err := service.repository.GetOpenInvitationByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска приглашения %w", err)
+	}
+	if invitation == nil || invitation.Status != InvitationPending {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := service.users.FindUserById(ctx, userID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Пароль проверяется до погашения токена, чтобы ошибка ввода не сжигала ссылку
+	if err = service.users.ValidatePassword(ctx, user, password); err != nil {
+		return nil, err
+	}
+
+	if _, err = service.links.Consume(ctx, token, TokenPurposeInvitation); err != nil {
+		return nil, err
+	}
+
+	if user, err = service.users.AcceptInvitation(ctx, userID, password); err != nil {
+		return nil, err
+	}
+
+	if err = service.repository.AcceptInvitation(ctx, invitation.ID); err != nil {
+		return nil, fmt.Errorf("Ошибка принятия приглашения %w", err)
+	}
+
+	return user, nil
+}
+
+// Приглашение, которое еще можно отправить повторно или отозвать
+func (service *InvitationService) findOpenInvitation(ctx context.Context, id int64) (*Invitation, error) {
+	invitation, err := service.repository.GetInvitation(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска приглашения %w", err)
+	}
+	if invitation == nil {
+		return nil, fmt.Errorf("Приглашение не найдено")
+	}
+	if invitation.Status == InvitationAccepted || invitation.Status == InvitationRevoked || invitation.UserID == nil {
+		return nil, fmt.Errorf("Приглашение уже принято или отозвано")
+	}
+
+	return invitation, nil
+}
+
+// Выпуск ссылки до окончания срока приглашения и отправка письма
+func (service *InvitationService) send(ctx context.Context, user *User, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	token, err := service.links.Issue(ctx, user.ID, TokenPurposeInvitation, ttl)
+	if err != nil {
+		return err
+	}
+
+	if err = service.notifier.SendInvitation(user, token, ttl); err != nil {
+		return fmt.Errorf("Ошибка отправки приглашения %w", err)
+	}
+
+	return nil
+}
+
+// Удаление учетной записи приглашения, которое не удалось создать
+func (service *InvitationService) discardUser(ctx context.Context, userID int64) {
+	if err := service.users.DeleteUserById(ctx, userID); err != nil {
+		log.Warnf("Учетная запись %d не удалена: %v", userID, err)
+	}
+}
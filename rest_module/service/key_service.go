@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"rest_module/repository"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/robfig/cron"
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Поддерживаемые алгоритмы подписи токенов
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+)
+
+// Открытый ключ в формате JWK (RFC 7517)
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// Набор открытых ключей
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type signingKey struct {
+	id        int64
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+}
+
+// Сервис ключей подписи: хранение, публикация и плановая ротация
+type KeyManager struct {
+	repository     *repository.SigningKeyRepository // репозиторий ключей
+	box            *SecretBox                       // шифрование закрытых ключей
+	algorithm      string                           // алгоритм новых ключей
+	rotationPeriod time.Duration                    // срок использования ключа для подписи
+	retention      time.Duration                    // срок публикации выведенного ключа
+	schedule       string                           // расписание проверки ротации
+	dbTimeout      operationTimeout                 // ограничение времени операции с БД
+	m              sync.RWMutex
+	current        *signingKey            // ключ для подписи
+	keys           map[string]*signingKey // опубликованные ключи по kid
+	reloadedAt     time.Time              // время последней загрузки ключей
+}
+
+// Конструктор сервиса ключей подписи
+func KeyManagerNewInstance(repository *repository.SigningKeyRepository, box *SecretBox) *KeyManager {
+	manager := KeyManager{}
+	manager.repository = repository
+	manager.box = box
+	manager.algorithm = GetEnv("OIDC_SIGNING_ALG", AlgorithmRS256)
+	manager.rotationPeriod = GetEnvDuration("OIDC_KEY_ROTATION_PERIOD", 30*24*time.Hour)
+	manager.retention = GetEnvDuration("OIDC_KEY_RETENTION", 24*time.Hour)
+	manager.schedule = GetEnv("OIDC_KEY_SCHEDULE", "@every 1m")
+	manager.keys = map[string]*signingKey{}
+	manager.dbTimeout = operationTimeoutFromEnv()
+	return &manager
+}
+
+// Загрузка ключей, выпуск первого ключа и запуск плановой ротации
+func (manager *KeyManager) Start(ctx context.Context) error {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if manager.algorithm != AlgorithmRS256 && manager.algorithm != AlgorithmES256 {
+		return fmt.Errorf("Алгоритм подписи %s не поддерживается", manager.algorithm)
+	}
+
+	if err := manager.reload(ctx); err != nil {
+		return err
+	}
+
+	if manager.currentKey() == nil {
+		key, err := manager.generate()
+		if err != nil {
+			return err
+		}
+		if _, err = manager.repository.InsertSigningKey(ctx, key); err != nil {
+			return fmt.Errorf("Ошибка сохранения ключа подписи %w", err)
+		}
+		if err = manager.reload(ctx); err != nil {
+			return err
+		}
+	}
+
+	manager.rotate()
+
+	// Каждый экземпляр сервиса подхватывает ключи, выпущенные другими экземплярами
+	scheduler := cron.New()
+	if err := scheduler.AddFunc(manager.schedule, manager.rotate); err != nil {
+		return fmt.Errorf("Неверное расписание ротации ключей %w", err)
+	}
+	scheduler.Start()
+
+	return nil
+}
+
+// Подпись утверждений действующим ключом
+func (manager *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	key := manager.currentKey()
+	if key == nil {
+		return "", fmt.Errorf("Нет ключа подписи")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("Ошибка подписи токена %w", err)
+	}
+
+	return signed, nil
+}
+
+// Открытый ключ для проверки подписи по заголовку kid
+func (manager *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	// Сигнатура jwt.Keyfunc не передает контекст запроса
+	ctx, cancel := manager.dbTimeout.apply(context.Background())
+	defer cancel()
+	key := manager.find(ctx, kid)
+	if key == nil {
+		return nil, fmt.Errorf("Неизвестный ключ подписи %s", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("Алгоритм токена не совпадает с алгоритмом ключа")
+	}
+
+	return key.private.Public(), nil
+}
+
+// Алгоритмы, которыми могут быть подписаны токены
+func (manager *KeyManager) Methods() []string {
+	return []string{AlgorithmRS256, AlgorithmES256}
+}
+
+// Алгоритм подписи новых токенов
+func (manager *KeyManager) Algorithm() string {
+	return manager.algorithm
+}
+
+// Опубликованные открытые ключи
+func (manager *KeyManager) JWKS() *JSONWebKeySet {
+	manager.m.RLock()
+	defer manager.m.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range manager.keys {
+		set.Keys = append(set.Keys, publicJWK(key))
+	}
+	slices.SortFunc(set.Keys, func(a, b JSONWebKey) int {
+		return manager.keys[b.KeyID].createdAt.Compare(manager.keys[a.KeyID].createdAt)
+	})
+
+	return &set
+}
+
+// Загрузка ключей и ротация действующего ключа, если срок его использования истек
+func (manager *KeyManager) rotate() {
+	ctx, cancel := manager.dbTimeout.apply(context.Background())
+	defer cancel()
+	if err := manager.reload(ctx); err != nil {
+		log.Println("KeyManager", err)
+		return
+	}
+
+	current := manager.currentKey()
+	if current == nil || (time.Since(current.createdAt) < manager.rotationPeriod && current.method.Alg() == manager.algorithm) {
+		return
+	}
+
+	go log.Println("Ротация ключа подписи")
+	key, err := manager.generate()
+	if err != nil {
+		log.Println("KeyManager", err)
+		return
+	}
+
+	if _, err = manager.repository.RotateSigningKey(ctx, current.id, key); err != nil {
+		log.Println("KeyManager", err)
+		return
+	}
+
+	if err = manager.reload(ctx); err != nil {
+		log.Println("KeyManager", err)
+	}
+}
+
+func (manager *KeyManager) reload(ctx context.Context) error {
+	stored, err := manager.repository.GetPublishedKeys(ctx, manager.retention)
+	if err != nil {
+		return fmt.Errorf("Ошибка чтения ключей подписи %w", err)
+	}
+
+	keys := map[string]*signingKey{}
+	var current *signingKey
+	for _, row := range *stored {
+		key, err := manager.decode(&row)
+		if err != nil {
+			log.Println("KeyManager", row.KID, err)
+			continue
+		}
+
+		keys[key.kid] = key
+		if current == nil && row.RetiredAt == nil {
+			current = key
+		}
+	}
+
+	manager.m.Lock()
+	manager.keys = keys
+	manager.current = current
+	manager.reloadedAt = time.Now()
+	manager.m.Unlock()
+
+	return nil
+}
+
+func (manager *KeyManager) currentKey() *signingKey {
+	manager.m.RLock()
+	defer manager.m.RUnlock()
+	return manager.current
+}
+
+// Поиск ключа; неизвестный kid приводит к перезагрузке не чаще раза в 10 секунд
+func (manager *KeyManager) find(ctx context.Context, kid string) *signingKey {
+	manager.m.RLock()
+	key, reloadedAt := manager.keys[kid], manager.reloadedAt
+	manager.m.RUnlock()
+
+	if key != nil || time.Since(reloadedAt) < 10*time.Second {
+		return key
+	}
+
+	if err := manager.reload(ctx); err != nil {
+		log.Println("KeyManager", err)
+		return nil
+	}
+
+	manager.m.RLock()
+	defer manager.m.RUnlock()
+	return manager.keys[kid]
+}
+
+func (manager *KeyManager) generate() (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch manager.algorithm {
+	case AlgorithmES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выпуска ключа подписи %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выпуска ключа подписи %w", err)
+	}
+
+	encrypted, err := manager.box.Encrypt(der)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка шифрования ключа подписи %w", err)
+	}
+
+	return &SigningKey{KID: randomToken(12), Algorithm: manager.algorithm, PrivateKey: encrypted}, nil
+}
+
+func (manager *KeyManager) decode(row *SigningKey) (*signingKey, error) {
+	der, err := manager.box.Decrypt(row.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения ключа подписи %w", err)
+	}
+
+	key := signingKey{id: row.ID, kid: row.KID, createdAt: row.CreatedAt}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private = jwt.SigningMethodRS256, private
+	case *ecdsa.PrivateKey:
+		key.method, key.private = jwt.SigningMethodES256, private
+	default:
+		return nil, fmt.Errorf("Неподдерживаемый тип ключа подписи")
+	}
+
+	if key.method.Alg() != row.Algorithm {
+		return nil, fmt.Errorf("Тип ключа не соответствует алгоритму %s", row.Algorithm)
+	}
+
+	return &key, nil
+}
+
+func publicJWK(key *signingKey) JSONWebKey {
+	jwk := JSONWebKey{Use: "sig", Algorithm: key.method.Alg(), KeyID: key.kid}
+	switch public := key.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// Несжатая точка: 0x04 || X || Y
+		exchange, err := public.ECDH()
+		if err != nil {
+			break
+		}
+		point := exchange.Bytes()
+		size := (len(point) - 1) / 2
+		jwk.KeyType = "EC"
+		jwk.Curve = public.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(point[1+size:])
+	}
+
+	return jwk
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"rest_module/repository"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Провайдер учетных записей каталога в таблице user_identities
+const ldapIdentityProvider = "ldap"
+
+// Параметры подключения к каталогу LDAP и соответствие атрибутов
+type LDAPConfig struct {
+	URL               string        // ldap://host:389 или ldaps://host:636
+	StartTLS          bool          // переход на TLS для ldap://
+	BindDN            string        // учетная запись для поиска; пусто - анонимный поиск
+	BindPassword      string        // пароль учетной записи для поиска
+	BaseDN            string        // корень поиска пользователей
+	UserFilter        string        // фильтр поиска, %s заменяется экранированным логином
+	UsernameAttribute string        // атрибут логина
+	EmailAttribute    string        // атрибут адреса почты
+	IDAttribute       string        // неизменный идентификатор записи; пусто - DN
+	AutoProvision     bool          // создание пользователя при первом входе
+	Timeout           time.Duration // тайм-аут подключения и запросов
+}
+
+// Параметры LDAP по переменным окружения
+func LDAPConfigFromEnv() *LDAPConfig {
+	return &LDAPConfig{
+		URL:               GetEnv("LDAP_URL", "ldap://localhost:389"),
+		StartTLS:          GetEnv("LDAP_START_TLS", "false") == "true",
+		BindDN:            GetEnv("LDAP_BIND_DN", ""),
+		BindPassword:      GetEnv("LDAP_BIND_PASSWORD", ""),
+		BaseDN:            GetEnv("LDAP_BASE_DN", ""),
+		UserFilter:        GetEnv("LDAP_USER_FILTER", "(uid=%s)"),
+		UsernameAttribute: GetEnv("LDAP_ATTR_USERNAME", "uid"),
+		EmailAttribute:    GetEnv("LDAP_ATTR_EMAIL", "mail"),
+		IDAttribute:       GetEnv("LDAP_ATTR_ID", ""),
+		AutoProvision:     GetEnv("LDAP_AUTO_PROVISION", "false") == "true",
+		Timeout:           GetEnvDuration("LDAP_TIMEOUT", 5*time.Second),
+	}
+}
+
+// Запись пользователя в каталоге
+type ldapEntry struct {
+	subject  string // значение IDAttribute или DN
+	username string
+	email    string
+}
+
+// Проверка пароля привязкой к каталогу LDAP: поиск записи по логину и simple bind от ее имени
+type LDAPAuthenticator struct {
+	config     *LDAPConfig
+	users      *UserManager             // сервис пользователей
+	identities repository.IdentityStore // связи с записями каталога
+}
+
+func NewLDAPAuthenticator(config *LDAPConfig, users *UserManager, identities repository.IdentityStore) *LDAPAuthenticator {
+	return &LDAPAuthenticator{config: config, users: users, identities: identities}
+}
+
+func (auth *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	go log.Println("Проверка учетных данных в LDAP")
+	// Привязка с пустым паролем считается анонимной и проходит без проверки
+	if strings.TrimSpace(username) == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	entry, err := auth.bind(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := auth.resolveUser(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = auth.users.loginAllowed(user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Поиск записи пользователя и проверка пароля
+func (auth *LDAPAuthenticator) bind(username, password string) (*ldapEntry, error) {
+	conn, err := auth.dial()
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка подключения к LDAP %w", err)
+	}
+	defer conn.Close()
+
+	if auth.config.BindDN != "" {
+		if err = conn.Bind(auth.config.BindDN, auth.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("Ошибка привязки к LDAP учетной записи поиска %w", err)
+		}
+	}
+
+	attributes := []string{auth.config.UsernameAttribute, auth.config.EmailAttribute}
+	if auth.config.IDAttribute != "" {
+		attributes = append(attributes, auth.config.IDAttribute)
+	}
+	request := ldap.NewSearchRequest(auth.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2,
+		int(auth.config.Timeout.Seconds()), false, fmt.Sprintf(auth.config.UserFilter, ldap.EscapeFilter(username)), attributes, nil)
+
+	result, err := conn.Search(request)
+	// Несколько записей на один логин: вход невозможен, какая из них имелась в виду, неизвестно
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || (err == nil && len(result.Entries) != 1) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя в LDAP %w", err)
+	}
+
+	found := result.Entries[0]
+	if err = conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("Ошибка проверки пароля в LDAP %w", err)
+	}
+
+	entry := ldapEntry{subject: found.DN, username: found.GetAttributeValue(auth.config.UsernameAttribute), email: found.GetAttributeValue(auth.config.EmailAttribute)}
+	if auth.config.IDAttribute != "" {
+		entry.subject = found.GetAttributeValue(auth.config.IDAttribute)
+	}
+	if entry.username == "" {
+		entry.username = username
+	}
+	if entry.subject == "" {
+		return nil, fmt.Errorf("В записи LDAP %s нет атрибута %s", found.DN, auth.config.IDAttribute)
+	}
+
+	return &entry, nil
+}
+
+// Подключение к каталогу, при необходимости с переходом на TLS
+func (auth *LDAPAuthenticator) dial() (ldap.Client, error) {
+	conn, err := ldap.DialURL(auth.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: auth.config.Timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(auth.config.Timeout)
+
+	if auth.config.StartTLS {
+		address, err := url.Parse(auth.config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err = conn.StartTLS(&tls.Config{ServerName: address.Hostname(), MinVersion: tls.VersionTLS12}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// Пользователь, связанный с записью каталога. Каталог - внутренний источник учетных записей,
+// поэтому существующий пользователь с тем же логином связывается с записью при первом входе
+func (auth *LDAPAuthenticator) resolveUser(ctx context.Context, entry *ldapEntry) (*User, error) {
+	userID, err := auth.identities.GetIdentityUserID(ctx, ldapIdentityProvider, entry.subject)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска учетной записи LDAP %w", err)
+	}
+	if userID != 0 {
+		return auth.users.FindUserById(ctx, userID)
+	}
+
+	user, _ := auth.users.FindUserByName(ctx, entry.username)
+	if user == nil {
+		if !auth.config.AutoProvision {
+			return nil, ErrInvalidCredentials
+		}
+		if entry.email == "" {
+			return nil, fmt.Errorf("В записи LDAP нет адреса почты")
+		}
+
+		if user, err = auth.users.ProvisionExternalUser(ctx, &ExternalUser{Username: entry.username, Email: entry.email, Active: true}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = auth.identities.InsertIdentity(ctx, user.ID, ldapIdentityProvider, entry.subject); err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения учетной записи LDAP %w", err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/bcrypt"
+
+	"rest_module/repository"
+)
+
+// Запись каталога в заглушке LDAP
+type ldapStubEntry struct {
+	dn         string
+	password   string
+	attributes map[string]string
+}
+
+// Сервер LDAP в процессе теста: simple bind, поиск с фильтрами равенства, присутствия и подстрок.
+// Привязка с пустым паролем, как и у настоящих серверов, проходит как анонимная (RFC 4513, 5.1.2)
+type ldapStub struct {
+	listener net.Listener
+	entries  []ldapStubEntry
+
+	m       sync.Mutex
+	conns   int      // число подключений
+	binds   []string // DN успешных и неуспешных привязок
+	filters []string // фильтры поиска
+}
+
+func startLDAPStub(t *testing.T, entries ...ldapStubEntry) *ldapStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	stub := &ldapStub{listener: listener, entries: entries}
+	go stub.serve()
+	return stub
+}
+
+func (stub *ldapStub) url() string {
+	return "ldap://" + stub.listener.Addr().String()
+}
+
+func (stub *ldapStub) serve() {
+	for {
+		conn, err := stub.listener.Accept()
+		if err != nil {
+			return
+		}
+		stub.m.Lock()
+		stub.conns++
+		stub.m.Unlock()
+		go stub.handle(conn)
+	}
+}
+
+func (stub *ldapStub) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, _ := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			conn.Write(ldapResponse(messageID, ldap.ApplicationBindResponse, ldapResult(stub.bind(dn, password))...))
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(request.Children[6])
+			stub.m.Lock()
+			stub.filters = append(stub.filters, filter)
+			stub.m.Unlock()
+
+			for _, entry := range stub.entries {
+				if ldapStubMatch(request.Children[6], &entry) {
+					conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultEntry, ldapStubEntryPackets(&entry)...))
+				}
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultDone, ldapResult(ldap.LDAPResultSuccess)...))
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// Результат simple bind
+func (stub *ldapStub) bind(dn, password string) int {
+	stub.m.Lock()
+	stub.binds = append(stub.binds, dn)
+	stub.m.Unlock()
+
+	if password == "" {
+		return ldap.LDAPResultSuccess
+	}
+	for _, entry := range stub.entries {
+		if strings.EqualFold(entry.dn, dn) && entry.password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// Значение атрибута; имена атрибутов, как и в LDAP, сравниваются без учета регистра
+func (entry *ldapStubEntry) attribute(name string) (string, bool) {
+	for attribute, value := range entry.attributes {
+		if strings.EqualFold(attribute, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func ldapStubMatch(filter *ber.Packet, entry *ldapStubEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !ldapStubMatch(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if ldapStubMatch(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !ldapStubMatch(filter.Children[0], entry)
+	case ldap.FilterPresent:
+		_, ok := entry.attribute(filter.Data.String())
+		return ok
+	case ldap.FilterEqualityMatch:
+		value, ok := entry.attribute(filter.Children[0].Data.String())
+		return ok && strings.EqualFold(value, filter.Children[1].Data.String())
+	case ldap.FilterSubstrings:
+		value, ok := entry.attribute(filter.Children[0].Data.String())
+		value = strings.ToLower(value)
+		for _, part := range filter.Children[1].Children {
+			substring := strings.ToLower(part.Data.String())
+			switch part.Tag {
+			case ldap.FilterSubstringsInitial:
+				ok = ok && strings.HasPrefix(value, substring)
+				value = strings.TrimPrefix(value, substring)
+			case ldap.FilterSubstringsAny:
+				index := strings.Index(value, substring)
+				ok = ok && index >= 0
+				value = value[max(index, 0)+len(substring):]
+			case ldap.FilterSubstringsFinal:
+				ok = ok && strings.HasSuffix(value, substring)
+			}
+		}
+		return ok
+	}
+	return false
+}
+
+func ldapResponse(messageID int64, tag ber.Tag, children ...*ber.Packet) []byte {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	for _, child := range children {
+		response.AppendChild(child)
+	}
+	envelope.AppendChild(response)
+	return envelope.Bytes()
+}
+
+func ldapResult(code int) []*ber.Packet {
+	return []*ber.Packet{
+		ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"),
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"),
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"),
+	}
+}
+
+func ldapStubEntryPackets(entry *ldapStubEntry) []*ber.Packet {
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, value := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+	}
+
+	return []*ber.Packet{ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "DN"), attributes}
+}
+
+// Связи с учетными записями провайдеров в памяти
+type memoryIdentityStore struct {
+	m          sync.Mutex
+	identities map[string]int64
+}
+
+func (store *memoryIdentityStore) InsertIdentity(ctx context.Context, userID int64, provider, subject string) error {
+	store.m.Lock()
+	defer store.m.Unlock()
+	store.identities[provider+"/"+subject] = userID
+	return nil
+}
+
+func (store *memoryIdentityStore) GetIdentityUserID(ctx context.Context, provider, subject string) (int64, error) {
+	store.m.Lock()
+	defer store.m.Unlock()
+	return store.identities[provider+"/"+subject], nil
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	stub := startLDAPStub(t,
+		ldapStubEntry{dn: "uid=ivan,ou=people,dc=example,dc=com", password: "ivan-secret",
+			attributes: map[string]string{"uid": "ivan", "mail": "ivan@example.com", "entryUUID": "6f1c0a52-ivan"}},
+		ldapStubEntry{dn: "uid=petr,ou=people,dc=example,dc=com", password: "petr-secret",
+			attributes: map[string]string{"uid": "petr", "mail": "petr@example.com", "entryUUID": "9b4e7d10-petr"}},
+		ldapStubEntry{dn: "uid=anna,ou=people,dc=example,dc=com", password: "anna-secret",
+			attributes: map[string]string{"uid": "anna", "mail": "anna@example.com"}},
+		ldapStubEntry{dn: "cn=anna,ou=people,dc=example,dc=com", password: "anna-secret",
+			attributes: map[string]string{"uid": "anna", "mail": "anna.k@example.com"}},
+	)
+
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		autoProvision bool
+		wantUser      string
+		wantErr       error
+		wantFilter    string // фильтр, с которым выполнен поиск; пусто - поиск не выполнялся
+	}{
+		{name: "верный пароль", username: "ivan", password: "ivan-secret", wantUser: "ivan", wantFilter: "(uid=ivan)"},
+		{name: "неверный пароль", username: "ivan", password: "wrong", wantErr: ErrInvalidCredentials, wantFilter: "(uid=ivan)"},
+		{name: "пустой пароль", username: "ivan", password: "", wantErr: ErrInvalidCredentials},
+		{name: "пустой логин", username: " ", password: "ivan-secret", wantErr: ErrInvalidCredentials},
+		{name: "неизвестный логин", username: "sidor", password: "ivan-secret", wantErr: ErrInvalidCredentials, wantFilter: "(uid=sidor)"},
+		{name: "несколько записей на логин", username: "anna", password: "anna-secret", wantErr: ErrInvalidCredentials,
+			wantFilter: "(uid=anna)"},
+		{name: "пароль из пробелов", username: "ivan", password: " ", wantErr: ErrInvalidCredentials, wantFilter: "(uid=ivan)"},
+		{name: "подстановка в логине", username: "iv*", password: "ivan-secret", wantErr: ErrInvalidCredentials, wantFilter: `(uid=iv\2a)`},
+		{name: "внедрение условия в фильтр", username: "ivan)(uid=*", password: "ivan-secret", wantErr: ErrInvalidCredentials,
+			wantFilter: `(uid=ivan\29\28uid=\2a)`},
+		{name: "первый вход без создания пользователя", username: "petr", password: "petr-secret", wantErr: ErrInvalidCredentials,
+			wantFilter: "(uid=petr)"},
+		{name: "создание пользователя при первом входе", username: "petr", password: "petr-secret", autoProvision: true,
+			wantUser: "petr", wantFilter: "(uid=petr)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hasher := &BcryptHasher{Cost: bcrypt.MinCost}
+			users := UserManagerNewInstance(repository.MemoryUserStoreNewInstance(), nil, nil, nil, PasswordPolicyFromEnv(nil, hasher), nil, hasher, nil)
+			if _, err := users.ProvisionExternalUser(context.Background(), &ExternalUser{Username: "ivan", Email: "ivan@example.com", Active: true}); err != nil {
+				t.Fatal(err)
+			}
+			identities := &memoryIdentityStore{identities: map[string]int64{}}
+			auth := NewLDAPAuthenticator(&LDAPConfig{
+				URL:               stub.url(),
+				BaseDN:            "ou=people,dc=example,dc=com",
+				UserFilter:        "(uid=%s)",
+				UsernameAttribute: "uid",
+				EmailAttribute:    "mail",
+				IDAttribute:       "entryUUID",
+				AutoProvision:     test.autoProvision,
+				Timeout:           5 * time.Second,
+			}, users, identities)
+
+			stub.m.Lock()
+			searches := len(stub.filters)
+			stub.m.Unlock()
+
+			user, err := auth.Authenticate(context.Background(), test.username, test.password)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("Ошибка %v, ожидалась %v", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if user.Username != test.wantUser {
+				t.Fatalf("Пользователь %s, ожидался %s", user.Username, test.wantUser)
+			}
+
+			stub.m.Lock()
+			filters := stub.filters[searches:]
+			stub.m.Unlock()
+			if test.wantFilter == "" && len(filters) != 0 {
+				t.Errorf("Выполнен поиск %v", filters)
+			}
+			if test.wantFilter != "" && (len(filters) != 1 || filters[0] != test.wantFilter) {
+				t.Errorf("Фильтры поиска %v, ожидался %s", filters, test.wantFilter)
+			}
+
+			if test.wantErr != nil {
+				return
+			}
+			// Запись каталога связана с пользователем; повторный вход находит его по связи
+			subject := test.wantUser
+			for _, entry := range stub.entries {
+				if entry.attributes["uid"] == test.wantUser {
+					subject = entry.attributes["entryUUID"]
+				}
+			}
+			if userID, _ := identities.GetIdentityUserID(context.Background(), ldapIdentityProvider, subject); userID != user.ID {
+				t.Errorf("Связь с записью каталога %d, ожидалась %d", userID, user.ID)
+			}
+			again, err := auth.Authenticate(context.Background(), test.username, test.password)
+			if err != nil || again.ID != user.ID {
+				t.Errorf("Повторный вход: %v, %+v", err, again)
+			}
+		})
+	}
+}
+
+// Привязка с пустым паролем не доходит до каталога, где прошла бы как анонимная
+func TestLDAPEmptyPasswordNotSent(t *testing.T) {
+	stub := startLDAPStub(t, ldapStubEntry{dn: "uid=ivan,ou=people,dc=example,dc=com", password: "ivan-secret",
+		attributes: map[string]string{"uid": "ivan", "mail": "ivan@example.com"}})
+	auth := NewLDAPAuthenticator(&LDAPConfig{URL: stub.url(), UserFilter: "(uid=%s)", UsernameAttribute: "uid",
+		EmailAttribute: "mail", Timeout: 5 * time.Second}, nil, nil)
+
+	if _, err := auth.Authenticate(context.Background(), "ivan", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Ошибка %v, ожидалась %v", err, ErrInvalidCredentials)
+	}
+
+	stub.m.Lock()
+	defer stub.m.Unlock()
+	if stub.conns != 0 || len(stub.binds) != 0 {
+		t.Errorf("Подключений к каталогу %d, привязок %v", stub.conns, stub.binds)
+	}
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"rest_module/repository"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Причины неудачного входа для метрики
+const (
+	FailureInvalidCredentials = "invalid_credentials"
+	FailureInvalidMFACode     = "invalid_mfa_code"
+	FailureLocked             = "locked"
+)
+
+// Ошибка входа при действующей блокировке
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (err *LockoutError) Error() string {
+	return fmt.Sprintf("Вход временно заблокирован, повторите через %d с", int(err.RetryAfter.Seconds()))
+}
+
+// Защита входа от подбора пароля: счетчики неудач по логину и по IP с экспоненциальной блокировкой
+type LoginGuard struct {
+	repository    *repository.LoginFailureRepository // репозиторий неудачных попыток
+	userThreshold int                                // неудач по логину до первой блокировки
+	ipThreshold   int                                // неудач с одного адреса до первой блокировки
+	baseDuration  time.Duration                      // длительность первой блокировки
+	maxDuration   time.Duration                      // предельная длительность блокировки
+	window        time.Duration                      // период, после которого счетчик обнуляется
+	failedLogins  *prometheus.CounterVec             // счетчик неудачных входов
+
+	limits          *repository.RateLimitRepository // репозиторий ограничений частоты запросов
+	magicLinkLimit  int                             // запросов ссылки для входа на адрес почты за период
+	magicLinkWindow time.Duration                   // период ограничения запросов ссылки для входа
+
+	dbTimeout operationTimeout // ограничение времени операции с БД
+}
+
+// Конструктор защиты входа
+func LoginGuardNewInstance(repository *repository.LoginFailureRepository, limits *repository.RateLimitRepository) *LoginGuard {
+	guard := LoginGuard{}
+	guard.repository = repository
+	guard.limits = limits
+	guard.userThreshold = GetEnvInt("LOCKOUT_USER_THRESHOLD", 5)
+	guard.ipThreshold = GetEnvInt("LOCKOUT_IP_THRESHOLD", 20)
+	guard.baseDuration = GetEnvDuration("LOCKOUT_BASE_DURATION", time.Minute)
+	guard.maxDuration = GetEnvDuration("LOCKOUT_MAX_DURATION", time.Hour)
+	guard.window = GetEnvDuration("LOCKOUT_WINDOW", 15*time.Minute)
+	guard.magicLinkLimit = GetEnvInt("MAGIC_LINK_RATE_LIMIT", 3)
+	guard.magicLinkWindow = GetEnvDuration("MAGIC_LINK_RATE_WINDOW", 15*time.Minute)
+	guard.dbTimeout = operationTimeoutFromEnv()
+	guard.failedLogins = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_failed_logins_total",
+			Help: "Total number of failed login attempts",
+		},
+		[]string{"reason"},
+	)
+	prometheus.MustRegister(guard.failedLogins)
+	return &guard
+}
+
+// Проверка блокировки логина и адреса клиента перед входом
+func (guard *LoginGuard) Check(ctx context.Context, username, ip string) error {
+	ctx, cancel := guard.dbTimeout.apply(ctx)
+	defer cancel()
+	for _, key := range []string{userKey(username), ipKey(ip)} {
+		status, err := guard.repository.GetStatus(ctx, key)
+		if err != nil {
+			return fmt.Errorf("Ошибка проверки блокировки %w", err)
+		}
+
+		if status.Locked {
+			guard.failedLogins.WithLabelValues(FailureLocked).Inc()
+			return &LockoutError{RetryAfter: time.Until(*status.LockedUntil).Round(time.Second)}
+		}
+	}
+
+	return nil
+}
+
+// Учет неудачного входа
+func (guard *LoginGuard) RecordFailure(ctx context.Context, username, ip, reason string) {
+	// Отключение клиента не должно отменять учет неудачной попытки
+	ctx, cancel := guard.dbTimeout.apply(context.WithoutCancel(ctx))
+	defer cancel()
+	guard.failedLogins.WithLabelValues(reason).Inc()
+	guard.record(ctx, userKey(username), guard.userThreshold)
+	guard.record(ctx, ipKey(ip), guard.ipThreshold)
+}
+
+// Сброс счетчика логина после успешного входа; счетчик адреса сохраняется
+func (guard *LoginGuard) RecordSuccess(ctx context.Context, username string) {
+	ctx, cancel := guard.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := guard.repository.Reset(ctx, userKey(username)); err != nil {
+		log.Println("LoginGuard", err)
+	}
+}
+
+// Состояние блокировки логина
+func (guard *LoginGuard) Status(ctx context.Context, username string) (*LockStatus, error) {
+	ctx, cancel := guard.dbTimeout.apply(ctx)
+	defer cancel()
+	status, err := guard.repository.GetStatus(ctx, userKey(username))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка проверки блокировки %w", err)
+	}
+
+	return status, nil
+}
+
+// Снятие блокировки логина
+func (guard *LoginGuard) Unlock(ctx context.Context, username string) error {
+	go log.Println("Снятие блокировки входа")
+	ctx, cancel := guard.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := guard.repository.Reset(ctx, userKey(username)); err != nil {
+		return fmt.Errorf("Ошибка снятия блокировки %w", err)
+	}
+
+	return nil
+}
+
+// Ограничение частоты запросов ссылки для входа на один адрес почты, известный или нет.
+// Счетчики хранятся отдельно от неудач входа: сброс после успешного входа и снятие блокировки их не затрагивают
+func (guard *LoginGuard) LimitMagicLink(ctx context.Context, email string) error {
+	ctx, cancel := guard.dbTimeout.apply(ctx)
+	defer cancel()
+	requests, windowStartedAt, err := guard.limits.Hit(ctx, magicLinkKey(email), guard.magicLinkWindow)
+	if err != nil {
+		return fmt.Errorf("Ошибка учета запроса ссылки %w", err)
+	}
+	if requests > guard.magicLinkLimit {
+		return &LockoutError{RetryAfter: time.Until(windowStartedAt.Add(guard.magicLinkWindow)).Round(time.Second)}
+	}
+
+	return nil
+}
+
+func (guard *LoginGuard) record(ctx context.Context, key string, threshold int) {
+	failures, err := guard.repository.RecordFailure(ctx, key, guard.window)
+	if err != nil {
+		log.Println("LoginGuard", err)
+		return
+	}
+
+	if failures < threshold {
+		return
+	}
+
+	if err = guard.repository.Lock(ctx, key, time.Now().Add(guard.lockDuration(failures-threshold))); err != nil {
+		log.Println("LoginGuard", err)
+	}
+}
+
+// Длительность блокировки удваивается с каждой неудачей сверх порога
+func (guard *LoginGuard) lockDuration(excess int) time.Duration {
+	duration := guard.baseDuration
+	for i := 0; i < excess && duration < guard.maxDuration; i++ {
+		duration *= 2
+	}
+
+	return min(duration, guard.maxDuration)
+}
+
+func userKey(username string) string {
+	return truncate("user:"+strings.ToLower(username), 128)
+}
+
+func ipKey(ip string) string {
+	return truncate("ip:"+ip, 128)
+}
+
+func magicLinkKey(email string) string {
+	return truncate("magic_link:"+strings.ToLower(strings.TrimSpace(email)), 128)
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"rest_module/repository"
+)
+
+// Блокировка удваивается с каждой неудачей сверх порога и не превышает предела
+func TestLockDuration(t *testing.T) {
+	guard := &LoginGuard{baseDuration: time.Minute, maxDuration: time.Hour}
+	tests := []struct {
+		excess   int
+		expected time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{3, 8 * time.Minute},
+		{5, 32 * time.Minute},
+		{6, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, test := range tests {
+		if duration := guard.lockDuration(test.excess); duration != test.expected {
+			t.Errorf("lockDuration(%d) = %s, ожидалось %s", test.excess, duration, test.expected)
+		}
+	}
+}
+
+// Счетчик логина не зависит от регистра, длинные ключи обрезаются до размера столбца
+func TestLoginGuardKeys(t *testing.T) {
+	if userKey("Ivan") != userKey("ivan") {
+		t.Errorf("Ключи %q и %q различаются", userKey("Ivan"), userKey("ivan"))
+	}
+	if userKey("ivan") == ipKey("ivan") {
+		t.Error("Ключи логина и адреса совпадают")
+	}
+	if key := userKey(strings.Repeat("a", 500)); len(key) > 128 {
+		t.Errorf("Длина ключа %d", len(key))
+	}
+}
+
+// Ограничение ссылок для входа считается по адресу почты без учета регистра и пробелов и отдельно от логина
+func TestMagicLinkKey(t *testing.T) {
+	if magicLinkKey(" Ivan@Example.com ") != magicLinkKey("ivan@example.com") {
+		t.Errorf("Ключи %q и %q различаются", magicLinkKey(" Ivan@Example.com "), magicLinkKey("ivan@example.com"))
+	}
+	if magicLinkKey("ivan@example.com") == userKey("ivan@example.com") {
+		t.Error("Ключ ссылки для входа совпадает с ключом логина")
+	}
+}
+
+// Ограничение запросов ссылки для входа не сбрасывается успешным входом и снятием блокировки
+func TestLimitMagicLink(t *testing.T) {
+	db := testDatabase(t)
+	t.Setenv("MAGIC_LINK_RATE_LIMIT", "2")
+	t.Setenv("MAGIC_LINK_RATE_WINDOW", "1m")
+	ctx := context.Background()
+
+	guard := LoginGuardNewInstance(repository.InitLoginFailureRepository(db), repository.InitRateLimitRepository(db))
+	email := fmt.Sprintf("limit%d@example.com", time.Now().UnixNano())
+	for i := 0; i < 2; i++ {
+		if err := guard.LimitMagicLink(ctx, email); err != nil {
+			t.Fatalf("Запрос %d: %v", i+1, err)
+		}
+	}
+
+	guard.RecordSuccess(ctx, email)
+	if err := guard.Unlock(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+
+	var lockout *LockoutError
+	if err := guard.LimitMagicLink(ctx, email); !errors.As(err, &lockout) {
+		t.Fatalf("Ошибка %v, ожидалась блокировка", err)
+	}
+	if lockout.RetryAfter <= 0 || lockout.RetryAfter > time.Minute {
+		t.Errorf("RetryAfter %s", lockout.RetryAfter)
+	}
+	if status, err := guard.Status(ctx, email); err != nil || status.Failures != 0 {
+		t.Errorf("Счетчик неудач входа %+v, %v", status, err)
+	}
+}
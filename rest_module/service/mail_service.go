@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-mail/mail/v2"
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/utils"
+)
+
+// Отправка писем пользователям
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Отправка писем через SMTP-сервер
+type SMTPMailer struct {
+	dialer *mail.Dialer
+	from   string
+}
+
+// Вывод писем в журнал, когда SMTP-сервер не настроен
+type LogMailer struct{}
+
+// Почтовый сервис по переменным окружения; без SMTP_HOST письма только пишутся в журнал
+func NewMailer() Mailer {
+	host := GetEnv("SMTP_HOST", "")
+	if host == "" {
+		log.Warn("SMTP_HOST не задан, письма будут выводиться в журнал")
+		return &LogMailer{}
+	}
+
+	port, err := strconv.Atoi(GetEnv("SMTP_PORT", "587"))
+	if err != nil {
+		panic(err)
+	}
+
+	dialer := mail.NewDialer(host, port, GetEnv("SMTP_USER", ""), GetEnv("SMTP_PASS", ""))
+	dialer.SSL = GetEnv("SMTP_SSL", "false") == "true"
+	switch GetEnv("SMTP_STARTTLS", "opportunistic") {
+	case "mandatory":
+		dialer.StartTLSPolicy = mail.MandatoryStartTLS
+	case "none":
+		dialer.StartTLSPolicy = mail.NoStartTLS
+	default:
+		dialer.StartTLSPolicy = mail.OpportunisticStartTLS
+	}
+
+	return &SMTPMailer{
+		dialer: dialer,
+		from:   GetEnv("SMTP_FROM", "no-reply@localhost"),
+	}
+}
+
+func (mailer *SMTPMailer) Send(to, subject, body string) error {
+	message := mail.NewMessage()
+	message.SetHeader("From", mailer.from)
+	message.SetHeader("To", to)
+	message.SetHeader("Subject", subject)
+	message.SetBody("text/plain", body)
+
+	if err := mailer.dialer.DialAndSend(message); err != nil {
+		return fmt.Errorf("Ошибка отправки письма %w", err)
+	}
+
+	return nil
+}
+
+func (mailer *LogMailer) Send(to, subject, body string) error {
+	log.Printf("Письмо для %s: %s\n%s", to, subject, body)
+	return nil
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	. "rest_module/model"
+	"rest_module/repository"
+)
+
+// Письмо, принятое заглушкой SMTP-сервера
+type smtpMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// SMTP-сервер в процессе теста: принимает письма без аутентификации и TLS
+type smtpStub struct {
+	listener net.Listener
+	messages chan smtpMessage
+}
+
+func startSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	stub := &smtpStub{listener: listener, messages: make(chan smtpMessage, 10)}
+	go stub.serve()
+	return stub
+}
+
+// Настройка NewMailer на заглушку
+func (stub *smtpStub) configure(t *testing.T) {
+	host, port, _ := net.SplitHostPort(stub.listener.Addr().String())
+	t.Setenv("SMTP_HOST", host)
+	t.Setenv("SMTP_PORT", port)
+	t.Setenv("SMTP_STARTTLS", "none")
+	t.Setenv("SMTP_FROM", "no-reply@example.com")
+}
+
+func (stub *smtpStub) serve() {
+	for {
+		conn, err := stub.listener.Accept()
+		if err != nil {
+			return
+		}
+		go stub.handle(conn)
+	}
+}
+
+func (stub *smtpStub) handle(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+
+	message := smtpMessage{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			text.PrintfLine("250-localhost")
+			text.PrintfLine("250 8BITMIME")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message.from = smtpPath(line)
+			text.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.to = append(message.to, smtpPath(line))
+			text.PrintfLine("250 OK")
+		case command == "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			if message.data, err = text.ReadDotBytes(); err != nil {
+				return
+			}
+			stub.messages <- message
+			message = smtpMessage{}
+			text.PrintfLine("250 OK")
+		case command == "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		case command == "RSET":
+			message = smtpMessage{}
+			text.PrintfLine("250 OK")
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// Адрес из команды MAIL FROM:<адрес> или RCPT TO:<адрес> без параметров расширений
+func smtpPath(line string) string {
+	start, end := strings.Index(line, "<"), strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return line[start+1 : end]
+}
+
+// Ожидание следующего письма
+func (stub *smtpStub) receive(t *testing.T) smtpMessage {
+	t.Helper()
+	select {
+	case message := <-stub.messages:
+		return message
+	case <-time.After(10 * time.Second):
+		t.Fatal("Письмо не получено")
+		return smtpMessage{}
+	}
+}
+
+// Заголовки и раскодированный текст письма
+func parseSMTPMessage(t *testing.T, message smtpMessage) (mail.Header, string) {
+	t.Helper()
+	parsed, err := mail.ReadMessage(bytes.NewReader(message.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := parsed.Body
+	if strings.EqualFold(parsed.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		body = quotedprintable.NewReader(body)
+	}
+	text, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed.Header, string(text)
+}
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// Токен из ссылки в тексте письма
+func tokenFromLink(t *testing.T, text, base string) string {
+	t.Helper()
+	link := linkPattern.FindString(text)
+	if !strings.HasPrefix(link, base) {
+		t.Fatalf("В письме нет ссылки %s: %q", base, text)
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := parsed.Query().Get("token")
+	if token == "" {
+		t.Fatalf("В ссылке %s нет токена", link)
+	}
+
+	return token
+}
+
+func TestPasswordResetMail(t *testing.T) {
+	stub := startSMTPStub(t)
+	stub.configure(t)
+	t.Setenv("PASSWORD_RESET_URL", "https://auth.example.com/reset-password?lang=ru")
+
+	notifier := NotifierNewInstance(NewMailer())
+	user := &User{ID: 1, Username: "ivan", Email: "ivan@example.com"}
+	token := randomToken(32)
+	if err := notifier.SendPasswordReset(user, token); err != nil {
+		t.Fatal(err)
+	}
+
+	message := stub.receive(t)
+	if message.from != "no-reply@example.com" {
+		t.Errorf("Отправитель %q", message.from)
+	}
+	if len(message.to) != 1 || message.to[0] != user.Email {
+		t.Errorf("Получатели %v, ожидался %s", message.to, user.Email)
+	}
+
+	header, text := parseSMTPMessage(t, message)
+	if header.Get("To") != user.Email {
+		t.Errorf("Заголовок To %q", header.Get("To"))
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || subject != "Сброс пароля" {
+		t.Errorf("Тема письма %q", subject)
+	}
+
+	link := linkPattern.FindString(text)
+	expected := "https://auth.example.com/reset-password?lang=ru&token=" + token
+	if link != expected {
+		t.Errorf("Ссылка %q, ожидалась %q", link, expected)
+	}
+}
+
+func TestPasswordResetMailDeliveryError(t *testing.T) {
+	stub := startSMTPStub(t)
+	stub.configure(t)
+	stub.listener.Close()
+
+	err := NotifierNewInstance(NewMailer()).SendPasswordReset(&User{Username: "ivan", Email: "ivan@example.com"}, "token")
+	if err == nil {
+		t.Fatal("Ошибка отправки не возвращена")
+	}
+}
+
+// Ссылка из письма сбрасывает пароль один раз и только до истечения срока действия
+func TestPasswordResetToken(t *testing.T) {
+	db := testDatabase(t)
+	stub := startSMTPStub(t)
+	stub.configure(t)
+	t.Setenv("PASSWORD_RESET_URL", "https://auth.example.com/reset-password")
+	ctx := context.Background()
+
+	users := repository.InitUserRepository(db)
+	history := repository.InitPasswordHistoryRepository(db)
+	hasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	links := UserTokenManagerNewInstance(repository.InitUserTokenRepository(db))
+	notifier := NotifierNewInstance(NewMailer())
+	manager := UserManagerNewInstance(users, repository.InitSessionRepository(db), links, notifier,
+		PasswordPolicyFromEnv(history, hasher), history, hasher, nil)
+	auth := AuthServiceNewInstance(manager, nil, nil, nil, nil, links, notifier, nil, nil, nil, nil, nil)
+
+	name := fmt.Sprintf("reset%d", time.Now().UnixNano())
+	hash, _ := hasher.Hash("Old-password1")
+	id, err := users.InsertUser(ctx, &User{Username: name, Password: hash, Email: name + "@example.com", EmailVerified: true, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { users.DeleteUserById(context.Background(), id) })
+
+	auth.ForgotPassword(name + "@example.com")
+	message := stub.receive(t)
+	if len(message.to) != 1 || message.to[0] != name+"@example.com" {
+		t.Fatalf("Получатели %v", message.to)
+	}
+	_, text := parseSMTPMessage(t, message)
+	token := tokenFromLink(t, text, "https://auth.example.com/reset-password?token=")
+
+	if err = auth.ResetPassword(ctx, token, "New-password2"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasher.Verify("New-password2", user.Password) {
+		t.Error("Пароль не изменен")
+	}
+
+	if err = auth.ResetPassword(ctx, token, "Third-password3"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Повторное использование ссылки: %v", err)
+	}
+
+	expired, err := links.Issue(ctx, id, TokenPurposePasswordReset, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.ResetPassword(ctx, expired, "Third-password3"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Ссылка с истекшим сроком: %v", err)
+	}
+}
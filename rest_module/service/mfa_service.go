@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"rest_module/repository"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+const recoveryCodeCount = 10
+
+// Результат начала подключения TOTP
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// Сервис двухфакторной аутентификации
+type MFAManager struct {
+	users     repository.UserStore // хранилище пользователей
+	totp      repository.TOTPStore // настройки TOTP пользователей
+	box       *SecretBox           // шифрование секретов TOTP
+	issuer    string               // имя сервиса в приложении-аутентификаторе
+	dbTimeout operationTimeout     // ограничение времени операции с БД
+}
+
+// Конструктор сервиса двухфакторной аутентификации
+func MFAManagerNewInstance(users repository.UserStore, totp repository.TOTPStore, box *SecretBox) *MFAManager {
+	manager := MFAManager{}
+	manager.users = users
+	manager.totp = totp
+	manager.box = box
+	manager.issuer = GetEnv("MFA_ISSUER", "UserManagement")
+	manager.dbTimeout = operationTimeoutFromEnv()
+	return &manager
+}
+
+// Начало подключения TOTP: новый секрет сохраняется, но не действует до подтверждения
+func (manager *MFAManager) EnrollTOTP(ctx context.Context, userID int64) (*TOTPEnrollment, error) {
+	go log.Println("Подключение TOTP")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	user, settings, err := manager.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.Enabled {
+		return nil, fmt.Errorf("Двухфакторная аутентификация уже подключена")
+	}
+
+	secret := generateTOTPSecret()
+	encrypted, err := manager.box.Encrypt([]byte(secret))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка шифрования секрета %w", err)
+	}
+
+	if err = manager.totp.SetTOTPSecret(ctx, userID, encrypted); err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения секрета %w", err)
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: totpURI(manager.issuer, user.Username, secret)}, nil
+}
+
+// Подтверждение подключения TOTP кодом; возвращает коды восстановления
+func (manager *MFAManager) ConfirmTOTP(ctx context.Context, userID int64, code string) ([]string, error) {
+	go log.Println("Подтверждение подключения TOTP")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	_, settings, err := manager.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.Enabled {
+		return nil, fmt.Errorf("Двухфакторная аутентификация уже подключена")
+	}
+	if settings.Secret == "" {
+		return nil, fmt.Errorf("Подключение TOTP не начато")
+	}
+
+	step, err := manager.checkCode(settings, code)
+	if err != nil {
+		return nil, err
+	}
+	if step == 0 {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = manager.totp.EnableTOTP(ctx, userID, step, hashes); err != nil {
+		return nil, fmt.Errorf("Ошибка подключения TOTP %w", err)
+	}
+
+	return codes, nil
+}
+
+// Отключение TOTP
+func (manager *MFAManager) DisableTOTP(ctx context.Context, userID int64) error {
+	go log.Println("Отключение TOTP")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if _, _, err := manager.load(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := manager.totp.DisableTOTP(ctx, userID); err != nil {
+		return fmt.Errorf("Ошибка отключения TOTP %w", err)
+	}
+
+	return nil
+}
+
+// Выпуск нового набора кодов восстановления взамен старого
+func (manager *MFAManager) RegenerateRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	go log.Println("Выпуск кодов восстановления")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	_, settings, err := manager.load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !settings.Enabled {
+		return nil, fmt.Errorf("Двухфакторная аутентификация не подключена")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = manager.totp.SetRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения кодов восстановления %w", err)
+	}
+
+	return codes, nil
+}
+
+// Проверка второго фактора: кода TOTP или одноразового кода восстановления
+func (manager *MFAManager) Verify(ctx context.Context, userID int64, code string) error {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	_, settings, err := manager.load(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !settings.Enabled {
+		return ErrInvalidMFACode
+	}
+
+	code = strings.TrimSpace(code)
+	step, err := manager.checkCode(settings, code)
+	if err != nil {
+		return err
+	}
+
+	if step != 0 {
+		// Каждый код TOTP принимается только один раз
+		accepted, err := manager.totp.AdvanceTOTPStep(ctx, userID, step)
+		if err != nil {
+			return fmt.Errorf("Ошибка проверки кода %w", err)
+		}
+		if !accepted {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	for _, hash := range settings.RecoveryCodes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(normalizeRecoveryCode(code))) == nil {
+			removed, err := manager.totp.RemoveRecoveryCode(ctx, userID, hash)
+			if err != nil {
+				return fmt.Errorf("Ошибка проверки кода %w", err)
+			}
+			if !removed {
+				return ErrInvalidMFACode
+			}
+			return nil
+		}
+	}
+
+	return ErrInvalidMFACode
+}
+
+func (manager *MFAManager) load(ctx context.Context, userID int64) (*User, *TOTPSettings, error) {
+	user, err := manager.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
+	if user == nil {
+		return nil, nil, fmt.Errorf("Пользователь с таким идентификатором не найден")
+	}
+
+	settings, err := manager.totp.GetTOTPSettings(ctx, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Ошибка чтения настроек TOTP %w", err)
+	}
+	if settings == nil {
+		return nil, nil, fmt.Errorf("Ошибка чтения настроек TOTP")
+	}
+
+	return user, settings, nil
+}
+
+// Номер шага, которому соответствует код, или 0
+func (manager *MFAManager) checkCode(settings *TOTPSettings, code string) (int64, error) {
+	if settings.Secret == "" {
+		return 0, nil
+	}
+
+	secret, err := manager.box.Decrypt(settings.Secret)
+	if err != nil {
+		return 0, err
+	}
+
+	step := validateTOTP(string(secret), code, time.Now())
+	if step <= settings.LastStep {
+		return 0, nil
+	}
+
+	return step, nil
+}
+
+// Коды восстановления вида xxxxx-xxxxx и их хеши
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := strings.ToLower(totpEncoding.EncodeToString(randomBytes(7)))[:10]
+		code := raw[:5] + "-" + raw[5:]
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Ошибка выпуска кодов восстановления %w", err)
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, string(hash))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Письма пользователям со ссылками на действия с учетной записью
+type Notifier struct {
+	mailer               Mailer
+	passwordResetURL     string
+	emailVerificationURL string
+	magicLinkURL         string
+	invitationURL        string
+}
+
+// Конструктор сервиса уведомлений
+func NotifierNewInstance(mailer Mailer) *Notifier {
+	notifier := Notifier{}
+	notifier.mailer = mailer
+	notifier.passwordResetURL = GetEnv("PASSWORD_RESET_URL", "http://localhost:8080/reset-password")
+	notifier.emailVerificationURL = GetEnv("EMAIL_VERIFICATION_URL", "http://localhost:8080/api/auth/email/verify")
+	notifier.magicLinkURL = GetEnv("MAGIC_LINK_URL", "http://localhost:8080/api/auth/magic-link/verify")
+	notifier.invitationURL = GetEnv("INVITATION_URL", "http://localhost:8080/accept-invitation")
+	return &notifier
+}
+
+// Письмо со ссылкой сброса пароля
+func (notifier *Notifier) SendPasswordReset(user *User, token string) error {
+	link := withToken(notifier.passwordResetURL, token)
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Для сброса пароля перейдите по ссылке:\n%s\n\n"+
+		"Если вы не запрашивали сброс пароля, просто проигнорируйте это письмо.", user.Username, link)
+
+	return notifier.mailer.Send(user.Email, "Сброс пароля", body)
+}
+
+// Письмо со ссылкой подтверждения адреса почты
+func (notifier *Notifier) SendEmailVerification(user *User, token string) error {
+	link := withToken(notifier.emailVerificationURL, token)
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Для подтверждения адреса почты перейдите по ссылке:\n%s", user.Username, link)
+
+	return notifier.mailer.Send(user.Email, "Подтверждение адреса почты", body)
+}
+
+// Письмо со ссылкой для входа без пароля
+func (notifier *Notifier) SendMagicLink(user *User, token string, ttl time.Duration) error {
+	link := withToken(notifier.magicLinkURL, token)
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Для входа перейдите по ссылке:\n%s\n\n"+
+		"Ссылка действует %d мин и может быть использована один раз. "+
+		"Если вы не запрашивали вход, просто проигнорируйте это письмо.", user.Username, link, int(ttl.Minutes()))
+
+	return notifier.mailer.Send(user.Email, "Вход по ссылке", body)
+}
+
+// Письмо с приглашением и ссылкой для установки пароля
+func (notifier *Notifier) SendInvitation(user *User, token string, ttl time.Duration) error {
+	link := withToken(notifier.invitationURL, token)
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Вас пригласили в сервис. Чтобы задать пароль и войти, перейдите по ссылке:\n%s\n\n"+
+		"Приглашение действует до %s.", user.Username, link, time.Now().Add(ttl).Format("02.01.2006 15:04 MST"))
+
+	return notifier.mailer.Send(user.Email, "Приглашение", body)
+}
+
+// Ссылка с токеном в параметре token
+func withToken(base, token string) string {
+	link, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(token)
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
@@ -0,0 +1,20 @@
+package service
+
+import "testing"
+
+func TestWithToken(t *testing.T) {
+	tests := []struct {
+		base     string
+		expected string
+	}{
+		{"http://localhost:8080/reset-password", "http://localhost:8080/reset-password?token=abc"},
+		{"https://auth.example.com/reset?lang=ru", "https://auth.example.com/reset?lang=ru&token=abc"},
+		{"https://auth.example.com/reset?token=old", "https://auth.example.com/reset?token=abc"},
+	}
+
+	for _, test := range tests {
+		if link := withToken(test.base, "abc"); link != test.expected {
+			t.Errorf("withToken(%q) = %q, ожидалось %q", test.base, link, test.expected)
+		}
+	}
+}
@@ -0,0 +1,671 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"rest_module/repository"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Поддерживаемые типы грантов OAuth2
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// Области действия OpenID Connect; остальные области совпадают с именами разрешений
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var oidcScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// Коды ошибок OAuth2 (RFC 6749)
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthAccessDenied            = "access_denied"
+)
+
+// Ошибка протокола OAuth2
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (err *OAuthError) Error() string {
+	return err.Code + ": " + err.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// Зарегистрированный клиент; секрет возвращается только при регистрации
+type RegisteredClient struct {
+	*OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// Параметры запроса авторизации
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// Проверенный запрос авторизации
+type AuthorizeContext struct {
+	Client      *OAuthClient
+	RedirectURI string
+	Scopes      []string
+	State       string
+}
+
+// Адрес возврата клиенту с ошибкой авторизации
+func (ctx *AuthorizeContext) ErrorRedirect(err *OAuthError) string {
+	query := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		query.Set("error_description", err.Description)
+	}
+	return ctx.redirect(query)
+}
+
+func (ctx *AuthorizeContext) redirect(query url.Values) string {
+	if ctx.State != "" {
+		query.Set("state", ctx.State)
+	}
+
+	target, _ := url.Parse(ctx.RedirectURI)
+	values := target.Query()
+	for key, value := range query {
+		values[key] = value
+	}
+	target.RawQuery = values.Encode()
+	return target.String()
+}
+
+// Сведения о токене (RFC 7662)
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+}
+
+// Сведения о пользователе для /userinfo
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// Описание провайдера OpenID Connect (OpenID Connect Discovery 1.0)
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// Сервер авторизации OAuth2
+type OAuthServer struct {
+	repository *repository.OAuthRepository // репозиторий клиентов и кодов
+	auth       *AuthService                // проверка учетных данных пользователя
+	users      *UserManager                // сервис пользователей
+	roles      *RoleManager                // сервис ролей
+	sessions   *SessionManager             // сервис сессий
+	tokens     *TokenService               // сервис токенов
+	codeTTL    time.Duration               // срок действия кода авторизации
+	dbTimeout  operationTimeout            // ограничение времени операции с БД
+}
+
+// Конструктор сервера авторизации OAuth2
+func OAuthServerNewInstance(repository *repository.OAuthRepository, auth *AuthService, users *UserManager, roles *RoleManager,
+	sessions *SessionManager, tokens *TokenService) *OAuthServer {
+	server := OAuthServer{}
+	server.repository = repository
+	server.auth = auth
+	server.users = users
+	server.roles = roles
+	server.sessions = sessions
+	server.tokens = tokens
+	server.codeTTL = GetEnvDuration("OAUTH_CODE_TTL", time.Minute)
+	server.dbTimeout = operationTimeoutFromEnv()
+	return &server
+}
+
+// Регистрация клиента; для конфиденциального клиента выпускается секрет
+func (server *OAuthServer) RegisterClient(ctx context.Context, name string, redirectURIs, grantTypes, scopes []string, confidential bool) (*RegisteredClient, error) {
+	go log.Println("Регистрация клиента OAuth2")
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, fmt.Errorf("Название клиента должно содержать от 1 до 100 символов")
+	}
+
+	if len(grantTypes) == 0 {
+		grantTypes = []string{GrantAuthorizationCode}
+	}
+	for _, grant := range grantTypes {
+		switch grant {
+		case GrantAuthorizationCode:
+			if len(redirectURIs) == 0 {
+				return nil, fmt.Errorf("Для гранта %s нужен хотя бы один адрес возврата", grant)
+			}
+		case GrantClientCredentials:
+			if !confidential {
+				return nil, fmt.Errorf("Грант %s доступен только конфиденциальным клиентам", grant)
+			}
+		default:
+			return nil, fmt.Errorf("Грант %s не поддерживается", grant)
+		}
+	}
+
+	for _, redirectURI := range redirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return nil, err
+		}
+	}
+
+	permissions, err := server.roles.AllPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(permissions, scope) && !slices.Contains(oidcScopes, scope) {
+			return nil, fmt.Errorf("Область действия %s не существует", scope)
+		}
+	}
+
+	client := OAuthClient{
+		ClientID:     hex.EncodeToString(randomBytes(12)),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		GrantTypes:   grantTypes,
+		Scopes:       scopes,
+		Confidential: confidential,
+		CreatedAt:    time.Now(),
+	}
+
+	secret, secretHash := "", ""
+	if confidential {
+		secret = randomToken(32)
+		secretHash = hashToken(secret)
+	}
+
+	if client.ID, err = server.repository.InsertClient(ctx, &client, secretHash); err != nil {
+		return nil, fmt.Errorf("Ошибка регистрации клиента %w", err)
+	}
+
+	return &RegisteredClient{OAuthClient: &client, ClientSecret: secret}, nil
+}
+
+// Все действующие клиенты
+func (server *OAuthServer) FindAllClients(ctx context.Context) (*[]OAuthClient, error) {
+	go log.Println("Чтение клиентов OAuth2")
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	clients, err := server.repository.GetAllClients(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения клиентов %w", err)
+	}
+
+	return clients, nil
+}
+
+// Отзыв клиента; все его сессии завершаются
+func (server *OAuthServer) RevokeClient(ctx context.Context, clientID string) error {
+	go log.Println("Отзыв клиента OAuth2")
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	revoked, err := server.repository.RevokeClient(ctx, clientID)
+	if err != nil {
+		return fmt.Errorf("Ошибка отзыва клиента %w", err)
+	}
+	if !revoked {
+		return fmt.Errorf("Клиент не найден")
+	}
+
+	return nil
+}
+
+// Проверка запроса авторизации. Без контекста ошибку нужно показать пользователю,
+// с контекстом - вернуть клиенту через адрес возврата
+func (server *OAuthServer) ValidateAuthorize(ctx context.Context, req *AuthorizeRequest) (*AuthorizeContext, error) {
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	client, _, err := server.repository.GetClient(ctx, req.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска клиента %w", err)
+	}
+	if client == nil {
+		return nil, oauthError(OAuthInvalidClient, "Клиент не найден")
+	}
+
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, oauthError(OAuthInvalidRequest, "Адрес возврата не зарегистрирован")
+	}
+
+	authorize := AuthorizeContext{Client: client, RedirectURI: redirectURI, State: req.State}
+
+	if req.ResponseType != "code" {
+		return &authorize, oauthError(OAuthUnsupportedResponseType, "Поддерживается только response_type=code")
+	}
+	if !slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+		return &authorize, oauthError(OAuthUnauthorizedClient, "Клиенту не разрешен грант authorization_code")
+	}
+
+	// PKCE обязателен для всех клиентов, допускается только метод S256
+	if req.CodeChallengeMethod != "S256" || len(req.CodeChallenge) < 43 || len(req.CodeChallenge) > 128 {
+		return &authorize, oauthError(OAuthInvalidRequest, "Требуется code_challenge с методом S256")
+	}
+
+	authorize.Scopes, err = requestedScopes(req.Scope, client.Scopes)
+	if err != nil {
+		return &authorize, err
+	}
+
+	return &authorize, nil
+}
+
+// Вход пользователя на странице авторизации и выпуск кода; возвращает адрес возврата клиенту
+func (server *OAuthServer) Authorize(ctx context.Context, req *AuthorizeRequest, username, password, code string, info ClientInfo) (string, error) {
+	go log.Println("Авторизация клиента OAuth2")
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	authorize, err := server.ValidateAuthorize(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := server.auth.VerifyCredentials(ctx, username, password, code, info)
+	if err != nil {
+		return "", err
+	}
+
+	authCode := randomToken(32)
+	err = server.repository.InsertCode(ctx, &OAuthCode{
+		ClientID:      authorize.Client.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        authorize.Scopes,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		ExpiresAt:     time.Now().Add(server.codeTTL),
+	}, hashToken(authCode))
+	if err != nil {
+		return "", fmt.Errorf("Ошибка выпуска кода авторизации %w", err)
+	}
+
+	return authorize.redirect(url.Values{"code": {authCode}}), nil
+}
+
+// Аутентификация клиента на конечных точках token, introspect и revoke
+func (server *OAuthServer) AuthenticateClient(ctx context.Context, clientID, clientSecret string) (*OAuthClient, error) {
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	client, secretHash, err := server.repository.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска клиента %w", err)
+	}
+	if client == nil {
+		return nil, oauthError(OAuthInvalidClient, "Клиент не найден")
+	}
+
+	// Публичный клиент не имеет секрета, конфиденциальный обязан его предъявить
+	if client.Confidential != (clientSecret != "") ||
+		(client.Confidential && subtle.ConstantTimeCompare([]byte(secretHash), []byte(hashToken(clientSecret))) != 1) {
+		return nil, oauthError(OAuthInvalidClient, "Неверные учетные данные клиента")
+	}
+
+	return client, nil
+}
+
+// Выпуск токенов по гранту из параметров запроса к /oauth/token
+func (server *OAuthServer) Token(ctx context.Context, client *OAuthClient, form url.Values, info ClientInfo) (*TokenPair, error) {
+	go log.Println("Выпуск токенов OAuth2")
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	grant := form.Get("grant_type")
+	switch grant {
+	case GrantAuthorizationCode:
+		if !slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+			return nil, oauthError(OAuthUnauthorizedClient, "Клиенту не разрешен грант "+grant)
+		}
+		return server.exchangeCode(ctx, client, form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier"), info)
+	case GrantRefreshToken:
+		if !slices.Contains(client.GrantTypes, GrantAuthorizationCode) {
+			return nil, oauthError(OAuthUnauthorizedClient, "Клиенту не разрешен грант "+grant)
+		}
+		return server.refresh(ctx, client, form.Get("refresh_token"))
+	case GrantClientCredentials:
+		if !client.Confidential || !slices.Contains(client.GrantTypes, GrantClientCredentials) {
+			return nil, oauthError(OAuthUnauthorizedClient, "Клиенту не разрешен грант "+grant)
+		}
+		scopes, err := requestedScopes(form.Get("scope"), client.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		return server.tokens.IssueClientToken(client.ClientID, scopes)
+	case "":
+		return nil, oauthError(OAuthInvalidRequest, "Не указан grant_type")
+	default:
+		return nil, oauthError(OAuthUnsupportedGrantType, "Грант "+grant+" не поддерживается")
+	}
+}
+
+// Сведения о токене доступа или токене обновления
+func (server *OAuthServer) Introspect(ctx context.Context, client *OAuthClient, token string) (*Introspection, error) {
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	if claims, err := server.tokens.ParseAccessToken(token); err == nil {
+		// Токен активен при тех же условиях, что и при авторизации запроса: сессия и вход от имени пользователя не завершены
+		principal, err := claims.Principal()
+		if err == nil {
+			err = server.auth.checkActive(ctx, principal)
+		}
+		if errors.Is(err, ErrInvalidToken) {
+			return &Introspection{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Username:  claims.Username,
+			TokenType: "Bearer",
+			Exp:       claims.ExpiresAt.Unix(),
+			Iat:       claims.IssuedAt.Unix(),
+			Sub:       claims.Subject,
+			Iss:       claims.Issuer,
+		}, nil
+	}
+
+	// Токен обновления раскрывается только клиенту, которому он выдан
+	session, err := server.sessions.FindByRefreshToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.ClientID != client.ClientID {
+		return &Introspection{}, nil
+	}
+
+	return &Introspection{
+		Active:    true,
+		Scope:     strings.Join(session.Scopes, " "),
+		ClientID:  session.ClientID,
+		TokenType: GrantRefreshToken,
+		Exp:       session.ExpiresAt.Unix(),
+		Iat:       session.CreatedAt.Unix(),
+		Sub:       strconv.FormatInt(session.UserID, 10),
+	}, nil
+}
+
+// Отзыв токена клиента (RFC 7009): завершается сессия, к которой относится токен.
+// Токены client_credentials не имеют сессии и действуют до истечения срока
+func (server *OAuthServer) Revoke(ctx context.Context, client *OAuthClient, token string) error {
+	go log.Println("Отзыв токена OAuth2")
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	var userID, sessionID int64
+	if claims, err := server.tokens.ParseAccessToken(token); err == nil {
+		if claims.ClientID != client.ClientID || claims.SessionID == 0 {
+			return nil
+		}
+		userID, _ = claims.UserID()
+		sessionID = claims.SessionID
+	} else {
+		session, err := server.sessions.FindByRefreshToken(ctx, token)
+		if err != nil {
+			return err
+		}
+		if session == nil || session.ClientID != client.ClientID {
+			return nil
+		}
+		userID, sessionID = session.UserID, session.ID
+	}
+
+	// Повторный отзыв не считается ошибкой
+	if err := server.sessions.Revoke(ctx, userID, sessionID); err != nil {
+		log.Println("OAuthServer", err)
+	}
+
+	return nil
+}
+
+func (server *OAuthServer) exchangeCode(ctx context.Context, client *OAuthClient, code, redirectURI, verifier string, info ClientInfo) (*TokenPair, error) {
+	if code == "" || verifier == "" {
+		return nil, oauthError(OAuthInvalidRequest, "Не указаны code или code_verifier")
+	}
+
+	authCode, err := server.repository.ConsumeCode(ctx, hashToken(code))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка проверки кода авторизации %w", err)
+	}
+	if authCode == nil || authCode.ClientID != client.ClientID {
+		return nil, oauthError(OAuthInvalidGrant, "Код авторизации недействителен")
+	}
+	// Адрес возврата, переданный в запросе авторизации, обязателен и должен совпадать (RFC 6749, 4.1.3)
+	if authCode.RedirectURI != redirectURI {
+		return nil, oauthError(OAuthInvalidGrant, "Адрес возврата не совпадает с указанным в запросе авторизации")
+	}
+
+	if !verifyCodeChallenge(verifier, authCode.CodeChallenge) {
+		return nil, oauthError(OAuthInvalidGrant, "Неверный code_verifier")
+	}
+
+	user, err := server.users.FindUserById(ctx, authCode.UserID)
+	if err != nil {
+		return nil, oauthError(OAuthInvalidGrant, "Пользователь не найден")
+	}
+	if !user.Active {
+		return nil, oauthError(OAuthInvalidGrant, ErrUserDisabled.Error())
+	}
+
+	session, refreshToken, err := server.sessions.CreateForClient(ctx, user.ID, client.ClientID, authCode.Scopes, info)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.issueDelegated(ctx, user, client.ClientID, session, refreshToken, authCode.Nonce)
+}
+
+func (server *OAuthServer) refresh(ctx context.Context, client *OAuthClient, refreshToken string) (*TokenPair, error) {
+	session, token, err := server.sessions.RotateForClient(ctx, refreshToken, client.ClientID)
+	if errors.Is(err, ErrInvalidToken) {
+		return nil, oauthError(OAuthInvalidGrant, "Токен обновления недействителен")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := server.users.FindUserById(ctx, session.UserID)
+	if err != nil {
+		return nil, oauthError(OAuthInvalidGrant, "Пользователь не найден")
+	}
+	if !user.Active {
+		return nil, oauthError(OAuthInvalidGrant, ErrUserDisabled.Error())
+	}
+
+	return server.issueDelegated(ctx, user, client.ClientID, session, token, "")
+}
+
+// Токен клиента дает только те разрешения пользователя, которые входят в выданные области действия;
+// при области openid к паре добавляется ID-токен
+func (server *OAuthServer) issueDelegated(ctx context.Context, user *User, clientID string, session *Session, refreshToken string, nonce string) (*TokenPair, error) {
+	permissions, err := server.roles.UserPermissions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := server.tokens.IssueDelegatedPair(user, clientID, session.Scopes, intersect(session.Scopes, permissions), session.ID, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(session.Scopes, ScopeOpenID) {
+		if pair.IDToken, err = server.tokens.IssueIDToken(user, clientID, nonce, session.Scopes); err != nil {
+			return nil, err
+		}
+	}
+
+	return pair, nil
+}
+
+// Сведения о пользователе по токену доступа; клиенту нужна область openid
+func (server *OAuthServer) UserInfo(ctx context.Context, principal *Principal) (*UserInfo, error) {
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	if principal.UserID == 0 || principal.APIKeyID != 0 {
+		return nil, ErrInvalidToken
+	}
+
+	// Собственные токены сервиса видят все сведения, токены клиентов - только выданные области
+	scopes := oidcScopes
+	if principal.ClientID != "" {
+		scopes = principal.Scopes
+	}
+	if !slices.Contains(scopes, ScopeOpenID) {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := server.users.FindUserById(ctx, principal.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	info := UserInfo{Subject: strconv.FormatInt(user.ID, 10)}
+	if slices.Contains(scopes, ScopeProfile) {
+		info.PreferredUsername = user.Username
+	}
+	if slices.Contains(scopes, ScopeEmail) {
+		info.Email = user.Email
+		info.EmailVerified = &user.EmailVerified
+	}
+
+	return &info, nil
+}
+
+// Описание провайдера; адреса строятся от издателя токенов
+func (server *OAuthServer) Discovery(ctx context.Context) (*ProviderMetadata, error) {
+	ctx, cancel := server.dbTimeout.apply(ctx)
+	defer cancel()
+	permissions, err := server.roles.AllPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := server.tokens.Issuer()
+	return &ProviderMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/jwks.json",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		ScopesSupported:                   append(slices.Clone(oidcScopes), permissions...),
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{server.tokens.keys.Algorithm()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "preferred_username", "email", "email_verified"},
+	}, nil
+}
+
+// Запрошенные области действия; пустой запрос означает все области клиента
+func requestedScopes(scope string, allowed []string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return allowed, nil
+	}
+
+	for _, requested := range scopes {
+		if !slices.Contains(allowed, requested) {
+			return nil, oauthError(OAuthInvalidScope, "Область действия "+requested+" недоступна клиенту")
+		}
+	}
+
+	return scopes, nil
+}
+
+// Проверка PKCE S256: BASE64URL(SHA256(code_verifier)) == code_challenge
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// Адрес возврата - абсолютный URL https без фрагмента; http допускается только для localhost и 127.0.0.1
+func validateRedirectURI(redirectURI string) error {
+	target, err := url.Parse(redirectURI)
+	if err != nil || !target.IsAbs() || target.Host == "" || target.Fragment != "" {
+		return fmt.Errorf("Адрес возврата %s должен быть абсолютным URL без фрагмента", redirectURI)
+	}
+
+	loopback := target.Hostname() == "localhost" || target.Hostname() == "127.0.0.1"
+	if target.Scheme != "https" && !(target.Scheme == "http" && loopback) {
+		return fmt.Errorf("Адрес возврата %s должен использовать https", redirectURI)
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	. "rest_module/utils"
+)
+
+// Хеширование паролей. Хеш хранится строкой, по которой определяются алгоритм и параметры
+type PasswordHasher interface {
+	// Хеш пароля с текущими параметрами
+	Hash(password string) (string, error)
+	// Проверка пароля по хешу
+	Verify(password, hash string) bool
+	// Хеш создан этим алгоритмом
+	Recognizes(hash string) bool
+	// Хеш создан с параметрами, отличными от текущих, и должен быть пересчитан
+	NeedsRehash(hash string) bool
+}
+
+// bcrypt в стандартном формате $2a$<cost>$...
+type BcryptHasher struct {
+	Cost int
+}
+
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+	return string(hash), err
+}
+
+func (hasher *BcryptHasher) Verify(password, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (hasher *BcryptHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (hasher *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != hasher.Cost
+}
+
+// argon2id (RFC 9106) в формате PHC: $argon2id$v=19$m=<KiB>,t=<проходы>,p=<потоки>$<соль>$<хеш>
+type Argon2idHasher struct {
+	Memory  uint32 // память в KiB
+	Time    uint32 // число проходов
+	Threads uint8  // степень параллелизма
+	KeyLen  uint32 // длина хеша в байтах
+	SaltLen uint32 // длина соли в байтах
+}
+
+// Параметры хеша argon2id
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := randomBytes(int(hasher.SaltLen))
+	key := argon2.IDKey([]byte(password), salt, hasher.Time, hasher.Memory, hasher.Threads, hasher.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, hasher.Memory, hasher.Time, hasher.Threads,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (hasher *Argon2idHasher) Verify(password, hash string) bool {
+	params, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+func (hasher *Argon2idHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (hasher *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := parseArgon2idHash(hash)
+	return err != nil || params.memory != hasher.Memory || params.time != hasher.Time || params.threads != hasher.Threads ||
+		len(params.key) != int(hasher.KeyLen) || len(params.salt) != int(hasher.SaltLen)
+}
+
+func parseArgon2idHash(hash string) (*argon2idParams, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("Некорректный хеш argon2id")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("Неподдерживаемая версия argon2id")
+	}
+
+	params := argon2idParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, fmt.Errorf("Некорректные параметры argon2id %w", err)
+	}
+	if params.time == 0 || params.threads == 0 {
+		return nil, fmt.Errorf("Некорректные параметры argon2id")
+	}
+
+	var err error
+	if params.salt, err = phcEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("Некорректная соль argon2id %w", err)
+	}
+	if params.key, err = phcEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return nil, fmt.Errorf("Некорректный хеш argon2id")
+	}
+
+	return &params, nil
+}
+
+// Хеширование текущим алгоритмом с проверкой хешей всех известных алгоритмов.
+// Хеши прежних алгоритмов и параметров пересчитываются при входе
+type UpgradingPasswordHasher struct {
+	current PasswordHasher   // алгоритм новых хешей
+	legacy  []PasswordHasher // алгоритмы, хеши которых еще принимаются
+}
+
+func NewUpgradingPasswordHasher(current PasswordHasher, legacy ...PasswordHasher) *UpgradingPasswordHasher {
+	return &UpgradingPasswordHasher{current: current, legacy: legacy}
+}
+
+func (hasher *UpgradingPasswordHasher) Hash(password string) (string, error) {
+	return hasher.current.Hash(password)
+}
+
+func (hasher *UpgradingPasswordHasher) Verify(password, hash string) bool {
+	if algorithm := hasher.algorithm(hash); algorithm != nil {
+		return algorithm.Verify(password, hash)
+	}
+	return false
+}
+
+func (hasher *UpgradingPasswordHasher) Recognizes(hash string) bool {
+	return hasher.algorithm(hash) != nil
+}
+
+func (hasher *UpgradingPasswordHasher) NeedsRehash(hash string) bool {
+	return !hasher.current.Recognizes(hash) || hasher.current.NeedsRehash(hash)
+}
+
+func (hasher *UpgradingPasswordHasher) algorithm(hash string) PasswordHasher {
+	if hasher.current.Recognizes(hash) {
+		return hasher.current
+	}
+	for _, legacy := range hasher.legacy {
+		if legacy.Recognizes(hash) {
+			return legacy
+		}
+	}
+	return nil
+}
+
+// Хеширование паролей по переменным окружения: PASSWORD_HASH_ALGORITHM (bcrypt или argon2id) выбирает
+// алгоритм новых хешей, хеши другого алгоритма принимаются и пересчитываются при входе
+func PasswordHasherFromEnv() (PasswordHasher, error) {
+	bcryptHasher := &BcryptHasher{Cost: GetEnvInt("PASSWORD_BCRYPT_COST", bcrypt.DefaultCost)}
+	if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("Недопустимая стоимость bcrypt %d", bcryptHasher.Cost)
+	}
+
+	// Значения по умолчанию - рекомендация OWASP для argon2id
+	memory := GetEnvInt("PASSWORD_ARGON2_MEMORY", 19*1024)
+	time := GetEnvInt("PASSWORD_ARGON2_TIME", 2)
+	threads := GetEnvInt("PASSWORD_ARGON2_THREADS", 1)
+	if time < 1 || threads < 1 || threads > 255 || memory < 8*threads || memory > 4*1024*1024 {
+		return nil, fmt.Errorf("Недопустимые параметры argon2id")
+	}
+	argon2Hasher := &Argon2idHasher{Memory: uint32(memory), Time: uint32(time), Threads: uint8(threads), KeyLen: 32, SaltLen: 16}
+
+	switch algorithm := GetEnv("PASSWORD_HASH_ALGORITHM", "bcrypt"); algorithm {
+	case "bcrypt":
+		return NewUpgradingPasswordHasher(bcryptHasher, argon2Hasher), nil
+	case "argon2id":
+		return NewUpgradingPasswordHasher(argon2Hasher, bcryptHasher), nil
+	default:
+		return nil, fmt.Errorf("Неизвестный алгоритм хеширования паролей %s", algorithm)
+	}
+}
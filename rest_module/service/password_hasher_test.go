@@ -0,0 +1,126 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Параметры argon2id, с которыми тесты не тратят время на хеширование
+func testArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Memory: 64, Time: 1, Threads: 1, KeyLen: 32, SaltLen: 16}
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	hash, err := hasher.Hash("Correct-horse1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasher.Recognizes(hash) || !hasher.Verify("Correct-horse1", hash) || hasher.Verify("Wrong-horse1", hash) {
+		t.Errorf("Неверная проверка хеша %s", hash)
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("Пересчет хеша с текущей стоимостью")
+	}
+	if !(&BcryptHasher{Cost: bcrypt.MinCost + 1}).NeedsRehash(hash) {
+		t.Error("Хеш с прежней стоимостью не пересчитывается")
+	}
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := testArgon2idHasher()
+	hash, err := hasher.Hash("Correct-horse1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Формат хеша %s", hash)
+	}
+	if !hasher.Recognizes(hash) || !hasher.Verify("Correct-horse1", hash) || hasher.Verify("Wrong-horse1", hash) {
+		t.Errorf("Неверная проверка хеша %s", hash)
+	}
+	if other, _ := hasher.Hash("Correct-horse1"); other == hash {
+		t.Error("Хеши одного пароля совпадают, соль не используется")
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("Пересчет хеша с текущими параметрами")
+	}
+
+	changed := testArgon2idHasher()
+	changed.Time = 2
+	if !changed.NeedsRehash(hash) || !changed.Verify("Correct-horse1", hash) {
+		t.Error("Хеш с прежними параметрами не проверяется по своим параметрам или не пересчитывается")
+	}
+
+	for _, invalid := range []string{
+		"",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+	} {
+		if hasher.Verify("Correct-horse1", invalid) || !hasher.NeedsRehash(invalid) {
+			t.Errorf("Некорректный хеш %q принят", invalid)
+		}
+	}
+}
+
+// Хеши прежнего алгоритма принимаются и пересчитываются, новые хеши считает текущий алгоритм
+func TestUpgradingPasswordHasher(t *testing.T) {
+	bcryptHasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	argon2Hasher := testArgon2idHasher()
+	hasher := NewUpgradingPasswordHasher(argon2Hasher, bcryptHasher)
+
+	legacy, _ := bcryptHasher.Hash("Correct-horse1")
+	if !hasher.Verify("Correct-horse1", legacy) || !hasher.NeedsRehash(legacy) {
+		t.Error("Хеш bcrypt не принят или не пересчитывается")
+	}
+
+	current, err := hasher.Hash("Correct-horse1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !argon2Hasher.Recognizes(current) || !hasher.Verify("Correct-horse1", current) || hasher.NeedsRehash(current) {
+		t.Errorf("Новый хеш %s", current)
+	}
+
+	if hasher.Recognizes("plain-text") || hasher.Verify("plain-text", "plain-text") {
+		t.Error("Принят хеш неизвестного алгоритма")
+	}
+}
+
+func TestPasswordHasherFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_BCRYPT_COST", "4")
+	t.Setenv("PASSWORD_ARGON2_MEMORY", "64")
+	t.Setenv("PASSWORD_ARGON2_TIME", "1")
+
+	for algorithm, prefix := range map[string]string{"bcrypt": "$2a$04$", "argon2id": "$argon2id$v=19$m=64,t=1,p=1$"} {
+		t.Setenv("PASSWORD_HASH_ALGORITHM", algorithm)
+		hasher, err := PasswordHasherFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash, _ := hasher.Hash("Correct-horse1"); !strings.HasPrefix(hash, prefix) {
+			t.Errorf("Алгоритм %s: хеш %s", algorithm, hash)
+		}
+	}
+
+	for name, value := range map[string]string{
+		"PASSWORD_HASH_ALGORITHM": "md5",
+		"PASSWORD_BCRYPT_COST":    "40",
+		"PASSWORD_ARGON2_THREADS": "0",
+		"PASSWORD_ARGON2_MEMORY":  "4",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := PasswordHasherFromEnv(); err == nil {
+				t.Errorf("%s=%s принято", name, value)
+			}
+		})
+	}
+}
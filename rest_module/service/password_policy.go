@@ -0,0 +1,295 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"rest_module/repository"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/utils"
+)
+
+// bcrypt учитывает только первые 72 байта пароля
+const bcryptMaxPasswordBytes = 72
+
+// Предельная длина пароля для любого алгоритма: хеширование длинного пароля не должно занимать сервер
+const maxPasswordBytes = 1024
+
+// Нарушение правила парольной политики
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Ошибка проверки пароля со списком нарушенных правил
+type PasswordPolicyError struct {
+	Message    string            `json:"error"`
+	Violations []PolicyViolation `json:"violations"`
+}
+
+func (err *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(err.Violations))
+	for _, violation := range err.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return err.Message + ": " + strings.Join(messages, "; ")
+}
+
+// Проверяемый пароль и сведения о его владельце
+type PasswordCandidate struct {
+	Password    string
+	Username    string
+	Email       string
+	UserID      int64  // 0 для нового пользователя
+	CurrentHash string // хеш действующего пароля
+}
+
+// Правило парольной политики; пустая строка означает, что правило выполнено.
+// Ошибка означает, что правило не удалось проверить, и пароль не принимается
+type PasswordRule interface {
+	Name() string
+	Check(ctx context.Context, candidate *PasswordCandidate) (string, error)
+}
+
+// Парольная политика из набора правил
+type PasswordPolicy struct {
+	rules []PasswordRule
+}
+
+// Конструктор политики из произвольных правил
+func NewPasswordPolicy(rules ...PasswordRule) *PasswordPolicy {
+	return &PasswordPolicy{rules: rules}
+}
+
+// Политика по переменным окружения; без истории паролей (history равно nil) правило истории не применяется
+func PasswordPolicyFromEnv(history *repository.PasswordHistoryRepository, hasher PasswordHasher) *PasswordPolicy {
+	rules := []PasswordRule{
+		&LengthRule{
+			Min: GetEnvInt("PASSWORD_MIN_LENGTH", 8),
+			Max: maxPasswordLength(GetEnvInt("PASSWORD_MAX_LENGTH", maxPasswordBytes), hasher),
+		},
+		&CharacterClassRule{MinClasses: GetEnvInt("PASSWORD_MIN_CHARACTER_CLASSES", 2)},
+		&SimilarityRule{},
+	}
+
+	if path := GetEnv("PASSWORD_BLOCKLIST_FILE", ""); path != "" {
+		rule, err := LoadCommonPasswordRule(path)
+		if err != nil {
+			log.Warnf("Список распространенных паролей не загружен: %v", err)
+		} else {
+			rules = append(rules, rule)
+		}
+	}
+
+	if count := GetEnvInt("PASSWORD_HISTORY_SIZE", 5); count > 0 && history != nil {
+		rules = append(rules, &HistoryRule{Count: count, history: history, hasher: hasher})
+	}
+
+	return NewPasswordPolicy(rules...)
+}
+
+// Предельная длина пароля в байтах, не больше 1024; 0 - предел по умолчанию. Если новые хеши считает bcrypt,
+// длина не превышает 72 байт, иначе пароли с общим началом были бы неразличимы
+func maxPasswordLength(configured int, hasher PasswordHasher) int {
+	limit := maxPasswordBytes
+	if configured > 0 {
+		limit = min(configured, maxPasswordBytes)
+	}
+
+	if upgrading, ok := hasher.(*UpgradingPasswordHasher); ok {
+		hasher = upgrading.current
+	}
+	if _, ok := hasher.(*BcryptHasher); ok {
+		return min(limit, bcryptMaxPasswordBytes)
+	}
+
+	return limit
+}
+
+// Проверка пароля всеми правилами; если правило не удалось проверить, возвращается ErrPasswordCheckUnavailable
+func (policy *PasswordPolicy) Validate(ctx context.Context, candidate *PasswordCandidate) error {
+	var violations []PolicyViolation
+	for _, rule := range policy.rules {
+		message, err := rule.Check(ctx, candidate)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrPasswordCheckUnavailable, err)
+		}
+		if message != "" {
+			violations = append(violations, PolicyViolation{Rule: rule.Name(), Message: message})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Message: "Пароль не соответствует требованиям", Violations: violations}
+	}
+
+	return nil
+}
+
+// Размер истории паролей, который нужно хранить для правила истории
+func (policy *PasswordPolicy) HistorySize() int {
+	for _, rule := range policy.rules {
+		if history, ok := rule.(*HistoryRule); ok {
+			return history.Count
+		}
+	}
+	return 0
+}
+
+// Длина пароля в символах (минимум) и в байтах (максимум; 0 - без ограничения)
+type LengthRule struct {
+	Min int
+	Max int
+}
+
+func (rule *LengthRule) Name() string { return "length" }
+
+func (rule *LengthRule) Check(ctx context.Context, candidate *PasswordCandidate) (string, error) {
+	if len([]rune(candidate.Password)) < rule.Min {
+		return fmt.Sprintf("Пароль должен содержать не менее %d символов", rule.Min), nil
+	}
+	if rule.Max > 0 && len(candidate.Password) > rule.Max {
+		return fmt.Sprintf("Пароль должен занимать не более %d байт", rule.Max), nil
+	}
+	return "", nil
+}
+
+// Минимальное число классов символов: строчные, заглавные, цифры, прочие
+type CharacterClassRule struct {
+	MinClasses int
+}
+
+func (rule *CharacterClassRule) Name() string { return "character_classes" }
+
+func (rule *CharacterClassRule) Check(ctx context.Context, candidate *PasswordCandidate) (string, error) {
+	var lower, upper, digit, other bool
+	for _, r := range candidate.Password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+
+	if classes < rule.MinClasses {
+		return fmt.Sprintf("Пароль должен содержать символы не менее %d видов из: строчные, заглавные, цифры, прочие", rule.MinClasses), nil
+	}
+	return "", nil
+}
+
+// Пароль не должен содержать логин или имя из адреса почты
+type SimilarityRule struct{}
+
+func (rule *SimilarityRule) Name() string { return "similarity" }
+
+func (rule *SimilarityRule) Check(ctx context.Context, candidate *PasswordCandidate) (string, error) {
+	password := strings.ToLower(candidate.Password)
+	local, _, _ := strings.Cut(candidate.Email, "@")
+
+	for _, value := range []string{candidate.Username, local} {
+		value = strings.ToLower(value)
+		if len([]rune(value)) < 3 {
+			continue
+		}
+		if strings.Contains(password, value) || strings.Contains(password, reverse(value)) {
+			return "Пароль не должен содержать логин или адрес почты", nil
+		}
+	}
+	return "", nil
+}
+
+// Запрет распространенных и скомпрометированных паролей из локального списка
+type CommonPasswordRule struct {
+	passwords map[string]struct{}
+}
+
+// Загрузка списка паролей из файла: по одному в строке, строки с # пропускаются
+func LoadCommonPasswordRule(path string) (*CommonPasswordRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rule := CommonPasswordRule{passwords: map[string]struct{}{}}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule.passwords[strings.ToLower(line)] = struct{}{}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func (rule *CommonPasswordRule) Name() string { return "common_password" }
+
+func (rule *CommonPasswordRule) Check(ctx context.Context, candidate *PasswordCandidate) (string, error) {
+	if _, found := rule.passwords[strings.ToLower(candidate.Password)]; found {
+		return "Пароль слишком распространен", nil
+	}
+	return "", nil
+}
+
+// Запрет повторного использования последних Count паролей
+type HistoryRule struct {
+	Count   int
+	history *repository.PasswordHistoryRepository
+	hasher  PasswordHasher
+}
+
+func (rule *HistoryRule) Name() string { return "history" }
+
+func (rule *HistoryRule) Check(ctx context.Context, candidate *PasswordCandidate) (string, error) {
+	if candidate.UserID == 0 {
+		return "", nil
+	}
+
+	hashes, err := rule.history.GetRecentHashes(ctx, candidate.UserID, rule.Count)
+	if err != nil {
+		return "", fmt.Errorf("Ошибка чтения истории паролей %w", err)
+	}
+
+	// Действующий пароль мог быть задан до появления истории
+	if candidate.CurrentHash != "" {
+		hashes = append(hashes, candidate.CurrentHash)
+	}
+
+	for _, hash := range hashes {
+		if rule.hasher.Verify(candidate.Password, hash) {
+			return fmt.Sprintf("Пароль совпадает с одним из последних %d паролей", rule.Count), nil
+		}
+	}
+	return "", nil
+}
+
+func reverse(value string) string {
+	runes := []rune(value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
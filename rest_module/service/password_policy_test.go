@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Имена нарушенных правил в порядке проверки
+func violatedRules(t *testing.T, policy *PasswordPolicy, candidate *PasswordCandidate) []string {
+	t.Helper()
+	err := policy.Validate(context.Background(), candidate)
+	if err == nil {
+		return nil
+	}
+
+	var policyError *PasswordPolicyError
+	if !errors.As(err, &policyError) {
+		t.Fatalf("Ошибка %v, ожидалась ошибка политики", err)
+	}
+
+	rules := make([]string, 0, len(policyError.Violations))
+	for _, violation := range policyError.Violations {
+		rules = append(rules, violation.Rule)
+	}
+	return rules
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := NewPasswordPolicy(&LengthRule{Min: 8, Max: 72}, &CharacterClassRule{MinClasses: 2}, &SimilarityRule{})
+
+	tests := []struct {
+		name      string
+		candidate PasswordCandidate
+		want      []string
+	}{
+		{name: "подходящий пароль", candidate: PasswordCandidate{Password: "Correct-horse1", Username: "ivan"}},
+		{name: "короткий пароль", candidate: PasswordCandidate{Password: "Ab1-"}, want: []string{"length"}},
+		{name: "длина в символах", candidate: PasswordCandidate{Password: "Пароль-1"}},
+		{name: "длинный пароль", candidate: PasswordCandidate{Password: "Aa1" + strings.Repeat("x", 70)}, want: []string{"length"}},
+		{name: "один класс символов", candidate: PasswordCandidate{Password: "correcthorse"}, want: []string{"character_classes"}},
+		{name: "содержит логин", candidate: PasswordCandidate{Password: "My-Ivan-2024", Username: "ivan"}, want: []string{"similarity"}},
+		{name: "содержит логин наоборот", candidate: PasswordCandidate{Password: "My-navi-2024", Username: "ivan"}, want: []string{"similarity"}},
+		{name: "содержит имя из почты", candidate: PasswordCandidate{Password: "Petrov-2024", Email: "petrov@example.com"}, want: []string{"similarity"}},
+		{name: "короткий логин не проверяется", candidate: PasswordCandidate{Password: "Correct-ab1", Username: "ab"}},
+		{name: "несколько нарушений", candidate: PasswordCandidate{Password: "ivan", Username: "ivan"}, want: []string{"length", "character_classes", "similarity"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := violatedRules(t, policy, &test.candidate); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Нарушены правила %v, ожидались %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommonPasswordRule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwords.txt")
+	if err := os.WriteFile(path, []byte("# распространенные пароли\n\nQwerty123\n  password1  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rule, err := LoadCommonPasswordRule(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := NewPasswordPolicy(rule)
+
+	for password, want := range map[string][]string{
+		"qwerty123": {"common_password"},
+		"PASSWORD1": {"common_password"},
+		"# распространенные пароли": nil,
+		"Correct-horse1": nil,
+	} {
+		if got := violatedRules(t, policy, &PasswordCandidate{Password: password}); !reflect.DeepEqual(got, want) {
+			t.Errorf("Пароль %q: нарушены правила %v, ожидались %v", password, got, want)
+		}
+	}
+
+	if _, err = LoadCommonPasswordRule(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Отсутствующий файл загружен без ошибки")
+	}
+}
+
+// Длина пароля ограничена для любого алгоритма; ограничение 72 байтами действует, только когда новые хеши считает bcrypt
+func TestMaxPasswordLength(t *testing.T) {
+	bcryptHasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	argon2Hasher := &Argon2idHasher{Memory: 64, Time: 1, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+	tests := []struct {
+		name       string
+		configured int
+		hasher     PasswordHasher
+		want       int
+	}{
+		{name: "bcrypt по умолчанию", hasher: bcryptHasher, want: bcryptMaxPasswordBytes},
+		{name: "bcrypt с меньшим пределом", configured: 64, hasher: bcryptHasher, want: 64},
+		{name: "bcrypt с большим пределом", configured: 128, hasher: bcryptHasher, want: bcryptMaxPasswordBytes},
+		{name: "bcrypt с переходом с argon2id", hasher: NewUpgradingPasswordHasher(bcryptHasher, argon2Hasher), want: bcryptMaxPasswordBytes},
+		{name: "argon2id по умолчанию", hasher: NewUpgradingPasswordHasher(argon2Hasher, bcryptHasher), want: maxPasswordBytes},
+		{name: "argon2id с пределом", configured: 128, hasher: NewUpgradingPasswordHasher(argon2Hasher, bcryptHasher), want: 128},
+		{name: "argon2id с пределом больше допустимого", configured: 1 << 20, hasher: argon2Hasher, want: maxPasswordBytes},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := maxPasswordLength(test.configured, test.hasher); got != test.want {
+				t.Errorf("Предельная длина %d, ожидалась %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import "slices"
+
+// Аутентифицированный субъект запроса
+type Principal struct {
+	UserID      int64    `json:"user_id"`
+	Username    string   `json:"username"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	SessionID   int64    `json:"session_id,omitempty"`
+	APIKeyID    int64    `json:"api_key_id,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+	Scopes      []string `json:"scopes,omitempty"`
+	Actor       *Actor   `json:"act,omitempty"`
+}
+
+// Администратор, действующий от имени пользователя
+type Actor struct {
+	UserID          int64  `json:"user_id"`
+	Username        string `json:"username"`
+	ImpersonationID int64  `json:"impersonation_id"`
+}
+
+// Проверка наличия разрешения у субъекта
+func (principal *Principal) HasPermission(permission string) bool {
+	return slices.Contains(principal.Permissions, permission)
+}
+
+// Запрос выполняет администратор от имени пользователя
+func (principal *Principal) IsImpersonated() bool {
+	return principal.Actor != nil
+}
+
+// Субъект действует от своего имени интерактивно, а не по ключу API или через клиента OAuth2
+func (principal *Principal) IsInteractive() bool {
+	return principal.APIKeyID == 0 && principal.ClientID == ""
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"rest_module/repository"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+)
+
+// Разрешения, проверяемые на маршрутах API
+const (
+	PermissionUsersRead          = "users:read"
+	PermissionUsersWrite         = "users:write"
+	PermissionUsersDelete        = "users:delete"
+	PermissionRolesManage        = "roles:manage"
+	PermissionStorageRead        = "storage:read"
+	PermissionStorageWrite       = "storage:write"
+	PermissionAPIKeysManage      = "api_keys:manage"
+	PermissionOAuthClientsManage = "oauth_clients:manage"
+	PermissionSCIMProvision      = "scim:provision"
+	PermissionInvitationsManage  = "invitations:manage"
+	PermissionUsersImpersonate   = "users:impersonate"
+	PermissionImpersonationsEnd  = "impersonations:end"
+	PermissionAuditRead          = "audit:read"
+)
+
+// Роли, создаваемые при инициализации БД
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+type RoleManager struct {
+	repository *repository.RoleRepository // репозиторий ролей
+	users      repository.UserStore       // хранилище пользователей
+	dbTimeout  operationTimeout           // ограничение времени операции с БД
+}
+
+// Конструктор сервиса ролей
+func RoleManagerNewInstance(repository *repository.RoleRepository, users repository.UserStore) *RoleManager {
+	manager := RoleManager{}
+	manager.repository = repository
+	manager.users = users
+	manager.dbTimeout = operationTimeoutFromEnv()
+	return &manager
+}
+
+// Все роли
+func (manager *RoleManager) FindAllRoles(ctx context.Context) (*[]Role, error) {
+	go log.Println("Чтение ролей")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	roles, err := manager.repository.GetAllRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения ролей %w", err)
+	}
+
+	return roles, nil
+}
+
+// Поиск роли по идентификатору
+func (manager *RoleManager) FindRoleByID(ctx context.Context, id int64) (*Role, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	role, err := manager.repository.GetRoleByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска роли %w", err)
+	}
+	if role == nil {
+		return nil, fmt.Errorf("Роль с таким идентификатором не найдена")
+	}
+
+	return role, nil
+}
+
+// Создание роли без разрешений
+func (manager *RoleManager) CreateRole(ctx context.Context, name, description string) (*Role, error) {
+	go log.Println("Создание роли")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := validateRoleName(name); err != nil {
+		return nil, err
+	}
+
+	exist, err := manager.repository.GetRoleByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска роли %w", err)
+	}
+	if exist != nil {
+		return nil, fmt.Errorf("Роль %s уже есть", name)
+	}
+
+	role := Role{Name: name, Description: description, Permissions: []string{}}
+	if role.ID, err = manager.repository.InsertRole(ctx, &role); err != nil {
+		return nil, fmt.Errorf("Ошибка создания роли %w", err)
+	}
+
+	return &role, nil
+}
+
+// Переименование роли; встроенные роли не переименовываются
+func (manager *RoleManager) RenameRole(ctx context.Context, id int64, name string) (*Role, error) {
+	go log.Println("Переименование роли")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	role, err := manager.FindRoleByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if role.Name == name {
+		return role, nil
+	}
+	if isBuiltinRole(role.Name) {
+		return nil, fmt.Errorf("Встроенную роль %s нельзя переименовать", role.Name)
+	}
+	if err = validateRoleName(name); err != nil {
+		return nil, err
+	}
+
+	exist, err := manager.repository.GetRoleByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска роли %w", err)
+	}
+	if exist != nil {
+		return nil, fmt.Errorf("Роль %s уже есть", name)
+	}
+
+	if err = manager.repository.RenameRole(ctx, id, name); err != nil {
+		return nil, fmt.Errorf("Ошибка переименования роли %w", err)
+	}
+	role.Name = name
+
+	return role, nil
+}
+
+// Удаление роли; встроенные роли не удаляются
+func (manager *RoleManager) DeleteRole(ctx context.Context, id int64) error {
+	go log.Println("Удаление роли")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	role, err := manager.FindRoleByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if isBuiltinRole(role.Name) {
+		return fmt.Errorf("Встроенную роль %s нельзя удалить", role.Name)
+	}
+
+	if err = manager.repository.DeleteRole(ctx, id); err != nil {
+		return fmt.Errorf("Ошибка удаления роли %w", err)
+	}
+
+	return nil
+}
+
+// Пользователи с ролью
+func (manager *RoleManager) RoleMembers(ctx context.Context, id int64) (*[]User, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	members, err := manager.repository.GetRoleMembers(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения участников роли %w", err)
+	}
+
+	return members, nil
+}
+
+// Роли пользователя
+func (manager *RoleManager) UserRoles(ctx context.Context, userID int64) ([]string, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	user, err := manager.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return user.Roles, nil
+}
+
+// Назначение роли пользователю
+func (manager *RoleManager) AssignRole(ctx context.Context, userID int64, roleName string) ([]string, error) {
+	go log.Println("Назначение роли пользователю")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if _, err := manager.findUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	role, err := manager.findRole(ctx, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = manager.repository.AssignRole(ctx, userID, role.ID); err != nil {
+		return nil, fmt.Errorf("Ошибка назначения роли %w", err)
+	}
+
+	return manager.UserRoles(ctx, userID)
+}
+
+// Снятие роли с пользователя
+func (manager *RoleManager) RemoveRole(ctx context.Context, userID int64, roleName string) ([]string, error) {
+	go log.Println("Снятие роли с пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if _, err := manager.findUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	role, err := manager.findRole(ctx, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = manager.repository.RemoveRole(ctx, userID, role.ID); err != nil {
+		return nil, fmt.Errorf("Ошибка снятия роли %w", err)
+	}
+
+	return manager.UserRoles(ctx, userID)
+}
+
+// Разрешения пользователя по всем его ролям
+func (manager *RoleManager) UserPermissions(ctx context.Context, userID int64) ([]string, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	permissions, err := manager.repository.GetUserPermissions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения разрешений %w", err)
+	}
+
+	return permissions, nil
+}
+
+// Имена всех разрешений
+func (manager *RoleManager) AllPermissions(ctx context.Context) ([]string, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	permissions, err := manager.repository.GetAllPermissions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения разрешений %w", err)
+	}
+
+	return permissions, nil
+}
+
+// Назначение роли администратора пользователю из конфигурации при старте
+func (manager *RoleManager) BootstrapAdmin(ctx context.Context, username string) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if username == "" {
+		return
+	}
+
+	user, err := manager.users.GetUserByName(ctx, username)
+	if err != nil || user == nil {
+		log.Warnf("Пользователь %s для роли администратора не найден", username)
+		return
+	}
+
+	if _, err = manager.AssignRole(ctx, user.ID, RoleAdmin); err != nil {
+		log.Warnf("Не удалось назначить роль администратора %s: %v", username, err)
+	}
+}
+
+func (manager *RoleManager) findUser(ctx context.Context, userID int64) (*User, error) {
+	user, err := manager.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("Пользователь с таким идентификатором не найден")
+	}
+
+	return user, nil
+}
+
+func (manager *RoleManager) findRole(ctx context.Context, name string) (*Role, error) {
+	role, err := manager.repository.GetRoleByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска роли %w", err)
+	}
+	if role == nil {
+		return nil, fmt.Errorf("Роль %s не найдена", name)
+	}
+
+	return role, nil
+}
+
+func isBuiltinRole(name string) bool {
+	return name == RoleAdmin || name == RoleUser
+}
+
+func validateRoleName(name string) error {
+	if strings.TrimSpace(name) == "" || len(name) > 50 {
+		return fmt.Errorf("Название роли должно содержать от 1 до 50 символов")
+	}
+
+	return nil
+}
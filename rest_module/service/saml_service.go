@@ -0,0 +1,531 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"rest_module/repository"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Пространства имен, привязки и значения SAML 2.0
+const (
+	samlProtocolNamespace   = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNamespace  = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlMetadataNamespace   = "urn:oasis:names:tc:SAML:2.0:metadata"
+	samlBindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlBindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	samlStatusSuccess       = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlMethodBearer        = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlNameIDUnspecified   = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// Ответ IdP не прошел проверку
+type SAMLError struct {
+	Reason string
+}
+
+func (err *SAMLError) Error() string {
+	return "Ответ SAML отклонен: " + err.Reason
+}
+
+// Проверенное утверждение IdP
+type SAMLAssertion struct {
+	ID           string              // идентификатор утверждения
+	NameID       string              // идентификатор субъекта у IdP
+	InResponseTo string              // идентификатор нашего запроса; пуст при входе по инициативе IdP
+	ExpiresAt    time.Time           // срок, после которого утверждение не принимается
+	Attributes   map[string][]string // атрибуты субъекта
+}
+
+// Первое значение атрибута
+func (assertion *SAMLAssertion) Attribute(name string) string {
+	if values := assertion.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Параметры IdP из его метаданных
+type samlIdentityProvider struct {
+	entityID string              // идентификатор IdP
+	ssoURL   string              // адрес входа с привязкой HTTP-Redirect
+	certs    []*x509.Certificate // сертификаты подписи
+}
+
+// Поставщик услуг SAML 2.0: метаданные, запрос входа и прием ответов IdP
+type SAMLServiceProvider struct {
+	repository        *repository.SAMLRepository     // запросы и принятые утверждения
+	identities        *repository.IdentityRepository // связи с учетными записями IdP
+	users             *UserManager                   // сервис пользователей
+	auth              *AuthService                   // сервис аутентификации
+	idp               *samlIdentityProvider          // nil, если вход через SAML не настроен
+	entityID          string                         // идентификатор SP
+	acsURL            string                         // адрес приема ответов IdP
+	nameIDFormat      string                         // запрашиваемый формат NameID
+	usernameAttribute string                         // атрибут логина; по умолчанию NameID
+	emailAttribute    string                         // атрибут адреса почты
+	clockSkew         time.Duration                  // допустимое расхождение часов с IdP
+	requestTTL        time.Duration                  // срок ожидания ответа на запрос
+	allowIdPInitiated bool                           // прием ответов без нашего запроса
+	dbTimeout         operationTimeout               // ограничение времени операции с БД
+}
+
+// Конструктор поставщика услуг SAML; baseURL - внешний адрес сервиса
+func SAMLServiceProviderNewInstance(repository *repository.SAMLRepository, identities *repository.IdentityRepository,
+	users *UserManager, auth *AuthService, baseURL string) *SAMLServiceProvider {
+	sp := SAMLServiceProvider{}
+	sp.repository = repository
+	sp.identities = identities
+	sp.users = users
+	sp.auth = auth
+	sp.entityID = GetEnv("SAML_SP_ENTITY_ID", baseURL+"/saml/metadata")
+	sp.acsURL = GetEnv("SAML_ACS_URL", baseURL+"/saml/acs")
+	sp.nameIDFormat = GetEnv("SAML_NAMEID_FORMAT", samlNameIDUnspecified)
+	sp.usernameAttribute = GetEnv("SAML_ATTR_USERNAME", "")
+	sp.emailAttribute = GetEnv("SAML_ATTR_EMAIL", "email")
+	sp.clockSkew = GetEnvDuration("SAML_CLOCK_SKEW", 2*time.Minute)
+	sp.requestTTL = GetEnvDuration("SAML_REQUEST_TTL", 10*time.Minute)
+	sp.allowIdPInitiated = GetEnv("SAML_ALLOW_IDP_INITIATED", "false") == "true"
+	sp.dbTimeout = operationTimeoutFromEnv()
+	return &sp
+}
+
+// Загрузка метаданных IdP из SAML_IDP_METADATA_FILE; без файла вход через SAML отключен
+func (sp *SAMLServiceProvider) LoadIdentityProvider() error {
+	path := GetEnv("SAML_IDP_METADATA_FILE", "")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Ошибка чтения метаданных IdP %w", err)
+	}
+
+	idp, err := parseIdPMetadata(data)
+	if err != nil {
+		return err
+	}
+
+	sp.idp = idp
+	return nil
+}
+
+// Метаданные SP для регистрации у IdP
+func (sp *SAMLServiceProvider) Metadata() ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+
+	entity := doc.CreateElement("md:EntityDescriptor")
+	entity.CreateAttr("xmlns:md", samlMetadataNamespace)
+	entity.CreateAttr("entityID", sp.entityID)
+
+	descriptor := entity.CreateElement("md:SPSSODescriptor")
+	descriptor.CreateAttr("AuthnRequestsSigned", "false")
+	descriptor.CreateAttr("WantAssertionsSigned", "true")
+	descriptor.CreateAttr("protocolSupportEnumeration", samlProtocolNamespace)
+	descriptor.CreateElement("md:NameIDFormat").SetText(sp.nameIDFormat)
+
+	acs := descriptor.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", samlBindingHTTPPost)
+	acs.CreateAttr("Location", sp.acsURL)
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+
+	doc.Indent(2)
+	return doc.WriteToBytes()
+}
+
+// Адрес входа у IdP с запросом AuthnRequest (привязка HTTP-Redirect)
+func (sp *SAMLServiceProvider) LoginURL(ctx context.Context, relayState string) (string, error) {
+	go log.Println("Запрос входа через SAML")
+	ctx, cancel := sp.dbTimeout.apply(ctx)
+	defer cancel()
+	if sp.idp == nil {
+		return "", ErrSAMLDisabled
+	}
+	if len(relayState) > 80 {
+		return "", fmt.Errorf("RelayState не может быть длиннее 80 байт")
+	}
+
+	id := "_" + hex.EncodeToString(randomBytes(20))
+	now := time.Now().UTC()
+
+	doc := etree.NewDocument()
+	request := doc.CreateElement("samlp:AuthnRequest")
+	request.CreateAttr("xmlns:samlp", samlProtocolNamespace)
+	request.CreateAttr("xmlns:saml", samlAssertionNamespace)
+	request.CreateAttr("ID", id)
+	request.CreateAttr("Version", "2.0")
+	request.CreateAttr("IssueInstant", now.Format(time.RFC3339))
+	request.CreateAttr("Destination", sp.idp.ssoURL)
+	request.CreateAttr("AssertionConsumerServiceURL", sp.acsURL)
+	request.CreateAttr("ProtocolBinding", samlBindingHTTPPost)
+	request.CreateElement("saml:Issuer").SetText(sp.entityID)
+	policy := request.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("Format", sp.nameIDFormat)
+	policy.CreateAttr("AllowCreate", "true")
+
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		return "", fmt.Errorf("Ошибка формирования запроса SAML %w", err)
+	}
+
+	var compressed bytes.Buffer
+	writer, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	writer.Write(raw)
+	writer.Close()
+
+	if err = sp.repository.InsertRequest(ctx, id, now.Add(sp.requestTTL)); err != nil {
+		return "", fmt.Errorf("Ошибка сохранения запроса SAML %w", err)
+	}
+
+	target, err := url.Parse(sp.idp.ssoURL)
+	if err != nil {
+		return "", fmt.Errorf("Некорректный адрес входа IdP %w", err)
+	}
+	query := target.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(compressed.Bytes()))
+	if relayState != "" {
+		query.Set("RelayState", relayState)
+	}
+	target.RawQuery = query.Encode()
+
+	return target.String(), nil
+}
+
+// Прием ответа IdP (привязка HTTP-POST) и вход пользователя
+func (sp *SAMLServiceProvider) Login(ctx context.Context, samlResponse string, client ClientInfo) (*LoginResult, error) {
+	go log.Println("Вход через SAML")
+	ctx, cancel := sp.dbTimeout.apply(ctx)
+	defer cancel()
+	if sp.idp == nil {
+		return nil, ErrSAMLDisabled
+	}
+
+	assertion, err := sp.ParseResponse(samlResponse, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = sp.acceptAssertion(ctx, assertion); err != nil {
+		return nil, err
+	}
+
+	user, err := sp.resolveUser(ctx, assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	return sp.auth.CompleteExternalLogin(ctx, user, client)
+}
+
+// Проверка, что утверждение отвечает на наш запрос и не было принято ранее; запрос и утверждение погашаются
+func (sp *SAMLServiceProvider) acceptAssertion(ctx context.Context, assertion *SAMLAssertion) error {
+	if assertion.InResponseTo == "" {
+		if !sp.allowIdPInitiated {
+			return &SAMLError{Reason: "вход по инициативе IdP запрещен"}
+		}
+	} else {
+		found, err := sp.repository.ConsumeRequest(ctx, assertion.InResponseTo)
+		if err != nil {
+			return fmt.Errorf("Ошибка проверки запроса SAML %w", err)
+		}
+		if !found {
+			return &SAMLError{Reason: "ответ на неизвестный или просроченный запрос"}
+		}
+	}
+
+	fresh, err := sp.repository.InsertAssertion(ctx, assertion.ID, assertion.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("Ошибка сохранения утверждения SAML %w", err)
+	}
+	if !fresh {
+		return &SAMLError{Reason: "утверждение уже использовано"}
+	}
+
+	return nil
+}
+
+// Разбор и проверка ответа IdP: подпись, получатель, сроки и аудитория утверждения.
+// Однократность ответа проверяет Login.
+func (sp *SAMLServiceProvider) ParseResponse(encoded string, now time.Time) (*SAMLAssertion, error) {
+	if sp.idp == nil {
+		return nil, ErrSAMLDisabled
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, &SAMLError{Reason: "некорректная кодировка ответа"}
+	}
+
+	doc := etree.NewDocument()
+	if err = doc.ReadFromBytes(raw); err != nil {
+		return nil, &SAMLError{Reason: "некорректный XML"}
+	}
+	for _, token := range doc.Child {
+		if _, ok := token.(*etree.Directive); ok {
+			return nil, &SAMLError{Reason: "объявление DTD не допускается"}
+		}
+	}
+
+	response := doc.Root()
+	if response == nil || response.Tag != "Response" || response.NamespaceURI() != samlProtocolNamespace {
+		return nil, &SAMLError{Reason: "ожидался элемент Response"}
+	}
+	if response.SelectAttrValue("Version", "") != "2.0" {
+		return nil, &SAMLError{Reason: "неподдерживаемая версия SAML"}
+	}
+	if destination := response.SelectAttrValue("Destination", ""); destination != "" && destination != sp.acsURL {
+		return nil, &SAMLError{Reason: "ответ предназначен другому получателю"}
+	}
+	if issuer := childNS(response, samlAssertionNamespace, "Issuer"); issuer != nil && strings.TrimSpace(issuer.Text()) != sp.idp.entityID {
+		return nil, &SAMLError{Reason: "ответ выпущен неизвестным IdP"}
+	}
+
+	status := childNS(response, samlProtocolNamespace, "Status")
+	if status == nil {
+		return nil, &SAMLError{Reason: "ответ не содержит статуса"}
+	}
+	statusCode := childNS(status, samlProtocolNamespace, "StatusCode")
+	if statusCode == nil || statusCode.SelectAttrValue("Value", "") != samlStatusSuccess {
+		code := ""
+		if statusCode != nil {
+			code = statusCode.SelectAttrValue("Value", "")
+		}
+		return nil, &SAMLError{Reason: "IdP вернул статус " + code}
+	}
+
+	if len(childrenNS(response, samlAssertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, &SAMLError{Reason: "зашифрованные утверждения не поддерживаются"}
+	}
+
+	responseSigned, err := verifyXMLSignature(response, sp.idp.certs)
+	if err != nil {
+		return nil, &SAMLError{Reason: err.Error()}
+	}
+
+	// Используется только утверждение из проверенного дерева, обращения по ID не выполняются
+	assertions := childrenNS(response, samlAssertionNamespace, "Assertion")
+	if len(assertions) != 1 {
+		return nil, &SAMLError{Reason: "ответ должен содержать ровно одно утверждение"}
+	}
+
+	assertionSigned, err := verifyXMLSignature(assertions[0], sp.idp.certs)
+	if err != nil {
+		return nil, &SAMLError{Reason: err.Error()}
+	}
+	if !responseSigned && !assertionSigned {
+		return nil, &SAMLError{Reason: "ответ не подписан"}
+	}
+
+	return sp.readAssertion(assertions[0], response.SelectAttrValue("InResponseTo", ""), now)
+}
+
+func (sp *SAMLServiceProvider) readAssertion(el *etree.Element, inResponseTo string, now time.Time) (*SAMLAssertion, error) {
+	assertion := SAMLAssertion{ID: el.SelectAttrValue("ID", ""), Attributes: map[string][]string{}}
+	if assertion.ID == "" || el.SelectAttrValue("Version", "") != "2.0" {
+		return nil, &SAMLError{Reason: "некорректное утверждение"}
+	}
+
+	issuer := childNS(el, samlAssertionNamespace, "Issuer")
+	if issuer == nil || strings.TrimSpace(issuer.Text()) != sp.idp.entityID {
+		return nil, &SAMLError{Reason: "утверждение выпущено неизвестным IdP"}
+	}
+
+	subject := childNS(el, samlAssertionNamespace, "Subject")
+	if subject == nil {
+		return nil, &SAMLError{Reason: "утверждение не содержит субъекта"}
+	}
+	if nameID := childNS(subject, samlAssertionNamespace, "NameID"); nameID != nil {
+		assertion.NameID = strings.TrimSpace(nameID.Text())
+	}
+	if assertion.NameID == "" {
+		return nil, &SAMLError{Reason: "утверждение не содержит NameID"}
+	}
+
+	confirmed := false
+	for _, confirmation := range childrenNS(subject, samlAssertionNamespace, "SubjectConfirmation") {
+		data := childNS(confirmation, samlAssertionNamespace, "SubjectConfirmationData")
+		if confirmation.SelectAttrValue("Method", "") != samlMethodBearer || data == nil {
+			continue
+		}
+		if data.SelectAttrValue("Recipient", "") != sp.acsURL {
+			continue
+		}
+		notOnOrAfter, err := time.Parse(time.RFC3339Nano, data.SelectAttrValue("NotOnOrAfter", ""))
+		if err != nil || !now.Before(notOnOrAfter.Add(sp.clockSkew)) {
+			continue
+		}
+		if value := data.SelectAttrValue("NotBefore", ""); value != "" {
+			notBefore, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil || now.Add(sp.clockSkew).Before(notBefore) {
+				continue
+			}
+		}
+
+		confirmedInResponseTo := data.SelectAttrValue("InResponseTo", "")
+		if inResponseTo != "" && confirmedInResponseTo != "" && inResponseTo != confirmedInResponseTo {
+			return nil, &SAMLError{Reason: "утверждение относится к другому запросу"}
+		}
+		if confirmedInResponseTo != "" {
+			inResponseTo = confirmedInResponseTo
+		}
+
+		assertion.ExpiresAt = notOnOrAfter.Add(sp.clockSkew)
+		confirmed = true
+		break
+	}
+	if !confirmed {
+		return nil, &SAMLError{Reason: "нет действующего подтверждения субъекта"}
+	}
+	assertion.InResponseTo = inResponseTo
+
+	if err := sp.checkConditions(childNS(el, samlAssertionNamespace, "Conditions"), now); err != nil {
+		return nil, err
+	}
+
+	for _, statement := range childrenNS(el, samlAssertionNamespace, "AttributeStatement") {
+		for _, attribute := range childrenNS(statement, samlAssertionNamespace, "Attribute") {
+			name := attribute.SelectAttrValue("Name", "")
+			for _, value := range childrenNS(attribute, samlAssertionNamespace, "AttributeValue") {
+				assertion.Attributes[name] = append(assertion.Attributes[name], strings.TrimSpace(value.Text()))
+			}
+		}
+	}
+
+	return &assertion, nil
+}
+
+// Проверка срока действия и аудитории утверждения
+func (sp *SAMLServiceProvider) checkConditions(conditions *etree.Element, now time.Time) error {
+	if conditions == nil {
+		return &SAMLError{Reason: "утверждение не содержит условий"}
+	}
+
+	if value := conditions.SelectAttrValue("NotBefore", ""); value != "" {
+		notBefore, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil || now.Add(sp.clockSkew).Before(notBefore) {
+			return &SAMLError{Reason: "утверждение еще не действует"}
+		}
+	}
+	if value := conditions.SelectAttrValue("NotOnOrAfter", ""); value != "" {
+		notOnOrAfter, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil || !now.Before(notOnOrAfter.Add(sp.clockSkew)) {
+			return &SAMLError{Reason: "срок действия утверждения истек"}
+		}
+	}
+
+	// Каждое ограничение аудитории должно включать этот SP
+	restrictions := childrenNS(conditions, samlAssertionNamespace, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return &SAMLError{Reason: "утверждение не ограничено аудиторией"}
+	}
+	for _, restriction := range restrictions {
+		allowed := false
+		for _, audience := range childrenNS(restriction, samlAssertionNamespace, "Audience") {
+			if strings.TrimSpace(audience.Text()) == sp.entityID {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return &SAMLError{Reason: "утверждение предназначено другому SP"}
+		}
+	}
+
+	return nil
+}
+
+// Пользователь, связанный с субъектом IdP; при первом входе пользователь создается
+func (sp *SAMLServiceProvider) resolveUser(ctx context.Context, assertion *SAMLAssertion) (*User, error) {
+	provider := "saml:" + sp.idp.entityID
+	userID, err := sp.identities.GetIdentityUserID(ctx, provider, assertion.NameID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска учетной записи SAML %w", err)
+	}
+	if userID != 0 {
+		return sp.users.FindUserById(ctx, userID)
+	}
+
+	username := assertion.NameID
+	if sp.usernameAttribute != "" {
+		username = assertion.Attribute(sp.usernameAttribute)
+	}
+	email := assertion.Attribute(sp.emailAttribute)
+	if email == "" && strings.Contains(assertion.NameID, "@") {
+		email = assertion.NameID
+	}
+	if username == "" || email == "" {
+		return nil, &SAMLError{Reason: "IdP не передал логин или адрес почты"}
+	}
+
+	// Существующая локальная учетная запись с тем же логином не связывается автоматически
+	user, err := sp.users.ProvisionExternalUser(ctx, &ExternalUser{Username: username, Email: email, Active: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = sp.identities.InsertIdentity(ctx, user.ID, provider, assertion.NameID); err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения учетной записи SAML %w", err)
+	}
+
+	return user, nil
+}
+
+// Разбор метаданных IdP: идентификатор, адрес входа и сертификаты подписи
+func parseIdPMetadata(data []byte) (*samlIdentityProvider, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, fmt.Errorf("Ошибка разбора метаданных IdP %w", err)
+	}
+
+	descriptor := doc.FindElement("//IDPSSODescriptor")
+	if descriptor == nil || descriptor.Parent() == nil {
+		return nil, fmt.Errorf("Метаданные не содержат IDPSSODescriptor")
+	}
+
+	idp := samlIdentityProvider{entityID: descriptor.Parent().SelectAttrValue("entityID", "")}
+	for _, service := range descriptor.SelectElements("SingleSignOnService") {
+		if service.SelectAttrValue("Binding", "") == samlBindingHTTPRedirect {
+			idp.ssoURL = service.SelectAttrValue("Location", "")
+			break
+		}
+	}
+
+	for _, key := range descriptor.SelectElements("KeyDescriptor") {
+		if use := key.SelectAttrValue("use", ""); use != "" && use != "signing" {
+			continue
+		}
+		for _, el := range key.FindElements(".//X509Certificate") {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(el.Text()), ""))
+			if err != nil {
+				return nil, fmt.Errorf("Некорректный сертификат IdP %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("Некорректный сертификат IdP %w", err)
+			}
+			idp.certs = append(idp.certs, cert)
+		}
+	}
+
+	if idp.entityID == "" || idp.ssoURL == "" || len(idp.certs) == 0 {
+		return nil, fmt.Errorf("Метаданные IdP должны содержать entityID, адрес входа HTTP-Redirect и сертификат подписи")
+	}
+
+	return &idp, nil
+}
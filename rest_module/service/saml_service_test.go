@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+
+	"rest_module/repository"
+)
+
+const (
+	testIdPEntityID = "https://idp.example.com/metadata"
+	testSPEntityID  = "https://sp.example.com/saml/metadata"
+	testACSURL      = "https://sp.example.com/saml/acs"
+)
+
+// Ключ и самоподписанный сертификат IdP
+type samlSigner struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newSAMLSigner(t *testing.T) *samlSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &samlSigner{key: key, cert: cert}
+}
+
+// Вложенная подпись элемента по его атрибуту ID: exc-c14n, RSA-SHA256; подпись вставляется после Issuer
+func (signer *samlSigner) sign(t *testing.T, el *etree.Element) *etree.Element {
+	t.Helper()
+	digest := sha256.Sum256(canonicalize(el, nil, nil))
+
+	signature := etree.NewElement("ds:Signature")
+	signature.CreateAttr("xmlns:ds", xmlDSigNamespace)
+	signedInfo := signature.CreateElement("ds:SignedInfo")
+	signedInfo.CreateElement("ds:CanonicalizationMethod").CreateAttr("Algorithm", xmlExcC14N)
+	signedInfo.CreateElement("ds:SignatureMethod").CreateAttr("Algorithm", xmlSignatureRSASHA256)
+	reference := signedInfo.CreateElement("ds:Reference")
+	reference.CreateAttr("URI", "#"+el.SelectAttrValue("ID", ""))
+	transforms := reference.CreateElement("ds:Transforms")
+	transforms.CreateElement("ds:Transform").CreateAttr("Algorithm", xmlEnvelopedSignature)
+	transforms.CreateElement("ds:Transform").CreateAttr("Algorithm", xmlExcC14N)
+	reference.CreateElement("ds:DigestMethod").CreateAttr("Algorithm", xmlDigestSHA256)
+	reference.CreateElement("ds:DigestValue").SetText(base64.StdEncoding.EncodeToString(digest[:]))
+	el.InsertChildAt(1, signature)
+
+	sum := sha256.Sum256(canonicalize(signedInfo, nil, nil))
+	value, err := rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature.CreateElement("ds:SignatureValue").SetText(base64.StdEncoding.EncodeToString(value))
+
+	return signature
+}
+
+// Ответ IdP с одним неподписанным утверждением, действующим в момент now
+func newSAMLResponse(now time.Time) (*etree.Document, *etree.Element, *etree.Element) {
+	doc := etree.NewDocument()
+	response := doc.CreateElement("samlp:Response")
+	response.CreateAttr("xmlns:samlp", samlProtocolNamespace)
+	response.CreateAttr("xmlns:saml", samlAssertionNamespace)
+	response.CreateAttr("ID", "_"+hex.EncodeToString(randomBytes(16)))
+	response.CreateAttr("Version", "2.0")
+	response.CreateAttr("IssueInstant", now.UTC().Format(time.RFC3339))
+	response.CreateAttr("Destination", testACSURL)
+	response.CreateAttr("InResponseTo", "_request")
+	response.CreateElement("saml:Issuer").SetText(testIdPEntityID)
+	response.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", samlStatusSuccess)
+
+	assertion := response.CreateElement("saml:Assertion")
+	assertion.CreateAttr("ID", "_"+hex.EncodeToString(randomBytes(16)))
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateAttr("IssueInstant", now.UTC().Format(time.RFC3339))
+	assertion.CreateElement("saml:Issuer").SetText(testIdPEntityID)
+
+	subject := assertion.CreateElement("saml:Subject")
+	subject.CreateElement("saml:NameID").SetText("ivan@example.com")
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", samlMethodBearer)
+	data := confirmation.CreateElement("saml:SubjectConfirmationData")
+	data.CreateAttr("Recipient", testACSURL)
+	data.CreateAttr("InResponseTo", "_request")
+	data.CreateAttr("NotOnOrAfter", now.Add(5*time.Minute).UTC().Format(time.RFC3339))
+
+	conditions := assertion.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", now.Add(-time.Minute).UTC().Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", now.Add(5*time.Minute).UTC().Format(time.RFC3339))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(testSPEntityID)
+
+	attribute := assertion.CreateElement("saml:AttributeStatement").CreateElement("saml:Attribute")
+	attribute.CreateAttr("Name", "email")
+	attribute.CreateElement("saml:AttributeValue").SetText("ivan@example.com")
+
+	return doc, response, assertion
+}
+
+func encodeSAMLResponse(t *testing.T, doc *etree.Document) string {
+	t.Helper()
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func newTestServiceProvider(certs ...*x509.Certificate) *SAMLServiceProvider {
+	return &SAMLServiceProvider{
+		idp:       &samlIdentityProvider{entityID: testIdPEntityID, ssoURL: "https://idp.example.com/sso", certs: certs},
+		entityID:  testSPEntityID,
+		acsURL:    testACSURL,
+		clockSkew: 2 * time.Minute,
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	signer := newSAMLSigner(t)
+	now := time.Now()
+	at := func(path string) func(el *etree.Element) *etree.Element {
+		return func(el *etree.Element) *etree.Element { return el.FindElement(path) }
+	}
+
+	tests := []struct {
+		name    string
+		build   func(t *testing.T, doc *etree.Document, response, assertion *etree.Element)
+		wantErr string
+	}{
+		{
+			name: "подписанное утверждение",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				signer.sign(t, assertion)
+			},
+		},
+		{
+			name: "подписанный ответ",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				signer.sign(t, response)
+			},
+		},
+		{
+			name: "неподписанное утверждение",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+			},
+			wantErr: "ответ не подписан",
+		},
+		{
+			name: "измененный текст утверждения",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				signer.sign(t, assertion)
+				at("saml:Subject/saml:NameID")(assertion).SetText("admin@example.com")
+			},
+			wantErr: "Хеш подписанного элемента не совпадает",
+		},
+		{
+			name: "измененный хеш",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				signature := signer.sign(t, assertion)
+				digest := sha256.Sum256([]byte("другое утверждение"))
+				signature.FindElement("ds:SignedInfo/ds:Reference/ds:DigestValue").SetText(base64.StdEncoding.EncodeToString(digest[:]))
+			},
+			wantErr: "Хеш подписанного элемента не совпадает",
+		},
+		{
+			name: "подпись другим ключом",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				newSAMLSigner(t).sign(t, assertion)
+			},
+			wantErr: "Подпись не прошла проверку",
+		},
+		{
+			name: "второе утверждение рядом с подписанным",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				forged := assertion.Copy()
+				signer.sign(t, assertion)
+				forged.CreateAttr("ID", "_forged")
+				at("saml:Subject/saml:NameID")(forged).SetText("admin@example.com")
+				response.AddChild(forged)
+			},
+			wantErr: "ровно одно утверждение",
+		},
+		{
+			name: "подписанное утверждение перенесено внутрь поддельного",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				signer.sign(t, assertion)
+				forged := assertion.Copy()
+				forged.RemoveChild(forged.FindElement("ds:Signature"))
+				forged.CreateAttr("ID", "_forged")
+				at("saml:Subject/saml:NameID")(forged).SetText("admin@example.com")
+				response.RemoveChild(assertion)
+				forged.AddChild(assertion)
+				response.AddChild(forged)
+			},
+			wantErr: "ответ не подписан",
+		},
+		{
+			name: "подпись перенесена в поддельное утверждение",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				signature := signer.sign(t, assertion)
+				assertion.RemoveChild(signature)
+				forged := assertion.Copy()
+				forged.CreateAttr("ID", "_forged")
+				at("saml:Subject/saml:NameID")(forged).SetText("admin@example.com")
+				forged.InsertChildAt(1, signature)
+				response.RemoveChild(assertion)
+				response.AddChild(forged)
+			},
+			wantErr: "Подпись не относится к элементу",
+		},
+		{
+			name: "ссылка подписи не совпадает с ID утверждения",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				signer.sign(t, assertion)
+				assertion.CreateAttr("ID", "_other")
+			},
+			wantErr: "Подпись не относится к элементу",
+		},
+		{
+			name: "срок действия условий истек",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				at("saml:Conditions")(assertion).CreateAttr("NotOnOrAfter", now.Add(-10*time.Minute).UTC().Format(time.RFC3339))
+				signer.sign(t, assertion)
+			},
+			wantErr: "срок действия утверждения истек",
+		},
+		{
+			name: "условия еще не действуют",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				at("saml:Conditions")(assertion).CreateAttr("NotBefore", now.Add(10*time.Minute).UTC().Format(time.RFC3339))
+				signer.sign(t, assertion)
+			},
+			wantErr: "утверждение еще не действует",
+		},
+		{
+			name: "истек срок подтверждения субъекта",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				data := at("saml:Subject/saml:SubjectConfirmation/saml:SubjectConfirmationData")(assertion)
+				data.CreateAttr("NotOnOrAfter", now.Add(-10*time.Minute).UTC().Format(time.RFC3339))
+				signer.sign(t, assertion)
+			},
+			wantErr: "нет действующего подтверждения субъекта",
+		},
+		{
+			name: "другая аудитория",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				at("saml:Conditions/saml:AudienceRestriction/saml:Audience")(assertion).SetText("https://other.example.com")
+				signer.sign(t, assertion)
+			},
+			wantErr: "утверждение предназначено другому SP",
+		},
+		{
+			name: "другой получатель в подтверждении субъекта",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				data := at("saml:Subject/saml:SubjectConfirmation/saml:SubjectConfirmationData")(assertion)
+				data.CreateAttr("Recipient", "https://other.example.com/acs")
+				signer.sign(t, assertion)
+			},
+			wantErr: "нет действующего подтверждения субъекта",
+		},
+		{
+			name: "другой адрес назначения ответа",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				response.CreateAttr("Destination", "https://other.example.com/acs")
+				signer.sign(t, assertion)
+			},
+			wantErr: "ответ предназначен другому получателю",
+		},
+		{
+			name: "утверждение другого IdP",
+			build: func(t *testing.T, doc *etree.Document, response, assertion *etree.Element) {
+				at("saml:Issuer")(assertion).SetText("https://other-idp.example.com")
+				signer.sign(t, assertion)
+			},
+			wantErr: "утверждение выпущено неизвестным IdP",
+		},
+	}
+
+	sp := newTestServiceProvider(signer.cert)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, response, assertion := newSAMLResponse(now)
+			test.build(t, doc, response, assertion)
+
+			parsed, err := sp.ParseResponse(encodeSAMLResponse(t, doc), now)
+			if test.wantErr != "" {
+				var samlErr *SAMLError
+				if !errors.As(err, &samlErr) || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Ошибка %v, ожидалась %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if parsed.ID != assertion.SelectAttrValue("ID", "") || parsed.NameID != "ivan@example.com" ||
+				parsed.InResponseTo != "_request" || parsed.Attribute("email") != "ivan@example.com" {
+				t.Errorf("Неверное утверждение %+v", parsed)
+			}
+		})
+	}
+}
+
+// Утверждение принимается один раз; повторный ответ с тем же утверждением отклоняется
+func TestAcceptAssertionReplay(t *testing.T) {
+	db := testDatabase(t)
+	signer := newSAMLSigner(t)
+	now := time.Now()
+
+	sp := newTestServiceProvider(signer.cert)
+	sp.repository = repository.InitSAMLRepository(db)
+	sp.allowIdPInitiated = true
+
+	doc, response, assertion := newSAMLResponse(now)
+	response.RemoveAttr("InResponseTo")
+	assertion.FindElement("saml:Subject/saml:SubjectConfirmation/saml:SubjectConfirmationData").RemoveAttr("InResponseTo")
+	signer.sign(t, assertion)
+	encoded := encodeSAMLResponse(t, doc)
+
+	for attempt := range 2 {
+		parsed, err := sp.ParseResponse(encoded, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = sp.acceptAssertion(context.Background(), parsed)
+		switch {
+		case attempt == 0 && err != nil:
+			t.Fatal(err)
+		case attempt == 1 && (err == nil || !strings.Contains(err.Error(), "утверждение уже использовано")):
+			t.Fatalf("Повторное утверждение: %v", err)
+		}
+	}
+}
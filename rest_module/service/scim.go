@@ -0,0 +1,324 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Схемы SCIM 2.0 (RFC 7643, RFC 7644)
+const (
+	SCIMSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIMSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SCIMSchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SCIMSchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// Ошибка SCIM (RFC 7644, раздел 3.12)
+type SCIMError struct {
+	Schemas    []string `json:"schemas"`
+	Status     string   `json:"status"`
+	SCIMType   string   `json:"scimType,omitempty"`
+	Detail     string   `json:"detail,omitempty"`
+	HTTPStatus int      `json:"-"`
+}
+
+func (err *SCIMError) Error() string {
+	return err.Detail
+}
+
+func scimError(status int, scimType, detail string) *SCIMError {
+	return &SCIMError{Schemas: []string{SCIMSchemaError}, Status: fmt.Sprint(status), SCIMType: scimType, Detail: detail, HTTPStatus: status}
+}
+
+// Служебные атрибуты ресурса
+type SCIMMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+// Адрес почты пользователя
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Ссылка на участника группы или группу пользователя
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Пользователь SCIM; пароль принимается, но никогда не возвращается
+type SCIMUser struct {
+	Schemas    []string     `json:"schemas"`
+	ID         string       `json:"id,omitempty"`
+	ExternalID string       `json:"externalId,omitempty"`
+	UserName   string       `json:"userName"`
+	Password   string       `json:"password,omitempty"`
+	Active     *bool        `json:"active,omitempty"`
+	Emails     []SCIMEmail  `json:"emails,omitempty"`
+	Groups     []SCIMMember `json:"groups,omitempty"`
+	Meta       *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// Группа SCIM, соответствует роли
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []SCIMMember `json:"members,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// Страница результатов поиска
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// Запрос PATCH
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// Операция PATCH: add, replace или remove
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Параметры поиска: фильтр, страница и исключаемые атрибуты
+type SCIMSearch struct {
+	Filter             string
+	StartIndex         int
+	Count              int
+	ExcludedAttributes string
+}
+
+// Excludes проверяет, исключен ли атрибут из ответа
+func (search *SCIMSearch) Excludes(attribute string) bool {
+	for _, excluded := range strings.Split(search.ExcludedAttributes, ",") {
+		if strings.EqualFold(strings.TrimSpace(excluded), attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// Условие фильтра вида "атрибут оператор значение"
+type scimCondition struct {
+	Path     string // атрибут в нижнем регистре
+	Operator string // оператор в нижнем регистре
+	Value    string
+}
+
+// Разбор фильтра SCIM. Поддерживаются сравнения (eq, ne, co, sw, ew, gt, ge, lt, le, pr),
+// объединенные через and; or, not и группировка не поддерживаются.
+func parseSCIMFilter(filter string) ([]scimCondition, error) {
+	tokens, err := scimFilterTokens(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []scimCondition{}
+	for i := 0; i < len(tokens); {
+		if len(conditions) > 0 {
+			if !strings.EqualFold(tokens[i], "and") {
+				return nil, scimError(http.StatusBadRequest, "invalidFilter", "Условия фильтра можно объединять только через and")
+			}
+			i++
+		}
+		if i+1 >= len(tokens) {
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "Незавершенное условие фильтра")
+		}
+
+		condition := scimCondition{Path: strings.ToLower(tokens[i]), Operator: strings.ToLower(tokens[i+1])}
+		if strings.ContainsAny(condition.Path, "()[]") || condition.Path == "not" {
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "Группировка и not в фильтре не поддерживаются")
+		}
+		i += 2
+
+		switch condition.Operator {
+		case "pr":
+		case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+			if i >= len(tokens) {
+				return nil, scimError(http.StatusBadRequest, "invalidFilter", "Не указано значение условия фильтра")
+			}
+			condition.Value = tokens[i]
+			i++
+		default:
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "Неизвестный оператор фильтра "+condition.Operator)
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+// Лексемы фильтра; строки в кавычках декодируются как строки JSON
+func scimFilterTokens(filter string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(filter); {
+		switch {
+		case filter[i] == ' ':
+			i++
+		case filter[i] == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, scimError(http.StatusBadRequest, "invalidFilter", "Незакрытая строка в фильтре")
+			}
+
+			var value string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &value); err != nil {
+				return nil, scimError(http.StatusBadRequest, "invalidFilter", "Некорректная строка в фильтре")
+			}
+			tokens = append(tokens, value)
+			i = end + 1
+		default:
+			end := strings.IndexByte(filter[i:], ' ')
+			if end < 0 {
+				end = len(filter) - i
+			}
+			tokens = append(tokens, filter[i:i+end])
+			i += end
+		}
+	}
+
+	return tokens, nil
+}
+
+// Путь атрибута без URN схемы пользователя или группы, в нижнем регистре
+func scimAttributePath(path string) string {
+	path = strings.ToLower(strings.TrimSpace(path))
+	for _, schema := range []string{SCIMSchemaUser, SCIMSchemaGroup} {
+		if rest, found := strings.CutPrefix(path, strings.ToLower(schema)+":"); found {
+			return rest
+		}
+	}
+	return path
+}
+
+// Логическое значение; некоторые клиенты передают его строкой "True"/"False"
+func scimBool(value json.RawMessage) (bool, error) {
+	var flag bool
+	if err := json.Unmarshal(value, &flag); err == nil {
+		return flag, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		switch strings.ToLower(text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+
+	return false, scimError(http.StatusBadRequest, "invalidValue", "Ожидалось логическое значение")
+}
+
+func scimString(value json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(value, &text); err != nil {
+		return "", scimError(http.StatusBadRequest, "invalidValue", "Ожидалась строка")
+	}
+	return text, nil
+}
+
+// Основной адрес почты: помеченный primary, иначе первый
+func primarySCIMEmail(emails []SCIMEmail) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// Описание атрибута схемы (RFC 7643, раздел 7)
+type scimAttribute struct {
+	Name          string          `json:"name"`
+	Type          string          `json:"type"`
+	MultiValued   bool            `json:"multiValued"`
+	Required      bool            `json:"required"`
+	CaseExact     bool            `json:"caseExact"`
+	Mutability    string          `json:"mutability"`
+	Returned      string          `json:"returned"`
+	Uniqueness    string          `json:"uniqueness"`
+	SubAttributes []scimAttribute `json:"subAttributes,omitempty"`
+}
+
+func scimStringAttribute(name string, required bool, mutability, returned, uniqueness string) scimAttribute {
+	return scimAttribute{Name: name, Type: "string", Required: required, Mutability: mutability, Returned: returned, Uniqueness: uniqueness}
+}
+
+// Схемы поддерживаемых ресурсов
+func scimSchemas() []map[string]interface{} {
+	emails := scimAttribute{Name: "emails", Type: "complex", MultiValued: true, Required: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+		SubAttributes: []scimAttribute{
+			scimStringAttribute("value", true, "readWrite", "default", "none"),
+			scimStringAttribute("type", false, "readWrite", "default", "none"),
+			{Name: "primary", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+		}}
+	reference := func(name, mutability string) scimAttribute {
+		return scimAttribute{Name: name, Type: "complex", MultiValued: true, Mutability: mutability, Returned: "default", Uniqueness: "none",
+			SubAttributes: []scimAttribute{
+				scimStringAttribute("value", false, "immutable", "default", "none"),
+				scimStringAttribute("display", false, "readOnly", "default", "none"),
+				{Name: "$ref", Type: "reference", Mutability: "immutable", Returned: "default", Uniqueness: "none"},
+			}}
+	}
+
+	return []map[string]interface{}{
+		{
+			"schemas":     []string{SCIMSchemaSchema},
+			"id":          SCIMSchemaUser,
+			"name":        "User",
+			"description": "Пользователь",
+			"attributes": []scimAttribute{
+				scimStringAttribute("userName", true, "readWrite", "default", "server"),
+				scimStringAttribute("password", false, "writeOnly", "never", "none"),
+				{Name: "active", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+				emails,
+				reference("groups", "readOnly"),
+			},
+		},
+		{
+			"schemas":     []string{SCIMSchemaSchema},
+			"id":          SCIMSchemaGroup,
+			"name":        "Group",
+			"description": "Группа пользователей, соответствует роли",
+			"attributes": []scimAttribute{
+				scimStringAttribute("displayName", true, "readWrite", "default", "server"),
+				reference("members", "readWrite"),
+			},
+		},
+	}
+}
@@ -0,0 +1,878 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rest_module/repository"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Поля пользователя, доступные в фильтре SCIM
+var scimUserFilterFields = map[string]string{
+	"id":                "id",
+	"username":          "username",
+	"emails":            "email",
+	"emails.value":      "email",
+	"externalid":        "external_id",
+	"active":            "active",
+	"meta.created":      "created_at",
+	"meta.lastmodified": "updated_at",
+}
+
+// Провижининг пользователей и групп (ролей) по SCIM 2.0
+type SCIMService struct {
+	users      *UserManager               // сервис пользователей
+	roles      *RoleManager               // сервис ролей
+	repository repository.UserSearchStore // поиск пользователей
+	baseURL    string                     // адрес SCIM API
+	maxResults int                        // наибольший размер страницы
+	dbTimeout  operationTimeout           // ограничение времени операции с БД
+}
+
+// Конструктор сервиса SCIM; baseURL - внешний адрес сервиса
+func SCIMServiceNewInstance(users *UserManager, roles *RoleManager, repository repository.UserSearchStore, baseURL string) *SCIMService {
+	service := SCIMService{}
+	service.users = users
+	service.roles = roles
+	service.repository = repository
+	service.baseURL = baseURL + "/scim/v2"
+	service.maxResults = GetEnvInt("SCIM_MAX_RESULTS", 200)
+	service.dbTimeout = operationTimeoutFromEnv()
+	return &service
+}
+
+// Поиск пользователей по фильтру SCIM
+func (service *SCIMService) ListUsers(ctx context.Context, search *SCIMSearch) (*SCIMListResponse, error) {
+	go log.Println("Поиск пользователей SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	conditions, err := parseSCIMFilter(search.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := repository.UserQuery{}
+	for _, condition := range conditions {
+		field, ok := scimUserFilterFields[scimAttributePath(condition.Path)]
+		if !ok {
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "Фильтр по атрибуту "+condition.Path+" не поддерживается")
+		}
+		// Идентификаторы числовые, нечисловой идентификатор ничего не находит
+		if field == "id" && condition.Operator != "pr" {
+			if _, err := strconv.ParseInt(condition.Value, 10, 64); err != nil {
+				return service.listResponse(search, 0, []SCIMUser{}), nil
+			}
+		}
+		query.Conditions = append(query.Conditions, repository.UserCondition{Field: field, Operator: condition.Operator, Value: condition.Value})
+	}
+	query.Offset, query.Limit = service.page(search)
+
+	users, total, err := service.repository.SearchUsers(ctx, &query)
+	if err != nil {
+		if repository.IsInvalidQuery(err) {
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "Некорректный фильтр: "+err.Error())
+		}
+		return nil, scimStorageError("Ошибка поиска пользователей", err)
+	}
+
+	roles, err := service.roleIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := []SCIMUser{}
+	for _, user := range *users {
+		resources = append(resources, service.userResource(&user, roles))
+	}
+
+	return service.listResponse(search, total, resources), nil
+}
+
+// Пользователь по идентификатору
+func (service *SCIMService) GetUser(ctx context.Context, id string) (*SCIMUser, error) {
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	user, err := service.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.userResourceWithRoles(ctx, user)
+}
+
+// Создание пользователя
+func (service *SCIMService) CreateUser(ctx context.Context, resource *SCIMUser) (*SCIMUser, error) {
+	go log.Println("Создание пользователя SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	external := ExternalUser{Username: resource.UserName, Email: primarySCIMEmail(resource.Emails), Password: resource.Password,
+		ExternalID: resource.ExternalID, Active: resource.Active == nil || *resource.Active}
+	if err := checkUser(&external); err != nil {
+		return nil, err
+	}
+
+	user, err := service.users.ProvisionExternalUser(ctx, &external)
+	if err != nil {
+		return nil, scimUserError(err)
+	}
+
+	// ProvisionExternalUser не заполняет служебные поля
+	if created, _ := service.users.FindUserById(ctx, user.ID); created != nil {
+		user = created
+	}
+	return service.userResourceWithRoles(ctx, user)
+}
+
+// Замена пользователя; не переданные атрибуты сбрасываются
+func (service *SCIMService) ReplaceUser(ctx context.Context, id string, resource *SCIMUser) (*SCIMUser, error) {
+	go log.Println("Замена пользователя SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	user, err := service.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	external := ExternalUser{Username: resource.UserName, Email: primarySCIMEmail(resource.Emails), Password: resource.Password,
+		ExternalID: resource.ExternalID, Active: resource.Active == nil || *resource.Active}
+	return service.updateUser(ctx, user.ID, &external)
+}
+
+// Частичное изменение пользователя
+func (service *SCIMService) PatchUser(ctx context.Context, id string, patch *SCIMPatchRequest) (*SCIMUser, error) {
+	go log.Println("Изменение пользователя SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := checkPatchRequest(patch); err != nil {
+		return nil, err
+	}
+
+	user, err := service.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	external := ExternalUser{Username: user.Username, Email: user.Email, ExternalID: user.ExternalID, Active: user.Active}
+	for _, operation := range patch.Operations {
+		op := strings.ToLower(operation.Op)
+		path := scimAttributePath(operation.Path)
+
+		switch {
+		case op == "remove":
+			if err = removeUserAttribute(&external, path); err != nil {
+				return nil, err
+			}
+		case path == "":
+			// Без пути значение - объект с заменяемыми атрибутами
+			attributes := map[string]json.RawMessage{}
+			if err = json.Unmarshal(operation.Value, &attributes); err != nil {
+				return nil, scimError(http.StatusBadRequest, "invalidValue", "Значение операции без пути должно быть объектом")
+			}
+			for name, value := range attributes {
+				if err = applyUserAttribute(&external, scimAttributePath(name), value); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			if err = applyUserAttribute(&external, path, operation.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return service.updateUser(ctx, user.ID, &external)
+}
+
+// Удаление пользователя
+func (service *SCIMService) DeleteUser(ctx context.Context, id string) error {
+	go log.Println("Удаление пользователя SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	user, err := service.findUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err = service.users.DeleteUserById(ctx, user.ID); err != nil {
+		return scimStorageError("Ошибка удаления пользователя", err)
+	}
+
+	return nil
+}
+
+// Поиск групп по фильтру SCIM; поддерживаются атрибуты displayName и id
+func (service *SCIMService) ListGroups(ctx context.Context, search *SCIMSearch) (*SCIMListResponse, error) {
+	go log.Println("Поиск групп SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	conditions, err := parseSCIMFilter(search.Filter)
+	if err != nil {
+		return nil, err
+	}
+	for i := range conditions {
+		conditions[i].Path = scimAttributePath(conditions[i].Path)
+		if conditions[i].Path != "displayname" && conditions[i].Path != "id" {
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "Фильтр по атрибуту "+conditions[i].Path+" не поддерживается")
+		}
+		if conditions[i].Operator != "pr" && !slices.Contains([]string{"eq", "ne", "co", "sw", "ew"}, conditions[i].Operator) {
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "Оператор "+conditions[i].Operator+" для групп не поддерживается")
+		}
+	}
+
+	roles, err := service.roles.FindAllRoles(ctx)
+	if err != nil {
+		return nil, scimStorageError("Ошибка чтения групп", err)
+	}
+
+	matched := []Role{}
+	for _, role := range *roles {
+		if matchGroup(&role, conditions) {
+			matched = append(matched, role)
+		}
+	}
+
+	offset, limit := service.page(search)
+	resources := []SCIMGroup{}
+	for i := offset; i < len(matched) && len(resources) < limit; i++ {
+		group, err := service.groupResource(ctx, &matched[i], !search.Excludes("members"))
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, *group)
+	}
+
+	return service.listResponse(search, len(matched), resources), nil
+}
+
+// Группа по идентификатору
+func (service *SCIMService) GetGroup(ctx context.Context, id string) (*SCIMGroup, error) {
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	role, err := service.findRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.groupResource(ctx, role, true)
+}
+
+// Создание группы с участниками
+func (service *SCIMService) CreateGroup(ctx context.Context, resource *SCIMGroup) (*SCIMGroup, error) {
+	go log.Println("Создание группы SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if exist, _ := service.roles.findRole(ctx, resource.DisplayName); exist != nil {
+		return nil, scimError(http.StatusConflict, "uniqueness", "Группа "+resource.DisplayName+" уже есть")
+	}
+
+	members, err := memberIDs(resource.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := service.roles.CreateRole(ctx, resource.DisplayName, "")
+	if err != nil {
+		return nil, scimChangeError("invalidValue", err)
+	}
+	if err = service.syncMembers(ctx, role, nil, members); err != nil {
+		return nil, err
+	}
+
+	return service.groupResource(ctx, role, true)
+}
+
+// Замена группы: название и полный состав участников
+func (service *SCIMService) ReplaceGroup(ctx context.Context, id string, resource *SCIMGroup) (*SCIMGroup, error) {
+	go log.Println("Замена группы SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	role, err := service.findMutableRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := memberIDs(resource.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.updateGroup(ctx, role, resource.DisplayName, members)
+}
+
+// Частичное изменение группы: название и участники
+func (service *SCIMService) PatchGroup(ctx context.Context, id string, patch *SCIMPatchRequest) (*SCIMGroup, error) {
+	go log.Println("Изменение группы SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := checkPatchRequest(patch); err != nil {
+		return nil, err
+	}
+
+	role, err := service.findMutableRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := service.roleMemberIDs(ctx, role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := role.Name
+	members := slices.Clone(current)
+	for _, operation := range patch.Operations {
+		op := strings.ToLower(operation.Op)
+		path := scimAttributePath(operation.Path)
+
+		if op == "remove" {
+			if members, err = removeMembers(members, path, operation.Value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if op != "add" && op != "replace" {
+			return nil, scimError(http.StatusBadRequest, "invalidSyntax", "Неизвестная операция "+operation.Op)
+		}
+
+		attributes := map[string]json.RawMessage{path: operation.Value}
+		if path == "" {
+			// Без пути значение - объект с изменяемыми атрибутами
+			if err = json.Unmarshal(operation.Value, &attributes); err != nil {
+				return nil, scimError(http.StatusBadRequest, "invalidValue", "Значение операции без пути должно быть объектом")
+			}
+		}
+
+		for attribute, value := range attributes {
+			switch scimAttributePath(attribute) {
+			case "displayname":
+				if name, err = scimString(value); err != nil {
+					return nil, err
+				}
+			case "members":
+				var list []SCIMMember
+				if err = json.Unmarshal(value, &list); err != nil {
+					return nil, scimError(http.StatusBadRequest, "invalidValue", "Ожидался список участников")
+				}
+				ids, err := memberIDs(list)
+				if err != nil {
+					return nil, err
+				}
+				if op == "replace" {
+					members = nil
+				}
+				for _, id := range ids {
+					if !slices.Contains(members, id) {
+						members = append(members, id)
+					}
+				}
+			default:
+				return nil, scimError(http.StatusBadRequest, "invalidPath", "Атрибут "+attribute+" группы не поддерживается")
+			}
+		}
+	}
+
+	return service.updateGroup(ctx, role, name, members)
+}
+
+// Удаление группы
+func (service *SCIMService) DeleteGroup(ctx context.Context, id string) error {
+	go log.Println("Удаление группы SCIM")
+	ctx, cancel := service.dbTimeout.apply(ctx)
+	defer cancel()
+	role, err := service.findMutableRole(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err = service.roles.DeleteRole(ctx, role.ID); err != nil {
+		return scimChangeError("mutability", err)
+	}
+
+	return nil
+}
+
+// Возможности сервиса (RFC 7643, раздел 5)
+func (service *SCIMService) ServiceProviderConfig() map[string]interface{} {
+	supported := func(value bool) map[string]bool { return map[string]bool{"supported": value} }
+	return map[string]interface{}{
+		"schemas":        []string{SCIMSchemaServiceProviderConfig},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": service.maxResults},
+		"changePassword": supported(true),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Токен доступа или ключ API с разрешением " + PermissionSCIMProvision,
+			"primary":     true,
+		}},
+		"meta": map[string]string{"resourceType": "ServiceProviderConfig", "location": service.baseURL + "/ServiceProviderConfig"},
+	}
+}
+
+// Типы ресурсов (RFC 7643, раздел 6)
+func (service *SCIMService) ResourceTypes() []map[string]interface{} {
+	resourceType := func(name, endpoint, schema string) map[string]interface{} {
+		return map[string]interface{}{
+			"schemas":  []string{SCIMSchemaResourceType},
+			"id":       name,
+			"name":     name,
+			"endpoint": endpoint,
+			"schema":   schema,
+			"meta":     map[string]string{"resourceType": "ResourceType", "location": service.baseURL + "/ResourceTypes/" + name},
+		}
+	}
+
+	return []map[string]interface{}{
+		resourceType("User", "/Users", SCIMSchemaUser),
+		resourceType("Group", "/Groups", SCIMSchemaGroup),
+	}
+}
+
+// Тип ресурса по имени
+func (service *SCIMService) ResourceType(name string) (map[string]interface{}, error) {
+	for _, resourceType := range service.ResourceTypes() {
+		if resourceType["id"] == name {
+			return resourceType, nil
+		}
+	}
+
+	return nil, scimError(http.StatusNotFound, "", "Тип ресурса "+name+" не найден")
+}
+
+// Схемы ресурсов (RFC 7643, раздел 7)
+func (service *SCIMService) Schemas() []map[string]interface{} {
+	schemas := scimSchemas()
+	for _, schema := range schemas {
+		schema["meta"] = map[string]string{"resourceType": "Schema", "location": service.baseURL + "/Schemas/" + schema["id"].(string)}
+	}
+
+	return schemas
+}
+
+// Схема по URN
+func (service *SCIMService) Schema(id string) (map[string]interface{}, error) {
+	for _, schema := range service.Schemas() {
+		if schema["id"] == id {
+			return schema, nil
+		}
+	}
+
+	return nil, scimError(http.StatusNotFound, "", "Схема "+id+" не найдена")
+}
+
+// Обновление пользователя с проверкой уникальности логина и внешнего идентификатора
+func (service *SCIMService) updateUser(ctx context.Context, id int64, external *ExternalUser) (*SCIMUser, error) {
+	if err := checkUser(external); err != nil {
+		return nil, err
+	}
+
+	user, err := service.users.UpdateExternalUser(ctx, id, external)
+	if err != nil {
+		return nil, scimUserError(err)
+	}
+
+	return service.userResourceWithRoles(ctx, user)
+}
+
+// Ошибка сохранения пользователя: занятый логин, адрес почты или externalId - конфликт уникальности
+func scimUserError(err error) *SCIMError {
+	if repository.IsUserConflict(err) {
+		return scimError(http.StatusConflict, "uniqueness", err.Error())
+	}
+
+	return scimChangeError("invalidValue", err)
+}
+
+// Ошибка изменения ресурса: сбой хранилища - как в scimStorageError, иначе 400 с типом scimType
+func scimChangeError(scimType string, err error) *SCIMError {
+	if repository.IsTimeout(err) || repository.IsUnavailable(err) || repository.IsCanceled(err) || errors.Is(err, ErrPasswordCheckUnavailable) {
+		return scimStorageError("Ошибка изменения ресурса", err)
+	}
+
+	return scimError(http.StatusBadRequest, scimType, err.Error())
+}
+
+// Статус ответа на запрос, клиент которого закрыл соединение
+const statusClientClosedRequest = 499
+
+// Ошибка хранилища: истекшее время ожидания - 504, недоступная БД или непроверенная парольная политика - 503, иначе 500.
+// Текст ошибки БД клиенту не передается, только в журнал
+func scimStorageError(detail string, err error) *SCIMError {
+	switch {
+	case repository.IsCanceled(err):
+		return scimError(statusClientClosedRequest, "", "Запрос отменен клиентом")
+	case repository.IsTimeout(err):
+		return scimError(http.StatusGatewayTimeout, "", "Превышено время ожидания ответа базы данных")
+	case repository.IsUnavailable(err):
+		return scimError(http.StatusServiceUnavailable, "", "База данных временно недоступна")
+	case errors.Is(err, ErrPasswordCheckUnavailable):
+		return scimError(http.StatusServiceUnavailable, "", ErrPasswordCheckUnavailable.Error())
+	}
+
+	log.Println(detail, err)
+	return scimError(http.StatusInternalServerError, "", detail)
+}
+
+// Проверка обязательных атрибутов пользователя.
+// Уникальность логина, адреса почты и externalId проверяет хранилище при записи
+func checkUser(external *ExternalUser) error {
+	if strings.TrimSpace(external.Username) == "" {
+		return scimError(http.StatusBadRequest, "invalidValue", "Не указан userName")
+	}
+	if external.Email == "" {
+		return scimError(http.StatusBadRequest, "invalidValue", "Не указан адрес почты")
+	}
+
+	return nil
+}
+
+// Изменение названия и состава группы
+func (service *SCIMService) updateGroup(ctx context.Context, role *Role, name string, members []int64) (*SCIMGroup, error) {
+	current, err := service.roleMemberIDs(ctx, role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != role.Name {
+		if exist, _ := service.roles.findRole(ctx, name); exist != nil {
+			return nil, scimError(http.StatusConflict, "uniqueness", "Группа "+name+" уже есть")
+		}
+		if role, err = service.roles.RenameRole(ctx, role.ID, name); err != nil {
+			return nil, scimChangeError("mutability", err)
+		}
+	}
+
+	if err = service.syncMembers(ctx, role, current, members); err != nil {
+		return nil, err
+	}
+
+	return service.groupResource(ctx, role, true)
+}
+
+// Назначение и снятие роли до совпадения состава участников с members
+func (service *SCIMService) syncMembers(ctx context.Context, role *Role, current, members []int64) error {
+	for _, id := range members {
+		if slices.Contains(current, id) {
+			continue
+		}
+		if _, err := service.roles.AssignRole(ctx, id, role.Name); err != nil {
+			return scimChangeError("invalidValue", err)
+		}
+	}
+
+	for _, id := range current {
+		if slices.Contains(members, id) {
+			continue
+		}
+		if _, err := service.roles.RemoveRole(ctx, id, role.Name); err != nil {
+			return scimChangeError("invalidValue", err)
+		}
+	}
+
+	return nil
+}
+
+func (service *SCIMService) findUser(ctx context.Context, id string) (*User, error) {
+	userID, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		if user, _ := service.users.FindUserById(ctx, userID); user != nil {
+			return user, nil
+		}
+	}
+
+	return nil, scimError(http.StatusNotFound, "", "Пользователь "+id+" не найден")
+}
+
+func (service *SCIMService) findRole(ctx context.Context, id string) (*Role, error) {
+	roleID, err := strconv.ParseInt(id, 10, 64)
+	if err == nil {
+		if role, _ := service.roles.FindRoleByID(ctx, roleID); role != nil {
+			return role, nil
+		}
+	}
+
+	return nil, scimError(http.StatusNotFound, "", "Группа "+id+" не найдена")
+}
+
+// Роль администратора через SCIM не изменяется, чтобы провижининг не выдавал полный доступ
+func (service *SCIMService) findMutableRole(ctx context.Context, id string) (*Role, error) {
+	role, err := service.findRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if role.Name == RoleAdmin {
+		return nil, scimError(http.StatusForbidden, "", "Группа "+RoleAdmin+" не изменяется через SCIM")
+	}
+
+	return role, nil
+}
+
+func (service *SCIMService) roleMemberIDs(ctx context.Context, roleID int64) ([]int64, error) {
+	members, err := service.roles.RoleMembers(ctx, roleID)
+	if err != nil {
+		return nil, scimStorageError("Ошибка чтения участников группы", err)
+	}
+
+	ids := []int64{}
+	for _, member := range *members {
+		ids = append(ids, member.ID)
+	}
+
+	return ids, nil
+}
+
+// Идентификаторы ролей по названию
+func (service *SCIMService) roleIDs(ctx context.Context) (map[string]int64, error) {
+	roles, err := service.roles.FindAllRoles(ctx)
+	if err != nil {
+		return nil, scimStorageError("Ошибка чтения групп", err)
+	}
+
+	ids := map[string]int64{}
+	for _, role := range *roles {
+		ids[role.Name] = role.ID
+	}
+
+	return ids, nil
+}
+
+func (service *SCIMService) userResourceWithRoles(ctx context.Context, user *User) (*SCIMUser, error) {
+	roles, err := service.roleIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := service.userResource(user, roles)
+	return &resource, nil
+}
+
+func (service *SCIMService) userResource(user *User, roles map[string]int64) SCIMUser {
+	id := strconv.FormatInt(user.ID, 10)
+	active := user.Active
+	created, modified := user.CreatedAt, user.UpdatedAt
+
+	resource := SCIMUser{
+		Schemas:    []string{SCIMSchemaUser},
+		ID:         id,
+		ExternalID: user.ExternalID,
+		UserName:   user.Username,
+		Active:     &active,
+		Emails:     []SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Meta:       &SCIMMeta{ResourceType: "User", Created: &created, LastModified: &modified, Location: service.baseURL + "/Users/" + id},
+	}
+	for _, name := range user.Roles {
+		roleID := strconv.FormatInt(roles[name], 10)
+		resource.Groups = append(resource.Groups, SCIMMember{Value: roleID, Display: name, Ref: service.baseURL + "/Groups/" + roleID})
+	}
+
+	return resource
+}
+
+func (service *SCIMService) groupResource(ctx context.Context, role *Role, withMembers bool) (*SCIMGroup, error) {
+	id := strconv.FormatInt(role.ID, 10)
+	group := SCIMGroup{
+		Schemas:     []string{SCIMSchemaGroup},
+		ID:          id,
+		DisplayName: role.Name,
+		Meta:        &SCIMMeta{ResourceType: "Group", Location: service.baseURL + "/Groups/" + id},
+	}
+	if !withMembers {
+		return &group, nil
+	}
+
+	members, err := service.roles.RoleMembers(ctx, role.ID)
+	if err != nil {
+		return nil, scimStorageError("Ошибка чтения участников группы", err)
+	}
+	for _, member := range *members {
+		memberID := strconv.FormatInt(member.ID, 10)
+		group.Members = append(group.Members, SCIMMember{Value: memberID, Display: member.Username, Ref: service.baseURL + "/Users/" + memberID})
+	}
+
+	return &group, nil
+}
+
+// Смещение и размер страницы; startIndex в SCIM начинается с 1
+func (service *SCIMService) page(search *SCIMSearch) (int, int) {
+	offset := max(search.StartIndex, 1) - 1
+	limit := search.Count
+	if limit < 0 {
+		limit = 0
+	}
+	if limit > service.maxResults {
+		limit = service.maxResults
+	}
+
+	return offset, limit
+}
+
+func (service *SCIMService) listResponse(search *SCIMSearch, total int, resources interface{}) *SCIMListResponse {
+	count := 0
+	switch list := resources.(type) {
+	case []SCIMUser:
+		count = len(list)
+	case []SCIMGroup:
+		count = len(list)
+	}
+
+	return &SCIMListResponse{
+		Schemas:      []string{SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   max(search.StartIndex, 1),
+		ItemsPerPage: count,
+		Resources:    resources,
+	}
+}
+
+func checkPatchRequest(patch *SCIMPatchRequest) error {
+	if !slices.Contains(patch.Schemas, SCIMSchemaPatchOp) {
+		return scimError(http.StatusBadRequest, "invalidSyntax", "Запрос PATCH должен использовать схему "+SCIMSchemaPatchOp)
+	}
+	for _, operation := range patch.Operations {
+		switch strings.ToLower(operation.Op) {
+		case "add", "replace", "remove":
+		default:
+			return scimError(http.StatusBadRequest, "invalidSyntax", "Неизвестная операция "+operation.Op)
+		}
+	}
+
+	return nil
+}
+
+// Изменение атрибута пользователя операцией add или replace; неизвестные атрибуты пропускаются
+func applyUserAttribute(external *ExternalUser, path string, value json.RawMessage) error {
+	var err error
+	switch {
+	case path == "username":
+		external.Username, err = scimString(value)
+	case path == "externalid":
+		external.ExternalID, err = scimString(value)
+	case path == "password":
+		external.Password, err = scimString(value)
+	case path == "active":
+		external.Active, err = scimBool(value)
+	case path == "emails":
+		var emails []SCIMEmail
+		if json.Unmarshal(value, &emails) != nil || primarySCIMEmail(emails) == "" {
+			return scimError(http.StatusBadRequest, "invalidValue", "Ожидался непустой список адресов почты")
+		}
+		external.Email = primarySCIMEmail(emails)
+	case path == "emails.value" || (strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value")):
+		// Хранится один адрес, поэтому фильтр в пути не сужает выбор
+		external.Email, err = scimString(value)
+	}
+
+	return err
+}
+
+// Удаление атрибута пользователя; обязательные атрибуты удалить нельзя
+func removeUserAttribute(external *ExternalUser, path string) error {
+	switch {
+	case path == "":
+		return scimError(http.StatusBadRequest, "noTarget", "Для удаления не указан путь")
+	case path == "externalid":
+		external.ExternalID = ""
+	case path == "username" || path == "active" || strings.HasPrefix(path, "emails"):
+		return scimError(http.StatusBadRequest, "mutability", "Атрибут "+path+" нельзя удалить")
+	}
+
+	return nil
+}
+
+// Удаление участников: всех, перечисленных в значении или выбранных фильтром members[value eq "id"]
+func removeMembers(members []int64, path string, value json.RawMessage) ([]int64, error) {
+	var removed []int64
+	switch {
+	case path == "members" && (len(value) == 0 || string(value) == "null"):
+		return []int64{}, nil
+	case path == "members":
+		var list []SCIMMember
+		if err := json.Unmarshal(value, &list); err != nil {
+			return nil, scimError(http.StatusBadRequest, "invalidValue", "Ожидался список участников")
+		}
+		ids, err := memberIDs(list)
+		if err != nil {
+			return nil, err
+		}
+		removed = ids
+	case strings.HasPrefix(path, "members[") && strings.HasSuffix(path, "]"):
+		conditions, err := parseSCIMFilter(path[len("members[") : len(path)-1])
+		if err != nil {
+			return nil, err
+		}
+		for _, condition := range conditions {
+			if condition.Path != "value" || condition.Operator != "eq" {
+				return nil, scimError(http.StatusBadRequest, "invalidFilter", "Участников можно выбрать только условием value eq")
+			}
+			id, err := strconv.ParseInt(condition.Value, 10, 64)
+			if err != nil {
+				return members, nil
+			}
+			removed = append(removed, id)
+		}
+	case path == "":
+		return nil, scimError(http.StatusBadRequest, "noTarget", "Для удаления не указан путь")
+	default:
+		return nil, scimError(http.StatusBadRequest, "mutability", "Атрибут "+path+" группы нельзя удалить")
+	}
+
+	return slices.DeleteFunc(members, func(id int64) bool { return slices.Contains(removed, id) }), nil
+}
+
+func memberIDs(members []SCIMMember) ([]int64, error) {
+	ids := []int64{}
+	for _, member := range members {
+		id, err := strconv.ParseInt(member.Value, 10, 64)
+		if err != nil {
+			return nil, scimError(http.StatusBadRequest, "invalidValue", "Некорректный идентификатор участника "+member.Value)
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+// Проверка роли по условиям фильтра групп
+func matchGroup(role *Role, conditions []scimCondition) bool {
+	for _, condition := range conditions {
+		actual := strings.ToLower(role.Name)
+		if condition.Path == "id" {
+			actual = strconv.FormatInt(role.ID, 10)
+		}
+		expected := strings.ToLower(condition.Value)
+
+		var matched bool
+		switch condition.Operator {
+		case "pr":
+			matched = actual != ""
+		case "eq":
+			matched = actual == expected
+		case "ne":
+			matched = actual != expected
+		case "co":
+			matched = strings.Contains(actual, expected)
+		case "sw":
+			matched = strings.HasPrefix(actual, expected)
+		case "ew":
+			matched = strings.HasSuffix(actual, expected)
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
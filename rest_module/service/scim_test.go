@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []scimCondition
+	}{
+		{filter: "", want: []scimCondition{}},
+		{filter: `userName eq "ivan"`, want: []scimCondition{{Path: "username", Operator: "eq", Value: "ivan"}}},
+		{filter: `userName EQ "Ivan Petrov"`, want: []scimCondition{{Path: "username", Operator: "eq", Value: "Ivan Petrov"}}},
+		{filter: `externalId pr`, want: []scimCondition{{Path: "externalid", Operator: "pr"}}},
+		{filter: `displayName co "\"quoted\" \\ name"`, want: []scimCondition{{Path: "displayname", Operator: "co", Value: `"quoted" \ name`}}},
+		{
+			filter: `active eq true  and  emails.value ew "@example.com"`,
+			want: []scimCondition{
+				{Path: "active", Operator: "eq", Value: "true"},
+				{Path: "emails.value", Operator: "ew", Value: "@example.com"},
+			},
+		},
+		{
+			filter: `meta.lastModified gt "2024-01-01T00:00:00Z" AND id pr`,
+			want: []scimCondition{
+				{Path: "meta.lastmodified", Operator: "gt", Value: "2024-01-01T00:00:00Z"},
+				{Path: "id", Operator: "pr"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		conditions, err := parseSCIMFilter(test.filter)
+		if err != nil {
+			t.Errorf("Фильтр %q: %v", test.filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(conditions, test.want) {
+			t.Errorf("Фильтр %q разобран как %+v, ожидалось %+v", test.filter, conditions, test.want)
+		}
+	}
+}
+
+func TestParseSCIMFilterInvalid(t *testing.T) {
+	for _, filter := range []string{
+		`userName eq "ivan" or userName eq "petr"`,
+		`not (userName eq "ivan")`,
+		`(userName eq "ivan")`,
+		`emails[type eq "work"]`,
+		`userName eq`,
+		`userName`,
+		`userName like "ivan"`,
+		`userName eq "ivan`,
+		`userName eq "\q"`,
+		`userName eq "ivan" and`,
+	} {
+		_, err := parseSCIMFilter(filter)
+		var scimErr *SCIMError
+		if !errors.As(err, &scimErr) {
+			t.Errorf("Фильтр %q: ошибка %v, ожидалась ошибка SCIM", filter, err)
+			continue
+		}
+		if scimErr.HTTPStatus != http.StatusBadRequest || scimErr.SCIMType != "invalidFilter" {
+			t.Errorf("Фильтр %q: статус %d, тип %q", filter, scimErr.HTTPStatus, scimErr.SCIMType)
+		}
+	}
+}
+
+func TestSCIMAttributePath(t *testing.T) {
+	for path, want := range map[string]string{
+		"userName": "username",
+		" urn:ietf:params:scim:schemas:core:2.0:User:name.givenName ": "name.givenname",
+		"urn:ietf:params:scim:schemas:core:2.0:Group:displayName":     "displayname",
+		"emails.value": "emails.value",
+	} {
+		if got := scimAttributePath(path); got != want {
+			t.Errorf("scimAttributePath(%q) = %q, ожидалось %q", path, got, want)
+		}
+	}
+}
+
+func TestSCIMBool(t *testing.T) {
+	for value, want := range map[string]bool{`true`: true, `false`: false, `"True"`: true, `"FALSE"`: false} {
+		flag, err := scimBool(json.RawMessage(value))
+		if err != nil || flag != want {
+			t.Errorf("scimBool(%s) = %v, %v", value, flag, err)
+		}
+	}
+
+	for _, value := range []string{`"yes"`, `1`, `"on"`} {
+		if _, err := scimBool(json.RawMessage(value)); err == nil {
+			t.Errorf("scimBool(%s) без ошибки", value)
+		}
+	}
+}
+
+func TestPrimarySCIMEmail(t *testing.T) {
+	emails := []SCIMEmail{{Value: "work@example.com"}, {Value: "home@example.com", Primary: true}}
+	if email := primarySCIMEmail(emails); email != "home@example.com" {
+		t.Errorf("Основной адрес %q", email)
+	}
+	if email := primarySCIMEmail(emails[:1]); email != "work@example.com" {
+		t.Errorf("Адрес без primary %q", email)
+	}
+	if email := primarySCIMEmail(nil); email != "" {
+		t.Errorf("Адрес из пустого списка %q", email)
+	}
+}
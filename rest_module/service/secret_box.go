@@ -0,0 +1,72 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/utils"
+)
+
+// Шифрование секретов для хранения в БД (AES-256-GCM)
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// Конструктор; ключ выводится из DATA_ENCRYPTION_KEY
+func NewSecretBox() *SecretBox {
+	secret := GetEnv("DATA_ENCRYPTION_KEY", "")
+	if secret == "" {
+		log.Warn("DATA_ENCRYPTION_KEY не задан, используется случайный ключ, зашифрованные данные не переживут перезапуск")
+		secret = randomToken(32)
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &SecretBox{aead: aead}
+}
+
+// Шифрование; результат - base64 от nonce и шифротекста
+func (box *SecretBox) Encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, box.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := box.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Расшифровка значения, полученного от Encrypt
+func (box *SecretBox) Decrypt(ciphertext string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка расшифровки секрета %w", err)
+	}
+
+	size := box.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, fmt.Errorf("Ошибка расшифровки секрета")
+	}
+
+	plaintext, err := box.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка расшифровки секрета %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"rest_module/repository"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Сведения о клиенте, открывающем сессию
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+type SessionManager struct {
+	repository *repository.SessionRepository // репозиторий сессий
+	ttl        time.Duration                 // срок жизни токена обновления
+	dbTimeout  operationTimeout              // ограничение времени операции с БД
+}
+
+// Конструктор сервиса сессий
+func SessionManagerNewInstance(repository *repository.SessionRepository) *SessionManager {
+	manager := SessionManager{}
+	manager.repository = repository
+	manager.ttl = GetEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	manager.dbTimeout = operationTimeoutFromEnv()
+	return &manager
+}
+
+// Открытие сессии; возвращает сессию и токен обновления
+func (manager *SessionManager) Create(ctx context.Context, userID int64, client ClientInfo) (*Session, string, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	return manager.create(ctx, &Session{UserID: userID}, client)
+}
+
+// Открытие сессии клиента OAuth2 с выданными ему областями действия
+func (manager *SessionManager) CreateForClient(ctx context.Context, userID int64, clientID string, scopes []string, client ClientInfo) (*Session, string, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	return manager.create(ctx, &Session{UserID: userID, ClientID: clientID, Scopes: scopes}, client)
+}
+
+func (manager *SessionManager) create(ctx context.Context, session *Session, client ClientInfo) (*Session, string, error) {
+	token := randomToken(32)
+	session.UserAgent = truncate(client.UserAgent, 255)
+	session.IP = truncate(client.IP, 64)
+	session.ExpiresAt = time.Now().Add(manager.ttl)
+
+	id, err := manager.repository.InsertSession(ctx, session, hashToken(token))
+	if err != nil {
+		return nil, "", fmt.Errorf("Ошибка создания сессии %w", err)
+	}
+	session.ID = id
+
+	return session, token, nil
+}
+
+// Обмен токена обновления на новый; старый токен перестает действовать
+func (manager *SessionManager) Rotate(ctx context.Context, refreshToken string) (*Session, string, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	return manager.RotateForClient(ctx, refreshToken, "")
+}
+
+// Обмен токена обновления сессии клиента OAuth2; пустой clientID - собственные сессии сервиса
+func (manager *SessionManager) RotateForClient(ctx context.Context, refreshToken string, clientID string) (*Session, string, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	oldHash := hashToken(refreshToken)
+	session, err := manager.FindByRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if session == nil || session.ClientID != clientID {
+		return nil, "", ErrInvalidToken
+	}
+
+	token := randomToken(32)
+	session.ExpiresAt = time.Now().Add(manager.ttl)
+	rotated, err := manager.repository.RotateSession(ctx, session.ID, oldHash, hashToken(token), session.ExpiresAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("Ошибка обновления сессии %w", err)
+	}
+	if !rotated {
+		return nil, "", ErrInvalidToken
+	}
+
+	return session, token, nil
+}
+
+// Действующая сессия по токену обновления; nil, если токен не действует
+func (manager *SessionManager) FindByRefreshToken(ctx context.Context, refreshToken string) (*Session, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	session, err := manager.repository.GetActiveSessionByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска сессии %w", err)
+	}
+
+	return session, nil
+}
+
+// Проверка, что сессия не отозвана
+func (manager *SessionManager) IsActive(ctx context.Context, sessionID int64) (bool, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	return manager.repository.IsSessionActive(ctx, sessionID)
+}
+
+// Действующие сессии пользователя
+func (manager *SessionManager) UserSessions(ctx context.Context, userID int64) (*[]Session, error) {
+	go log.Println("Чтение сессий пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	sessions, err := manager.repository.GetUserSessions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения сессий %w", err)
+	}
+
+	return sessions, nil
+}
+
+// Завершение одной сессии пользователя
+func (manager *SessionManager) Revoke(ctx context.Context, userID int64, sessionID int64) error {
+	go log.Println("Завершение сессии пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	revoked, err := manager.repository.RevokeSession(ctx, userID, sessionID)
+	if err != nil {
+		return fmt.Errorf("Ошибка завершения сессии %w", err)
+	}
+	if !revoked {
+		return fmt.Errorf("Сессия не найдена")
+	}
+
+	return nil
+}
+
+// Завершение всех сессий пользователя
+func (manager *SessionManager) RevokeAll(ctx context.Context, userID int64) error {
+	go log.Println("Завершение всех сессий пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := manager.repository.RevokeUserSessions(ctx, userID); err != nil {
+		return fmt.Errorf("Ошибка завершения сессий %w", err)
+	}
+
+	return nil
+}
+
+// Хеш секретного токена для хранения в БД
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(value string, max int) string {
+	if len(value) > max {
+		return value[:max]
+	}
+	return value
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+const (
+	accessTokenType = "access"
+	mfaTokenType    = "mfa"
+)
+
+// Пара токенов, выдаваемая при входе
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Утверждения токена
+type TokenClaims struct {
+	Type        string   `json:"typ"`
+	Username    string   `json:"username,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"perms,omitempty"`
+	SessionID   int64    `json:"sid,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	// Администратор, действующий от имени пользователя (RFC 8693), и запись о входе от его имени
+	Actor           *ActorClaims `json:"act,omitempty"`
+	ImpersonationID int64        `json:"imp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Утверждение act: субъект, фактически выполняющий действия
+type ActorClaims struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username,omitempty"`
+}
+
+// Идентификатор пользователя из утверждения sub
+func (claims *TokenClaims) UserID() (int64, error) {
+	return strconv.ParseInt(claims.Subject, 10, 64)
+}
+
+// Токен выпущен клиенту OAuth2 от его собственного имени
+func (claims *TokenClaims) IsClientToken() bool {
+	return claims.ClientID != "" && claims.Subject == claims.ClientID
+}
+
+// Субъект, от имени которого выпущен токен
+func (claims *TokenClaims) Principal() (*Principal, error) {
+	if claims.IsClientToken() {
+		return &Principal{
+			Username:    "client:" + claims.ClientID,
+			Permissions: claims.Permissions,
+			ClientID:    claims.ClientID,
+			Scopes:      strings.Fields(claims.Scope),
+		}, nil
+	}
+
+	id, err := claims.UserID()
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	principal := Principal{
+		UserID:      id,
+		Username:    claims.Username,
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+		SessionID:   claims.SessionID,
+		ClientID:    claims.ClientID,
+		Scopes:      strings.Fields(claims.Scope),
+	}
+
+	if claims.Actor != nil {
+		actorID, err := strconv.ParseInt(claims.Actor.Subject, 10, 64)
+		if err != nil || claims.ImpersonationID == 0 {
+			return nil, ErrInvalidToken
+		}
+		principal.Actor = &Actor{UserID: actorID, Username: claims.Actor.Username, ImpersonationID: claims.ImpersonationID}
+	}
+
+	return &principal, nil
+}
+
+// Утверждения ID-токена OpenID Connect
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Сервис выпуска и проверки JWT; токены подписываются асимметричными ключами из KeyManager
+type TokenService struct {
+	keys      *KeyManager
+	issuer    string
+	accessTTL time.Duration
+	mfaTTL    time.Duration
+	idTTL     time.Duration
+}
+
+// Конструктор сервиса токенов
+func NewTokenService(keys *KeyManager) *TokenService {
+	return &TokenService{
+		keys:      keys,
+		issuer:    strings.TrimSuffix(GetEnv("JWT_ISSUER", "http://localhost:8080"), "/"),
+		accessTTL: GetEnvDuration("JWT_ACCESS_TTL", 15*time.Minute),
+		mfaTTL:    GetEnvDuration("MFA_TOKEN_TTL", 5*time.Minute),
+		idTTL:     GetEnvDuration("OIDC_ID_TOKEN_TTL", time.Hour),
+	}
+}
+
+// Издатель токенов (утверждение iss); для OpenID Connect - базовый URL сервиса
+func (service *TokenService) Issuer() string {
+	return service.issuer
+}
+
+// Выпуск пары токенов для сессии пользователя с его разрешениями
+func (service *TokenService) IssuePair(user *User, permissions []string, sessionID int64, refreshToken string) (*TokenPair, error) {
+	if user == nil {
+		return nil, fmt.Errorf("User can not be null")
+	}
+
+	access, err := service.sign(TokenClaims{
+		Type:        accessTokenType,
+		Username:    user.Username,
+		Permissions: permissions,
+		Roles:       user.Roles,
+		SessionID:   sessionID,
+	}, strconv.FormatInt(user.ID, 10), service.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.pair(access, refreshToken, ""), nil
+}
+
+// Выпуск пары токенов клиенту OAuth2, действующему от имени пользователя
+func (service *TokenService) IssueDelegatedPair(user *User, clientID string, scopes []string, permissions []string,
+	sessionID int64, refreshToken string) (*TokenPair, error) {
+	scope := strings.Join(scopes, " ")
+	access, err := service.sign(TokenClaims{
+		Type:        accessTokenType,
+		Username:    user.Username,
+		Permissions: permissions,
+		Roles:       user.Roles,
+		SessionID:   sessionID,
+		ClientID:    clientID,
+		Scope:       scope,
+	}, strconv.FormatInt(user.ID, 10), service.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.pair(access, refreshToken, scope), nil
+}
+
+// Выпуск токена доступа администратору, действующему от имени пользователя; токена обновления нет
+func (service *TokenService) IssueImpersonationToken(user *User, permissions []string, actor *User, impersonationID int64,
+	ttl time.Duration) (*TokenPair, error) {
+	access, err := service.sign(TokenClaims{
+		Type:            accessTokenType,
+		Username:        user.Username,
+		Permissions:     permissions,
+		Roles:           user.Roles,
+		Actor:           &ActorClaims{Subject: strconv.FormatInt(actor.ID, 10), Username: actor.Username},
+		ImpersonationID: impersonationID,
+	}, strconv.FormatInt(user.ID, 10), ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	pair := service.pair(access, "", "")
+	pair.ExpiresIn = int64(ttl.Seconds())
+	return pair, nil
+}
+
+// Выпуск токена доступа клиенту OAuth2 от его собственного имени, без токена обновления
+func (service *TokenService) IssueClientToken(clientID string, scopes []string) (*TokenPair, error) {
+	scope := strings.Join(scopes, " ")
+	access, err := service.sign(TokenClaims{
+		Type:        accessTokenType,
+		Permissions: scopes,
+		ClientID:    clientID,
+		Scope:       scope,
+	}, clientID, service.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.pair(access, "", scope), nil
+}
+
+// Проверка токена доступа
+func (service *TokenService) ParseAccessToken(token string) (*TokenClaims, error) {
+	return service.parse(token, accessTokenType)
+}
+
+// Выпуск ID-токена для клиента; состав утверждений определяется областями profile и email
+func (service *TokenService) IssueIDToken(user *User, clientID string, nonce string, scopes []string) (string, error) {
+	now := time.Now()
+	claims := IDTokenClaims{
+		Nonce: nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    service.issuer,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(service.idTTL)),
+		},
+	}
+
+	if slices.Contains(scopes, ScopeProfile) {
+		claims.PreferredUsername = user.Username
+	}
+	if slices.Contains(scopes, ScopeEmail) {
+		claims.Email = user.Email
+		claims.EmailVerified = &user.EmailVerified
+	}
+
+	return service.keys.Sign(claims)
+}
+
+// Выпуск токена, подтверждающего первый фактор до ввода второго
+func (service *TokenService) IssueMFAToken(user *User) (string, error) {
+	return service.sign(TokenClaims{Type: mfaTokenType, Username: user.Username}, strconv.FormatInt(user.ID, 10), service.mfaTTL)
+}
+
+// Проверка токена первого фактора
+func (service *TokenService) ParseMFAToken(token string) (*TokenClaims, error) {
+	return service.parse(token, mfaTokenType)
+}
+
+func (service *TokenService) pair(access, refreshToken, scope string) *TokenPair {
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(service.accessTTL.Seconds()),
+		Scope:        scope,
+	}
+}
+
+func (service *TokenService) sign(claims TokenClaims, subject string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        randomToken(16),
+		Issuer:    service.issuer,
+		Subject:   subject,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+
+	return service.keys.Sign(claims)
+}
+
+func (service *TokenService) parse(token string, tokenType string) (*TokenClaims, error) {
+	claims := TokenClaims{}
+	_, err := jwt.ParseWithClaims(token, &claims, service.keys.Keyfunc,
+		jwt.WithValidMethods(service.keys.Methods()),
+		jwt.WithIssuer(service.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Type != tokenType {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// Случайная строка в base64url из n байт
+func randomToken(n int) string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(n))
+}
+
+// n случайных байт из криптографического источника
+func randomBytes(n int) []byte {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return buf
+}
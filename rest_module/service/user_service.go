@@ -3,157 +3,642 @@ package service
 import (
 	"context"
 	"fmt"
+	"net/mail"
 	"rest_module/repository"
+	"strings"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	"golang.org/x/crypto/bcrypt"
-
 	. "rest_module/model"
+	. "rest_module/utils"
 )
 
 type UserManager struct {
-	m           sync.Mutex                 // мьютекс для синхронизации доступа
-	repository  *repository.UserRepository // репозиторий пользователей
+	repository  repository.UserStore                  // хранилище пользователей
+	sessions    *repository.SessionRepository         // репозиторий сессий
+	links       *UserTokenManager                     // одноразовые токены для ссылок из писем
+	notifier    *Notifier                             // письма пользователям
+	policy      *PasswordPolicy                       // парольная политика
+	history     *repository.PasswordHistoryRepository // история паролей
+	hasher      PasswordHasher                        // хеширование паролей
+	dummyHash   string                                // хеш для выравнивания времени проверки несуществующих пользователей
 	integration *IntegrationService
+
+	requireVerifiedEmail bool             // вход только с подтвержденным адресом почты
+	verificationTTL      time.Duration    // срок действия ссылки подтверждения адреса
+	dbTimeout            operationTimeout // ограничение времени операции с хранилищем
+
+	pendingRehashes map[int64]pendingRehash // пересчитанные хеши, ожидающие второго шага входа
+	pendingTTL      time.Duration           // срок ожидания второго шага входа
+	pendingM        sync.Mutex
+}
+
+// Хеш, пересчитанный при проверке пароля и сохраняемый после второго шага входа
+type pendingRehash struct {
+	oldHash   string
+	newHash   string
+	expiresAt time.Time
 }
 
 // Конструктор сервиса
-func UserManagerNewInstance(repository *repository.UserRepository, integration *IntegrationService) *UserManager {
+func UserManagerNewInstance(repository repository.UserStore, sessions *repository.SessionRepository,
+	links *UserTokenManager, notifier *Notifier, policy *PasswordPolicy, history *repository.PasswordHistoryRepository,
+	hasher PasswordHasher, integration *IntegrationService) *UserManager {
 	manager := UserManager{}
 	manager.repository = repository
+	manager.sessions = sessions
+	manager.links = links
+	manager.notifier = notifier
+	manager.policy = policy
+	manager.history = history
+	manager.hasher = hasher
+	manager.dummyHash, _ = hasher.Hash("dummy-password")
 	manager.integration = integration
+	manager.requireVerifiedEmail = GetEnv("REQUIRE_EMAIL_VERIFICATION", "true") == "true"
+	manager.verificationTTL = GetEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	manager.dbTimeout = operationTimeoutFromEnv()
+	manager.pendingRehashes = map[int64]pendingRehash{}
+	manager.pendingTTL = GetEnvDuration("MFA_TOKEN_TTL", 5*time.Minute)
 	return &manager
 }
 
 // Создание пользователя
-func (manager *UserManager) AddUser(Username, Password, Email string) (*User, error) {
+func (manager *UserManager) AddUser(ctx context.Context, Username, Password, Email string) (*User, error) {
 	go log.Println("Создание пользователя")
-	manager.m.Lock()
-	defer manager.m.Unlock()
+	if err := manager.policy.Validate(ctx, &PasswordCandidate{Password: Password, Username: Username, Email: Email}); err != nil {
+		return nil, err
+	}
 
-	if len(Password) < 8 {
-		return nil, fmt.Errorf("Пароль должен содержать не менее 8 символов")
+	return manager.addUser(ctx, &User{Username: Username, Email: Email, Active: true}, Password)
+}
+
+// Пользователь, создаваемый внешним провайдером или системой провижининга
+type ExternalUser struct {
+	Username   string // логин
+	Email      string // адрес почты, подтвержден провайдером
+	Password   string // пусто - пароль не меняется, новому пользователю назначается случайный
+	ExternalID string // идентификатор в системе провижининга
+	Active     bool   // учетная запись включена
+}
+
+// Создание пользователя внешнего провайдера; без пароля вход возможен только через провайдера
+func (manager *UserManager) ProvisionExternalUser(ctx context.Context, external *ExternalUser) (*User, error) {
+	go log.Println("Создание пользователя внешнего провайдера")
+	password := external.Password
+	if password == "" {
+		password = randomToken(32)
+	} else if err := manager.policy.Validate(ctx, &PasswordCandidate{Password: password, Username: external.Username, Email: external.Email}); err != nil {
+		return nil, err
 	}
 
-	manager.repository.Db.BeginTransaction()
-	exist, _ := manager.repository.GetUserByName(Username)
-	if exist != nil {
-		manager.repository.Db.RollbackTransaction()
-		return nil, fmt.Errorf("Пользователь с таким логином уже есть")
+	user := User{Username: external.Username, Email: external.Email, EmailVerified: true, Active: external.Active, ExternalID: external.ExternalID}
+	return manager.addUser(ctx, &user, password)
+}
+
+// Создание приглашенного пользователя: учетная запись отключена до принятия приглашения,
+// пароль случайный и заменяется паролем, выбранным при принятии
+func (manager *UserManager) AddInvitedUser(ctx context.Context, Username, Email string) (*User, error) {
+	go log.Println("Создание приглашенного пользователя")
+	return manager.addUser(ctx, &User{Username: Username, Email: Email}, randomToken(32))
+}
+
+// Принятие приглашения: установка пароля, подтверждение адреса почты и включение учетной записи
+func (manager *UserManager) AcceptInvitation(ctx context.Context, id int64, Password string) (*User, error) {
+	go log.Println("Принятие приглашения пользователем")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := manager.SetPassword(ctx, id, Password); err != nil {
+		return nil, err
 	}
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.DefaultCost)
-	user := User{Username: Username, Email: Email, Password: string(hashedPassword)}
+	if err := manager.repository.ActivateUser(ctx, id); err != nil {
+		return nil, fmt.Errorf("Ошибка включения учетной записи %w", err)
+	}
 
-	var err error
-	user.ID, err = manager.repository.InsertUser(&user)
+	user, err := manager.repository.GetUserByID(ctx, id)
 	if err != nil {
-		manager.repository.Db.RollbackTransaction()
-		return nil, fmt.Errorf("Ошибка добавления пользователя %s", err.Error())
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
 	}
-	manager.repository.Db.CommitTransaction()
-	manager.exportUserSnapshot(&user)
-	return &user, nil
+	if user == nil {
+		return nil, fmt.Errorf("Пользователь с таким идентификатором не найден")
+	}
+	manager.exportUserSnapshot(user)
+	return user, nil
+}
+
+// Обновление пользователя системой провижининга; отключение и смена пароля завершают сессии
+func (manager *UserManager) UpdateExternalUser(ctx context.Context, id int64, external *ExternalUser) (*User, error) {
+	go log.Println("Обновление пользователя внешнего провайдера")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+
+	if err := validateEmail(external.Email); err != nil {
+		return nil, err
+	}
+
+	// Пользователь заблокирован до конца транзакции, параллельные изменения выполняются по очереди;
+	// занятый логин или адрес почты отклоняют уникальные индексы хранилища
+	err := manager.repository.WithTx(ctx, func(ctx context.Context) error {
+		current, err := manager.repository.LockUserByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Ошибка поиска пользователя %w", err)
+		}
+		if current == nil {
+			return fmt.Errorf("Пользователь с таким идентификатором не найден")
+		}
+
+		passwordChanged := external.Password != "" && !manager.hasher.Verify(external.Password, current.Password)
+		if passwordChanged {
+			candidate := PasswordCandidate{Password: external.Password, Username: external.Username, Email: external.Email, UserID: id, CurrentHash: current.Password}
+			if err := manager.policy.Validate(ctx, &candidate); err != nil {
+				return err
+			}
+
+			hashedPassword, err := manager.hasher.Hash(external.Password)
+			if err != nil {
+				return fmt.Errorf("Ошибка хеширования пароля %w", err)
+			}
+			if err := manager.repository.UpdatePassword(ctx, id, hashedPassword); err != nil {
+				return fmt.Errorf("Ошибка обновления пароля %w", err)
+			}
+			if err := manager.recordPasswordHistory(ctx, id, hashedPassword); err != nil {
+				return err
+			}
+		}
+
+		user := User{Username: external.Username, Email: external.Email, EmailVerified: true, Active: external.Active, ExternalID: external.ExternalID}
+		if err := manager.repository.UpdateProvisioning(ctx, id, &user); err != nil {
+			if repository.IsUserConflict(err) {
+				return err
+			}
+			return fmt.Errorf("Ошибка обновления пользователя %w", err)
+		}
+
+		if passwordChanged || (current.Active && !external.Active) {
+			if err := manager.sessions.RevokeUserSessions(ctx, id); err != nil {
+				return fmt.Errorf("Ошибка завершения сессий пользователя %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := manager.repository.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
+	if updated == nil {
+		return nil, fmt.Errorf("Пользователь с таким идентификатором не найден")
+	}
+	manager.exportUserSnapshot(updated)
+	return updated, nil
+}
+
+// Сохранение пользователя с ролью по умолчанию; включенным пользователям с неподтвержденным адресом
+// отправляется письмо для подтверждения
+func (manager *UserManager) addUser(ctx context.Context, user *User, Password string) (*User, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+
+	if err := validateEmail(user.Email); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := manager.hasher.Hash(Password)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка хеширования пароля %w", err)
+	}
+
+	// Пользователь и история пароля сохраняются вместе; занятый логин или адрес почты
+	// отклоняют уникальные индексы хранилища, в том числе при вставке с другой реплики
+	err = manager.repository.WithTx(ctx, func(ctx context.Context) error {
+		// Новые пользователи получают роль по умолчанию
+		user.Password = hashedPassword
+		user.Roles = []string{RoleUser}
+		id, err := manager.repository.InsertUser(ctx, user)
+		if err != nil {
+			if repository.IsUserConflict(err) {
+				return err
+			}
+			return fmt.Errorf("Ошибка добавления пользователя %w", err)
+		}
+		user.ID = id
+
+		return manager.recordPasswordHistory(ctx, user.ID, user.Password)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Active && !user.EmailVerified {
+		manager.sendEmailVerification(user)
+	}
+	manager.exportUserSnapshot(user)
+	return user, nil
 }
 
 // Обновление пользователя
-func (manager *UserManager) UpdateUser(id int64, Username, Password, Email string) (*User, error) {
+func (manager *UserManager) UpdateUser(ctx context.Context, id int64, Username, Password, Email string) (*User, error) {
 	go log.Println("Обновление пользователя")
-	manager.m.Lock()
-	defer manager.m.Unlock()
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
 
-	if len(Password) < 8 {
-		return nil, fmt.Errorf("Пароль должен содержать не менее 8 символов")
+	if err := validateEmail(Email); err != nil {
+		return nil, err
 	}
 
-	manager.repository.Db.BeginTransaction()
-	exist, _ := manager.repository.GetUserByName(Username)
-	if exist != nil {
-		manager.repository.Db.RollbackTransaction()
-		return nil, fmt.Errorf("Пользователь с таким логином уже есть")
-	}
+	var user User
+	var emailChanged bool
+	// Пользователь заблокирован до конца транзакции, параллельные изменения выполняются по очереди;
+	// занятый логин или адрес почты отклоняют уникальные индексы хранилища
+	err := manager.repository.WithTx(ctx, func(ctx context.Context) error {
+		current, err := manager.repository.LockUserByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Ошибка поиска пользователя %w", err)
+		}
+		if current == nil {
+			return fmt.Errorf("Пользователь с таким идентификатором не найден")
+		}
+
+		// Новый адрес почты требует повторного подтверждения
+		emailChanged = !strings.EqualFold(current.Email, Email)
+
+		// Пустой пароль оставляет текущий; политика проверяет только новый пароль, текущий можно передать повторно
+		hashedPassword := current.Password
+		passwordChanged := Password != "" && !manager.hasher.Verify(Password, hashedPassword)
+		if passwordChanged {
+			candidate := PasswordCandidate{Password: Password, Username: Username, Email: Email, UserID: id, CurrentHash: current.Password}
+			if err := manager.policy.Validate(ctx, &candidate); err != nil {
+				return err
+			}
+
+			var err error
+			if hashedPassword, err = manager.hasher.Hash(Password); err != nil {
+				return fmt.Errorf("Ошибка хеширования пароля %w", err)
+			}
+		}
+		changes := User{Username: Username, Email: Email, EmailVerified: current.EmailVerified && !emailChanged}
+		if err := manager.repository.UpdateUser(ctx, id, &changes, hashedPassword); err != nil {
+			if repository.IsUserConflict(err) {
+				return err
+			}
+			return fmt.Errorf("Ошибка обновления пользователя %w", err)
+		}
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.DefaultCost)
-	user := User{Username: Username, Email: Email, Password: string(hashedPassword)}
+		// В ответе сохраненная запись со всеми полями, включая не изменяемые этим методом
+		updated, err := manager.repository.GetUserByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Ошибка поиска пользователя %w", err)
+		}
+		user = *updated
+
+		// Смена пароля завершает все сессии пользователя
+		if passwordChanged {
+			if err := manager.recordPasswordHistory(ctx, id, user.Password); err != nil {
+				return err
+			}
+			if err := manager.sessions.RevokeUserSessions(ctx, id); err != nil {
+				return fmt.Errorf("Ошибка завершения сессий пользователя %w", err)
+			}
+		}
 
-	var err error
-	err = manager.repository.UpdateUser(id, &user, string(hashedPassword))
+		return nil
+	})
 	if err != nil {
-		manager.repository.Db.RollbackTransaction()
-		return nil, fmt.Errorf("Ошибка обновления пользователя %s", err.Error())
+		return nil, err
+	}
+
+	if emailChanged {
+		manager.sendEmailVerification(&user)
 	}
-	manager.repository.Db.CommitTransaction()
 	manager.exportUserSnapshot(&user)
 	return &user, nil
 }
 
+// Проверка логина и пароля пользователя
+func (manager *UserManager) Authenticate(ctx context.Context, Username, Password string) (*User, error) {
+	go log.Println("Проверка учетных данных пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+
+	user, err := manager.repository.GetUserByName(ctx, Username)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
+
+	if user == nil {
+		// Сравнение с фиктивным хешем, чтобы время ответа не выдавало существование логина
+		manager.hasher.Verify(Password, manager.dummyHash)
+		return nil, ErrInvalidCredentials
+	}
+
+	if !manager.hasher.Verify(Password, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err = manager.loginAllowed(user); err != nil {
+		return nil, err
+	}
+
+	// Хеш прежнего алгоритма или параметров пересчитывается, пока пароль известен, но сохраняется
+	// только после всех проверок входа: при двухфакторной аутентификации - после второго шага
+	if manager.hasher.NeedsRehash(user.Password) {
+		hashedPassword, err := manager.hasher.Hash(Password)
+		if err != nil {
+			log.Warnf("Хеш пароля пользователя %d не пересчитан: %v", user.ID, err)
+		} else if user.MFAEnabled {
+			manager.deferRehash(user, hashedPassword)
+		} else {
+			manager.saveRehash(ctx, user, user.Password, hashedPassword)
+		}
+	}
+
+	return user, nil
+}
+
+// Сохранение хеша, пересчитанного при проверке пароля, после второго шага входа
+func (manager *UserManager) CompleteRehash(ctx context.Context, user *User) {
+	manager.pendingM.Lock()
+	pending, ok := manager.pendingRehashes[user.ID]
+	delete(manager.pendingRehashes, user.ID)
+	manager.pendingM.Unlock()
+
+	if ok && time.Now().Before(pending.expiresAt) {
+		ctx, cancel := manager.dbTimeout.apply(ctx)
+		defer cancel()
+		manager.saveRehash(ctx, user, pending.oldHash, pending.newHash)
+	}
+}
+
+// Хеш ждет второго шага входа в памяти; если второй шаг выполнит другой экземпляр сервиса, хеш пересчитается при следующем входе
+func (manager *UserManager) deferRehash(user *User, hashedPassword string) {
+	manager.pendingM.Lock()
+	defer manager.pendingM.Unlock()
+	for id, pending := range manager.pendingRehashes {
+		if time.Now().After(pending.expiresAt) {
+			delete(manager.pendingRehashes, id)
+		}
+	}
+	manager.pendingRehashes[user.ID] = pendingRehash{oldHash: user.Password, newHash: hashedPassword, expiresAt: time.Now().Add(manager.pendingTTL)}
+}
+
+// Замена хеша пароля пересчитанным; ошибка не мешает входу
+func (manager *UserManager) saveRehash(ctx context.Context, user *User, oldHash, newHash string) {
+	// Хеш не заменяется, если пароль успели сменить
+	if err := manager.repository.RehashPassword(ctx, user.ID, oldHash, newHash); err != nil {
+		log.Warnf("Хеш пароля пользователя %d не пересчитан: %v", user.ID, err)
+		return
+	}
+	if user.Password == oldHash {
+		user.Password = newHash
+	}
+}
+
+// Проверка, что пользователь с подтвержденными учетными данными может войти
+func (manager *UserManager) loginAllowed(user *User) error {
+	if !user.Active {
+		return ErrUserDisabled
+	}
+
+	if manager.requireVerifiedEmail && !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+
+	return nil
+}
+
 // Поиск пользователя по идентификатору
-func (manager *UserManager) FindUserById(id int64) (*User, error) {
+func (manager *UserManager) FindUserById(ctx context.Context, id int64) (*User, error) {
 	go log.Println("Поиск пользователя по идентификатору")
-	manager.m.Lock()
-	defer manager.m.Unlock()
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
 
-	manager.repository.Db.BeginTransaction()
-	user, _ := manager.repository.GetUserByID(id)
+	user, err := manager.repository.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
 	if user == nil {
-		manager.repository.Db.RollbackTransaction()
 		return nil, fmt.Errorf("Пользователь с таким идентификатором не найден")
 	}
-	manager.repository.Db.CommitTransaction()
 
 	return user, nil
 }
 
 // Поиск пользователя по имени
-func (manager *UserManager) FindUserByName(Username string) (*User, error) {
+func (manager *UserManager) FindUserByName(ctx context.Context, Username string) (*User, error) {
 	go log.Println("Поиск пользователя по имени")
-	manager.m.Lock()
-	defer manager.m.Unlock()
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
 
-	manager.repository.Db.BeginTransaction()
-	user, _ := manager.repository.GetUserByName(Username)
+	user, err := manager.repository.GetUserByName(ctx, Username)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
 	if user == nil {
-		manager.repository.Db.RollbackTransaction()
 		return nil, fmt.Errorf("Пользователь с таким логином не найден")
 	}
-	manager.repository.Db.CommitTransaction()
 
 	return user, nil
 }
 
+// Поиск пользователя по адресу почты
+func (manager *UserManager) FindUserByEmail(ctx context.Context, Email string) (*User, error) {
+	go log.Println("Поиск пользователя по адресу почты")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+
+	user, err := manager.repository.GetUserByEmail(ctx, Email)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("Пользователь с таким адресом почты не найден")
+	}
+
+	return user, nil
+}
+
+// Проверка нового пароля пользователя по парольной политике
+func (manager *UserManager) ValidatePassword(ctx context.Context, user *User, Password string) error {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+
+	return manager.policy.Validate(ctx, &PasswordCandidate{
+		Password:    Password,
+		Username:    user.Username,
+		Email:       user.Email,
+		UserID:      user.ID,
+		CurrentHash: user.Password,
+	})
+}
+
+// Установка нового пароля; все сессии пользователя завершаются
+func (manager *UserManager) SetPassword(ctx context.Context, id int64, Password string) error {
+	go log.Println("Установка пароля пользователя")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+
+	// Пользователь заблокирован до конца транзакции: параллельная смена пароля не обойдет проверку истории
+	return manager.repository.WithTx(ctx, func(ctx context.Context) error {
+		user, err := manager.repository.LockUserByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Ошибка поиска пользователя %w", err)
+		}
+		if user == nil {
+			return fmt.Errorf("Пользователь с таким идентификатором не найден")
+		}
+
+		if err := manager.ValidatePassword(ctx, user, Password); err != nil {
+			return err
+		}
+
+		hashedPassword, err := manager.hasher.Hash(Password)
+		if err != nil {
+			return fmt.Errorf("Ошибка хеширования пароля %w", err)
+		}
+
+		if err := manager.repository.UpdatePassword(ctx, id, hashedPassword); err != nil {
+			return fmt.Errorf("Ошибка обновления пароля %w", err)
+		}
+
+		if err := manager.recordPasswordHistory(ctx, id, hashedPassword); err != nil {
+			return err
+		}
+
+		if err := manager.sessions.RevokeUserSessions(ctx, id); err != nil {
+			return fmt.Errorf("Ошибка завершения сессий пользователя %w", err)
+		}
+
+		return nil
+	})
+}
+
 // Поиск пользователей
-func (manager *UserManager) FindAllUsers() (*[]User, error) {
+func (manager *UserManager) FindAllUsers(ctx context.Context) (*[]User, error) {
 	go log.Println("Чтение пользователей")
-	manager.m.Lock()
-	defer manager.m.Unlock()
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
 
-	manager.repository.Db.BeginTransaction()
-	users, _ := manager.repository.GetAllUsers()
+	users, err := manager.repository.GetAllUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения пользователей %w", err)
+	}
 	if users == nil {
-		manager.repository.Db.RollbackTransaction()
 		return nil, fmt.Errorf("Пользователи не найдены")
 	}
-	manager.repository.Db.CommitTransaction()
 
 	return users, nil
 }
 
 // Удаление пользователя
-func (manager *UserManager) DeleteUserById(id int64) error {
+func (manager *UserManager) DeleteUserById(ctx context.Context, id int64) error {
 	go log.Println("Удаление пользователя")
-	manager.m.Lock()
-	defer manager.m.Unlock()
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
 
-	manager.repository.Db.BeginTransaction()
-	err := manager.repository.DeleteUserById(id)
+	if err := manager.repository.DeleteUserById(ctx, id); err != nil {
+		return fmt.Errorf("Ошибка удаления пользователя %w", err)
+	}
+
+	return nil
+}
+
+// Подтверждение адреса почты по токену из письма
+func (manager *UserManager) VerifyEmail(ctx context.Context, token string) (*User, error) {
+	go log.Println("Подтверждение адреса почты")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	id, err := manager.links.Consume(ctx, token, TokenPurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = manager.repository.SetEmailVerified(ctx, id); err != nil {
+		return nil, fmt.Errorf("Ошибка подтверждения адреса почты %w", err)
+	}
+
+	user, err := manager.repository.GetUserByID(ctx, id)
 	if err != nil {
-		manager.repository.Db.RollbackTransaction()
-		return fmt.Errorf("Ошибка удаления пользователя %s", err.Error())
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("Пользователь с таким идентификатором не найден")
+	}
+
+	return user, nil
+}
+
+// Отметка о подтверждении адреса почты, подтвержденного другим способом
+func (manager *UserManager) confirmEmail(ctx context.Context, user *User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	if err := manager.repository.SetEmailVerified(ctx, user.ID); err != nil {
+		return fmt.Errorf("Ошибка подтверждения адреса почты %w", err)
+	}
+	user.EmailVerified = true
+
+	return nil
+}
+
+// Повторная отправка письма для подтверждения адреса почты
+func (manager *UserManager) ResendEmailVerification(ctx context.Context, id int64) error {
+	go log.Println("Повторная отправка подтверждения адреса почты")
+	user, err := manager.FindUserById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if user.EmailVerified {
+		return fmt.Errorf("Адрес почты уже подтвержден")
+	}
+
+	manager.sendEmailVerification(user)
+	return nil
+}
+
+// Выпуск токена подтверждения и отправка письма в фоне
+func (manager *UserManager) sendEmailVerification(user *User) {
+	userCopy := *user
+	go func(u User) {
+		token, err := manager.links.Issue(context.Background(), u.ID, TokenPurposeEmailVerification, manager.verificationTTL)
+		if err != nil {
+			log.Println("SendEmailVerification", err)
+			return
+		}
+
+		if err = manager.notifier.SendEmailVerification(&u, token); err != nil {
+			log.Println("SendEmailVerification", err)
+		}
+	}(userCopy)
+}
+
+// Сохранение хеша нового пароля в истории
+func (manager *UserManager) recordPasswordHistory(ctx context.Context, id int64, hash string) error {
+	keep := manager.policy.HistorySize()
+	if keep == 0 {
+		return nil
+	}
+
+	if err := manager.history.InsertPasswordHash(ctx, id, hash, keep); err != nil {
+		return fmt.Errorf("Ошибка сохранения истории паролей %w", err)
+	}
+
+	return nil
+}
+
+// Проверка формата адреса почты
+func validateEmail(Email string) error {
+	address, err := mail.ParseAddress(Email)
+	if err != nil || address.Address != Email {
+		return fmt.Errorf("Некорректный адрес почты")
 	}
-	manager.repository.Db.CommitTransaction()
 
 	return nil
 }
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	. "rest_module/model"
+	"rest_module/repository"
+)
+
+// Хранилище в памяти не ведет второй фактор; пользователи этого хранилища считаются подключившими его
+type mfaUserStore struct {
+	*repository.MemoryUserStore
+}
+
+func (store mfaUserStore) GetUserByName(ctx context.Context, name string) (*User, error) {
+	user, err := store.MemoryUserStore.GetUserByName(ctx, name)
+	if user != nil {
+		user.MFAEnabled = true
+	}
+	return user, err
+}
+
+// Хеш прежнего алгоритма заменяется только после всех проверок входа
+func TestAuthenticateRehash(t *testing.T) {
+	legacy := &Argon2idHasher{Memory: 64, Time: 1, Threads: 1, KeyLen: 32, SaltLen: 16}
+	hasher := NewUpgradingPasswordHasher(&BcryptHasher{Cost: bcrypt.MinCost}, legacy)
+
+	tests := []struct {
+		name         string
+		user         User
+		mfa          bool // подключен второй фактор
+		wantErr      error
+		wantRehashed bool // хеш заменен после проверки пароля
+		wantComplete bool // хеш заменен после второго шага входа
+	}{
+		{name: "вход без второго фактора", user: User{Active: true, EmailVerified: true}, wantRehashed: true, wantComplete: true},
+		{name: "отключенная учетная запись", user: User{EmailVerified: true}, wantErr: ErrUserDisabled},
+		{name: "адрес почты не подтвержден", user: User{Active: true}, wantErr: ErrEmailNotVerified},
+		{name: "вход со вторым фактором", user: User{Active: true, EmailVerified: true}, mfa: true, wantComplete: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("REQUIRE_EMAIL_VERIFICATION", "true")
+			ctx := context.Background()
+			store := repository.MemoryUserStoreNewInstance()
+			var users repository.UserStore = store
+			if test.mfa {
+				users = mfaUserStore{store}
+			}
+			manager := UserManagerNewInstance(users, nil, nil, nil, PasswordPolicyFromEnv(nil, hasher), nil, hasher, nil)
+
+			legacyHash, _ := legacy.Hash("Secret-password1")
+			user := test.user
+			user.Username, user.Email, user.Password = "ivan", "ivan@example.com", legacyHash
+			id, err := store.InsertUser(ctx, &user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rehashed := func() bool {
+				stored, _ := store.GetUserByID(ctx, id)
+				return stored.Password != legacyHash && hasher.Verify("Secret-password1", stored.Password) && !hasher.NeedsRehash(stored.Password)
+			}
+
+			authenticated, err := manager.Authenticate(ctx, "ivan", "Secret-password1")
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Ошибка %v, ожидалась %v", err, test.wantErr)
+			}
+			if rehashed() != test.wantRehashed {
+				t.Fatalf("Хеш после проверки пароля заменен: %v, ожидалось %v", rehashed(), test.wantRehashed)
+			}
+
+			if authenticated != nil {
+				manager.CompleteRehash(ctx, authenticated)
+			}
+			if rehashed() != test.wantComplete {
+				t.Errorf("Хеш после второго шага заменен: %v, ожидалось %v", rehashed(), test.wantComplete)
+			}
+		})
+	}
+}
+
+// Обновление без пароля сохраняет текущий пароль и возвращает сохраненную запись целиком
+func TestUpdateUserKeepsPassword(t *testing.T) {
+	ctx := context.Background()
+	hasher := &BcryptHasher{Cost: bcrypt.MinCost}
+	store := repository.MemoryUserStoreNewInstance()
+	manager := UserManagerNewInstance(store, nil, nil, nil, PasswordPolicyFromEnv(nil, hasher), nil, hasher, nil)
+
+	hash, _ := hasher.Hash("Secret-password1")
+	id, err := store.InsertUser(ctx, &User{Username: "ivan", Email: "ivan@example.com", Password: hash, EmailVerified: true,
+		Active: true, ExternalID: "ext-ivan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"", "Secret-password1"} {
+		updated, err := manager.UpdateUser(ctx, id, "ivan.petrov", password, "ivan@example.com")
+		if err != nil {
+			t.Fatalf("Пароль %q: %v", password, err)
+		}
+		if updated.Username != "ivan.petrov" || !updated.Active || !updated.EmailVerified || updated.ExternalID != "ext-ivan" ||
+			updated.CreatedAt.IsZero() {
+			t.Errorf("Пароль %q: возвращена запись %+v", password, updated)
+		}
+
+		stored, _ := store.GetUserByID(ctx, id)
+		if stored.Password != hash {
+			t.Errorf("Пароль %q: хеш пароля изменен", password)
+		}
+	}
+}
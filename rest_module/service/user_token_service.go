@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"rest_module/repository"
+	"time"
+)
+
+// Назначения одноразовых токенов
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeMagicLink         = "magic_link"
+	TokenPurposeInvitation        = "invitation"
+)
+
+// Сервис одноразовых токенов, хранимых в виде хеша
+type UserTokenManager struct {
+	repository *repository.UserTokenRepository // репозиторий токенов
+	dbTimeout  operationTimeout                // ограничение времени операции с БД
+}
+
+// Конструктор сервиса одноразовых токенов
+func UserTokenManagerNewInstance(repository *repository.UserTokenRepository) *UserTokenManager {
+	manager := UserTokenManager{}
+	manager.repository = repository
+	manager.dbTimeout = operationTimeoutFromEnv()
+	return &manager
+}
+
+// Выпуск токена; ранее выпущенные токены с тем же назначением перестают действовать
+func (manager *UserTokenManager) Issue(ctx context.Context, userID int64, purpose string, ttl time.Duration) (string, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	if err := manager.repository.InvalidateUserTokens(ctx, userID, purpose); err != nil {
+		return "", fmt.Errorf("Ошибка выпуска токена %w", err)
+	}
+
+	token := randomToken(32)
+	if err := manager.repository.InsertToken(ctx, userID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", fmt.Errorf("Ошибка выпуска токена %w", err)
+	}
+
+	return token, nil
+}
+
+// Владелец действующего токена без его погашения
+func (manager *UserTokenManager) Lookup(ctx context.Context, token, purpose string) (int64, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	userID, err := manager.repository.GetTokenUserID(ctx, purpose, hashToken(token))
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка проверки токена %w", err)
+	}
+	if userID == 0 {
+		return 0, ErrInvalidToken
+	}
+
+	return userID, nil
+}
+
+// Погашение токена; повторное использование возвращает ErrInvalidToken
+func (manager *UserTokenManager) Consume(ctx context.Context, token, purpose string) (int64, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	userID, err := manager.repository.ConsumeToken(ctx, purpose, hashToken(token))
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка проверки токена %w", err)
+	}
+	if userID == 0 {
+		return 0, ErrInvalidToken
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Алгоритмы COSE открытых ключей, принимаемые при регистрации
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// Типы ключей и кривые COSE (RFC 9053)
+const (
+	coseKeyTypeOKP   = 1
+	coseKeyTypeEC2   = 2
+	coseKeyTypeRSA   = 3
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// Флаги данных аутентификатора
+const (
+	authenticatorUserPresent  = 0x01
+	authenticatorUserVerified = 0x04
+	authenticatorAttested     = 0x40
+	authenticatorExtensions   = 0x80
+)
+
+// Требование проверки пользователя (PIN, биометрия) аутентификатором
+const (
+	userVerificationRequired  = "required"
+	userVerificationPreferred = "preferred"
+)
+
+// Размер случайного идентификатора пользователя для аутентификатора; спецификация допускает до 64 байт
+const webAuthnUserHandleSize = 32
+
+// Двоичные данные WebAuthn, в JSON передаются в base64url
+type Base64URL []byte
+
+func (value Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(value))
+}
+
+func (value *Base64URL) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return fmt.Errorf("Некорректное значение base64url %w", err)
+	}
+
+	*value = decoded
+	return nil
+}
+
+// Проверяющая сторона (сервис)
+type WebAuthnRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Учетная запись, для которой создается ключ доступа
+type WebAuthnUserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// Ссылка на зарегистрированный ключ доступа
+type WebAuthnCredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// Параметры navigator.credentials.create() в формате PublicKeyCredential.parseCreationOptionsFromJSON
+type PublicKeyCredentialCreationOptions struct {
+	RP                     WebAuthnRelyingParty           `json:"rp"`
+	User                   WebAuthnUserEntity             `json:"user"`
+	Challenge              string                         `json:"challenge"`
+	PubKeyCredParams       []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                          `json:"timeout"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                         `json:"attestation"`
+}
+
+// Параметры navigator.credentials.get() в формате PublicKeyCredential.parseRequestOptionsFromJSON
+type PublicKeyCredentialRequestOptions struct {
+	Challenge        string                         `json:"challenge"`
+	Timeout          int64                          `json:"timeout"`
+	RPID             string                         `json:"rpId"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// Результат navigator.credentials.create() в формате PublicKeyCredential.toJSON()
+type WebAuthnAttestation struct {
+	ID       string                      `json:"id"`
+	RawID    Base64URL                   `json:"rawId"`
+	Type     string                      `json:"type"`
+	Response WebAuthnAttestationResponse `json:"response"`
+}
+
+type WebAuthnAttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+	Transports        []string  `json:"transports"`
+}
+
+// Результат navigator.credentials.get() в формате PublicKeyCredential.toJSON()
+type WebAuthnAssertion struct {
+	ID       string                    `json:"id"`
+	RawID    Base64URL                 `json:"rawId"`
+	Type     string                    `json:"type"`
+	Response WebAuthnAssertionResponse `json:"response"`
+}
+
+type WebAuthnAssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle"`
+}
+
+// Данные клиента, подписанные вместе с данными аутентификатора
+type webAuthnClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Разбор данных клиента и проверка типа церемонии и источника запроса
+func parseClientData(raw []byte, ceremony string, origins []string) (*webAuthnClientData, error) {
+	var clientData webAuthnClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return nil, fmt.Errorf("Некорректные данные клиента WebAuthn %w", err)
+	}
+	if clientData.Type != ceremony {
+		return nil, fmt.Errorf("Неверный тип церемонии WebAuthn %s", clientData.Type)
+	}
+	// Источник, подставленный браузером, защищает от фишинга: ключ не сработает на чужом сайте
+	if !slices.Contains(origins, clientData.Origin) || clientData.CrossOrigin {
+		return nil, fmt.Errorf("Недопустимый источник запроса WebAuthn %s", clientData.Origin)
+	}
+	if clientData.Challenge == "" {
+		return nil, fmt.Errorf("Данные клиента WebAuthn не содержат вызова")
+	}
+
+	return &clientData, nil
+}
+
+// Данные аутентификатора (WebAuthn §6.1)
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte // только при регистрации
+	credentialID []byte // только при регистрации
+	publicKey    []byte // открытый ключ COSE, только при регистрации
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("Данные аутентификатора слишком короткие")
+	}
+
+	result := authenticatorData{rpIDHash: data[:32], flags: data[32], signCount: binary.BigEndian.Uint32(data[33:37])}
+	rest := data[37:]
+
+	if result.flags&authenticatorAttested != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("Данные аутентификатора не содержат ключа")
+		}
+		result.aaguid = rest[:16]
+		length := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if length == 0 || length > 1023 || len(rest) < length {
+			return nil, fmt.Errorf("Некорректный идентификатор ключа в данных аутентификатора")
+		}
+		result.credentialID = rest[:length]
+		rest = rest[length:]
+
+		var key cbor.RawMessage
+		remaining, err := cbor.UnmarshalFirst(rest, &key)
+		if err != nil {
+			return nil, fmt.Errorf("Некорректный открытый ключ в данных аутентификатора %w", err)
+		}
+		result.publicKey = key
+		rest = remaining
+	}
+
+	if result.flags&authenticatorExtensions != 0 {
+		var extensions map[string]cbor.RawMessage
+		remaining, err := cbor.UnmarshalFirst(rest, &extensions)
+		if err != nil {
+			return nil, fmt.Errorf("Некорректные расширения в данных аутентификатора %w", err)
+		}
+		rest = remaining
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("Лишние байты в данных аутентификатора")
+	}
+
+	return &result, nil
+}
+
+// Проверка хеша идентификатора проверяющей стороны и присутствия пользователя
+func (data *authenticatorData) verify(rpID string, requireUserVerification bool) error {
+	hash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(data.rpIDHash, hash[:]) {
+		return fmt.Errorf("Ключ доступа создан для другого сервиса")
+	}
+	if data.flags&authenticatorUserPresent == 0 {
+		return fmt.Errorf("Аутентификатор не подтвердил присутствие пользователя")
+	}
+	if requireUserVerification && data.flags&authenticatorUserVerified == 0 {
+		return fmt.Errorf("Аутентификатор не выполнил проверку пользователя")
+	}
+
+	return nil
+}
+
+// Идентификатор модели аутентификатора в виде UUID
+func (data *authenticatorData) aaguidString() string {
+	id := data.aaguid
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// Разбор объекта аттестации. Сервис запрашивает аттестацию "none" и не проверяет модель
+// аутентификатора, поэтому принимается только формат none с пустым утверждением
+func parseAttestationObject(raw []byte) (*authenticatorData, error) {
+	var object struct {
+		Format    string                     `cbor:"fmt"`
+		Statement map[string]cbor.RawMessage `cbor:"attStmt"`
+		AuthData  []byte                     `cbor:"authData"`
+	}
+	if err := cbor.Unmarshal(raw, &object); err != nil {
+		return nil, fmt.Errorf("Некорректный объект аттестации %w", err)
+	}
+	if object.Format != "none" || len(object.Statement) != 0 {
+		return nil, fmt.Errorf("Неподдерживаемый формат аттестации %s", object.Format)
+	}
+
+	data, err := parseAuthenticatorData(object.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if data.credentialID == nil {
+		return nil, fmt.Errorf("Объект аттестации не содержит ключа")
+	}
+
+	return data, nil
+}
+
+// Открытый ключ COSE (RFC 9052 §7); значения параметров -1..-3 зависят от типа ключа
+type coseKey struct {
+	Type      int64           `cbor:"1,keyasint"`
+	Algorithm int64           `cbor:"3,keyasint"`
+	Param1    cbor.RawMessage `cbor:"-1,keyasint"` // crv для EC2 и OKP, n для RSA
+	Param2    []byte          `cbor:"-2,keyasint"` // x для EC2 и OKP, e для RSA
+	Param3    []byte          `cbor:"-3,keyasint"` // y для EC2
+}
+
+// Разбор открытого ключа COSE поддерживаемого алгоритма
+func parseCOSEKey(raw []byte) (crypto.PublicKey, error) {
+	var key coseKey
+	if err := cbor.Unmarshal(raw, &key); err != nil {
+		return nil, fmt.Errorf("Некорректный открытый ключ %w", err)
+	}
+
+	switch {
+	case key.Type == coseKeyTypeEC2 && key.Algorithm == coseAlgES256:
+		var curve int64
+		if err := cbor.Unmarshal(key.Param1, &curve); err != nil || curve != coseCurveP256 {
+			return nil, fmt.Errorf("Неподдерживаемая кривая ключа ES256")
+		}
+		if len(key.Param2) != 32 || len(key.Param3) != 32 {
+			return nil, fmt.Errorf("Некорректные координаты ключа ES256")
+		}
+		// Проверка, что точка лежит на кривой
+		point := append(append([]byte{4}, key.Param2...), key.Param3...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("Некорректный ключ ES256 %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(key.Param2), Y: new(big.Int).SetBytes(key.Param3)}, nil
+
+	case key.Type == coseKeyTypeOKP && key.Algorithm == coseAlgEdDSA:
+		var curve int64
+		if err := cbor.Unmarshal(key.Param1, &curve); err != nil || curve != coseCurveEd25519 {
+			return nil, fmt.Errorf("Неподдерживаемая кривая ключа EdDSA")
+		}
+		if len(key.Param2) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Некорректный ключ Ed25519")
+		}
+		return ed25519.PublicKey(key.Param2), nil
+
+	case key.Type == coseKeyTypeRSA && key.Algorithm == coseAlgRS256:
+		var modulus []byte
+		if err := cbor.Unmarshal(key.Param1, &modulus); err != nil {
+			return nil, fmt.Errorf("Некорректный модуль ключа RS256")
+		}
+		n := new(big.Int).SetBytes(modulus)
+		e := new(big.Int).SetBytes(key.Param2)
+		if n.BitLen() < 2048 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("Недопустимый ключ RS256")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	}
+
+	return nil, fmt.Errorf("Неподдерживаемый алгоритм ключа %d", key.Algorithm)
+}
+
+// Проверка подписи утверждения: подписываются данные аутентификатора и хеш данных клиента
+func verifyAssertionSignature(publicKey []byte, authData, clientDataJSON, signature []byte) error {
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(slices.Clone(authData), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	valid := false
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return fmt.Errorf("Неверная подпись утверждения WebAuthn")
+	}
+
+	return nil
+}
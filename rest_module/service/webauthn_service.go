@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"rest_module/repository"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	. "rest_module/model"
+	. "rest_module/utils"
+)
+
+// Назначения вызовов церемоний WebAuthn
+const (
+	webAuthnPurposeRegistration = "registration"
+	webAuthnPurposeLogin        = "login"
+)
+
+// Сервис ключей доступа WebAuthn (passkeys): регистрация и проверка утверждений
+type WebAuthnManager struct {
+	repository *repository.WebAuthnRepository // репозиторий ключей доступа
+	users      repository.UserStore           // хранилище пользователей
+	rpID       string                         // домен сервиса, к которому привязаны ключи
+	rpName     string                         // имя сервиса в окне браузера
+	origins    []string                       // допустимые источники запросов
+	timeout    time.Duration                  // срок действия вызова
+	dbTimeout  operationTimeout               // ограничение времени операции с БД
+}
+
+// Конструктор сервиса ключей доступа
+func WebAuthnManagerNewInstance(repository *repository.WebAuthnRepository, users repository.UserStore) *WebAuthnManager {
+	manager := WebAuthnManager{}
+	manager.repository = repository
+	manager.users = users
+	manager.rpID = GetEnv("WEBAUTHN_RP_ID", "localhost")
+	manager.rpName = GetEnv("WEBAUTHN_RP_NAME", GetEnv("MFA_ISSUER", "UserManagement"))
+	for _, origin := range strings.Split(GetEnv("WEBAUTHN_ORIGINS", "http://localhost:8080"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			manager.origins = append(manager.origins, origin)
+		}
+	}
+	manager.timeout = GetEnvDuration("WEBAUTHN_TIMEOUT", 5*time.Minute)
+	manager.dbTimeout = operationTimeoutFromEnv()
+	return &manager
+}
+
+// Начало регистрации ключа доступа: параметры для navigator.credentials.create()
+func (manager *WebAuthnManager) BeginRegistration(ctx context.Context, userID int64) (*PublicKeyCredentialCreationOptions, error) {
+	go log.Println("Начало регистрации ключа доступа")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	user, err := manager.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска пользователя %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("Пользователь с таким идентификатором не найден")
+	}
+
+	// Повторная регистрация того же аутентификатора отклоняется браузером
+	exclude, err := manager.descriptors(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := manager.repository.GetOrInsertUserHandle(ctx, userID, randomBytes(webAuthnUserHandleSize))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения идентификатора пользователя WebAuthn %w", err)
+	}
+
+	challenge, err := manager.issueChallenge(ctx, &userID, webAuthnPurposeRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublicKeyCredentialCreationOptions{
+		RP:        WebAuthnRelyingParty{ID: manager.rpID, Name: manager.rpName},
+		User:      WebAuthnUserEntity{ID: handle, Name: user.Username, DisplayName: user.Username},
+		Challenge: challenge,
+		PubKeyCredParams: []WebAuthnCredentialParameter{
+			{Type: "public-key", Alg: coseAlgES256},
+			{Type: "public-key", Alg: coseAlgEdDSA},
+			{Type: "public-key", Alg: coseAlgRS256},
+		},
+		Timeout:                manager.timeout.Milliseconds(),
+		ExcludeCredentials:     exclude,
+		AuthenticatorSelection: WebAuthnAuthenticatorSelection{ResidentKey: "preferred", UserVerification: userVerificationPreferred},
+		Attestation:            "none",
+	}, nil
+}
+
+// Завершение регистрации: проверка ответа аутентификатора и сохранение ключа доступа
+func (manager *WebAuthnManager) FinishRegistration(ctx context.Context, userID int64, name string, attestation *WebAuthnAttestation) (*WebAuthnCredential, error) {
+	go log.Println("Регистрация ключа доступа")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	clientData, err := parseClientData(attestation.Response.ClientDataJSON, "webauthn.create", manager.origins)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, err := manager.consumeChallenge(ctx, clientData.Challenge, webAuthnPurposeRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil || *owner != userID {
+		return nil, fmt.Errorf("Недействительный или просроченный вызов WebAuthn")
+	}
+
+	data, err := parseAttestationObject(attestation.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	if err = data.verify(manager.rpID, false); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(data.credentialID, attestation.RawID) {
+		return nil, fmt.Errorf("Идентификатор ключа не совпадает с данными аутентификатора")
+	}
+	if _, err = parseCOSEKey(data.publicKey); err != nil {
+		return nil, err
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(data.credentialID)
+	existing, err := manager.repository.GetCredential(ctx, credentialID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка поиска ключа доступа %w", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("Ключ доступа уже зарегистрирован")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Ключ доступа"
+	}
+	transports := attestation.Response.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+
+	credential := WebAuthnCredential{UserID: userID, CredentialID: credentialID, Name: truncate(name, 100), PublicKey: data.publicKey,
+		SignCount: data.signCount, Transports: transports, AAGUID: data.aaguidString()}
+	if _, err = manager.repository.InsertCredential(ctx, &credential); err != nil {
+		return nil, fmt.Errorf("Ошибка сохранения ключа доступа %w", err)
+	}
+
+	return &credential, nil
+}
+
+// Ключи доступа пользователя
+func (manager *WebAuthnManager) Credentials(ctx context.Context, userID int64) (*[]WebAuthnCredential, error) {
+	go log.Println("Чтение ключей доступа")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	credentials, err := manager.repository.GetUserCredentials(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения ключей доступа %w", err)
+	}
+
+	return credentials, nil
+}
+
+// Удаление ключа доступа пользователя
+func (manager *WebAuthnManager) RemoveCredential(ctx context.Context, userID, id int64) error {
+	go log.Println("Удаление ключа доступа")
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	removed, err := manager.repository.DeleteCredential(ctx, userID, id)
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления ключа доступа %w", err)
+	}
+	if !removed {
+		return fmt.Errorf("Ключ доступа не найден")
+	}
+
+	return nil
+}
+
+// Начало входа по ключу доступа: параметры для navigator.credentials.get().
+// userID равен 0 для входа без логина: браузер предложит ключи, сохраненные на устройстве
+func (manager *WebAuthnManager) BeginLogin(ctx context.Context, userID int64, userVerification string) (*PublicKeyCredentialRequestOptions, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	var owner *int64
+	allow := []WebAuthnCredentialDescriptor{}
+	if userID != 0 {
+		descriptors, err := manager.descriptors(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(descriptors) == 0 {
+			return nil, ErrInvalidPasskey
+		}
+		owner, allow = &userID, descriptors
+	}
+
+	challenge, err := manager.issueChallenge(ctx, owner, webAuthnPurposeLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublicKeyCredentialRequestOptions{Challenge: challenge, Timeout: manager.timeout.Milliseconds(), RPID: manager.rpID,
+		AllowCredentials: allow, UserVerification: userVerification}, nil
+}
+
+// Проверка утверждения аутентификатора; возвращает владельца ключа.
+// userID - пользователь, для которого начат вход, или 0 для входа без логина
+func (manager *WebAuthnManager) FinishLogin(ctx context.Context, userID int64, assertion *WebAuthnAssertion, requireUserVerification bool) (int64, error) {
+	ctx, cancel := manager.dbTimeout.apply(ctx)
+	defer cancel()
+	clientData, err := parseClientData(assertion.Response.ClientDataJSON, "webauthn.get", manager.origins)
+	if err != nil {
+		log.Warnf("Утверждение WebAuthn отклонено: %v", err)
+		return 0, ErrInvalidPasskey
+	}
+
+	owner, err := manager.consumeChallenge(ctx, clientData.Challenge, webAuthnPurposeLogin)
+	if err != nil {
+		return 0, err
+	}
+	if (userID == 0) != (owner == nil) || (owner != nil && *owner != userID) {
+		return 0, ErrInvalidPasskey
+	}
+
+	credential, err := manager.repository.GetCredential(ctx, base64.RawURLEncoding.EncodeToString(assertion.RawID))
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка поиска ключа доступа %w", err)
+	}
+	if credential == nil || (userID != 0 && credential.UserID != userID) {
+		return 0, ErrInvalidPasskey
+	}
+	// Идентификатор пользователя из аутентификатора должен принадлежать владельцу ключа
+	if len(assertion.Response.UserHandle) != 0 {
+		handleOwner, err := manager.repository.GetUserIDByHandle(ctx, assertion.Response.UserHandle)
+		if err != nil {
+			return 0, fmt.Errorf("Ошибка поиска пользователя WebAuthn %w", err)
+		}
+		if handleOwner != credential.UserID {
+			return 0, ErrInvalidPasskey
+		}
+	}
+
+	data, err := parseAuthenticatorData(assertion.Response.AuthenticatorData)
+	if err == nil {
+		err = data.verify(manager.rpID, requireUserVerification)
+	}
+	if err == nil {
+		err = verifyAssertionSignature(credential.PublicKey, assertion.Response.AuthenticatorData, assertion.Response.ClientDataJSON, assertion.Response.Signature)
+	}
+	if err != nil {
+		log.Warnf("Утверждение ключа доступа %d отклонено: %v", credential.ID, err)
+		return 0, ErrInvalidPasskey
+	}
+
+	// Счетчик, не выросший с прошлого входа, означает копию ключа; аутентификаторы без счетчика всегда передают 0
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		log.Warnf("Счетчик подписей ключа доступа %d не вырос: возможна копия ключа", credential.ID)
+		return 0, ErrInvalidPasskey
+	}
+
+	updated, err := manager.repository.UpdateSignCount(ctx, credential.ID, credential.SignCount, data.signCount)
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка сохранения счетчика подписей %w", err)
+	}
+	if !updated {
+		return 0, ErrInvalidPasskey
+	}
+
+	return credential.UserID, nil
+}
+
+// Ссылки на ключи доступа пользователя
+func (manager *WebAuthnManager) descriptors(ctx context.Context, userID int64) ([]WebAuthnCredentialDescriptor, error) {
+	credentials, err := manager.repository.GetUserCredentials(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения ключей доступа %w", err)
+	}
+
+	descriptors := []WebAuthnCredentialDescriptor{}
+	for _, credential := range *credentials {
+		descriptors = append(descriptors, WebAuthnCredentialDescriptor{Type: "public-key", ID: credential.CredentialID, Transports: credential.Transports})
+	}
+
+	return descriptors, nil
+}
+
+// Выпуск одноразового вызова; хранится только его хеш
+func (manager *WebAuthnManager) issueChallenge(ctx context.Context, userID *int64, purpose string) (string, error) {
+	challenge := randomToken(32)
+	if err := manager.repository.InsertChallenge(ctx, userID, purpose, hashToken(challenge), time.Now().Add(manager.timeout)); err != nil {
+		return "", fmt.Errorf("Ошибка сохранения вызова WebAuthn %w", err)
+	}
+
+	return challenge, nil
+}
+
+// Погашение вызова из данных клиента; возвращает пользователя, для которого вызов выпущен
+func (manager *WebAuthnManager) consumeChallenge(ctx context.Context, challenge, purpose string) (*int64, error) {
+	owner, found, err := manager.repository.ConsumeChallenge(ctx, purpose, hashToken(challenge))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка проверки вызова WebAuthn %w", err)
+	}
+	if !found {
+		return nil, ErrInvalidPasskey
+	}
+
+	return owner, nil
+}
@@ -0,0 +1,378 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"maps"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// Пространства имен и алгоритмы XML Signature
+const (
+	xmlNamespace            = "http://www.w3.org/XML/1998/namespace"
+	xmlDSigNamespace        = "http://www.w3.org/2000/09/xmldsig#"
+	xmlExcC14N              = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	xmlEnvelopedSignature   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	xmlDigestSHA256         = "http://www.w3.org/2001/04/xmlenc#sha256"
+	xmlDigestSHA512         = "http://www.w3.org/2001/04/xmlenc#sha512"
+	xmlSignatureRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	xmlSignatureRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	xmlSignatureECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+)
+
+// Проверка вложенной подписи (enveloped signature) элемента сертификатами certs.
+// Возвращает false без ошибки, если элемент не подписан. Подпись должна ссылаться
+// на сам элемент по его атрибуту ID, ключ из KeyInfo не используется.
+func verifyXMLSignature(el *etree.Element, certs []*x509.Certificate) (bool, error) {
+	signatures := childrenNS(el, xmlDSigNamespace, "Signature")
+	if len(signatures) == 0 {
+		return false, nil
+	}
+	if len(signatures) > 1 {
+		return false, fmt.Errorf("Элемент %s содержит несколько подписей", el.Tag)
+	}
+	signature := signatures[0]
+
+	signedInfo := childNS(signature, xmlDSigNamespace, "SignedInfo")
+	if signedInfo == nil {
+		return false, fmt.Errorf("Подпись не содержит SignedInfo")
+	}
+
+	c14nMethod := childNS(signedInfo, xmlDSigNamespace, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.SelectAttrValue("Algorithm", "") != xmlExcC14N {
+		return false, fmt.Errorf("Неподдерживаемый алгоритм каноникализации подписи")
+	}
+
+	signatureMethod := childNS(signedInfo, xmlDSigNamespace, "SignatureMethod")
+	if signatureMethod == nil {
+		return false, fmt.Errorf("Подпись не содержит SignatureMethod")
+	}
+
+	references := childrenNS(signedInfo, xmlDSigNamespace, "Reference")
+	if len(references) != 1 {
+		return false, fmt.Errorf("Подпись должна содержать ровно одну ссылку")
+	}
+	reference := references[0]
+
+	// Ссылка только на подписанный элемент защищает от подмены (XML signature wrapping)
+	id := el.SelectAttrValue("ID", "")
+	if id == "" || reference.SelectAttrValue("URI", "") != "#"+id {
+		return false, fmt.Errorf("Подпись не относится к элементу %s", el.Tag)
+	}
+
+	enveloped, exclusive := false, false
+	var referencePrefixes []string
+	if transforms := childNS(reference, xmlDSigNamespace, "Transforms"); transforms != nil {
+		for _, transform := range childrenNS(transforms, xmlDSigNamespace, "Transform") {
+			switch transform.SelectAttrValue("Algorithm", "") {
+			case xmlEnvelopedSignature:
+				enveloped = true
+			case xmlExcC14N:
+				exclusive = true
+				referencePrefixes = inclusivePrefixes(transform)
+			default:
+				return false, fmt.Errorf("Неподдерживаемое преобразование подписи")
+			}
+		}
+	}
+	if !enveloped || !exclusive {
+		return false, fmt.Errorf("Подпись должна использовать преобразования enveloped-signature и exc-c14n")
+	}
+
+	digestMethod := childNS(reference, xmlDSigNamespace, "DigestMethod")
+	if digestMethod == nil {
+		return false, fmt.Errorf("Подпись не содержит DigestMethod")
+	}
+	digester, err := xmlDigest(digestMethod.SelectAttrValue("Algorithm", ""))
+	if err != nil {
+		return false, err
+	}
+
+	expectedDigest, err := xmlBase64(childNS(reference, xmlDSigNamespace, "DigestValue"))
+	if err != nil {
+		return false, err
+	}
+
+	digester.Write(canonicalize(el, signature, referencePrefixes))
+	if subtle.ConstantTimeCompare(digester.Sum(nil), expectedDigest) != 1 {
+		return false, fmt.Errorf("Хеш подписанного элемента не совпадает")
+	}
+
+	value, err := xmlBase64(childNS(signature, xmlDSigNamespace, "SignatureValue"))
+	if err != nil {
+		return false, err
+	}
+
+	canonical := canonicalize(signedInfo, nil, inclusivePrefixes(c14nMethod))
+	algorithm := signatureMethod.SelectAttrValue("Algorithm", "")
+	for _, cert := range certs {
+		if verifyXMLSignatureValue(cert.PublicKey, algorithm, canonical, value) == nil {
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("Подпись не прошла проверку сертификатом IdP")
+}
+
+func verifyXMLSignatureValue(key crypto.PublicKey, algorithm string, signed, signature []byte) error {
+	var digest []byte
+	var hashType crypto.Hash
+	switch algorithm {
+	case xmlSignatureRSASHA256, xmlSignatureECDSASHA256:
+		sum := sha256.Sum256(signed)
+		digest, hashType = sum[:], crypto.SHA256
+	case xmlSignatureRSASHA512:
+		sum := sha512.Sum512(signed)
+		digest, hashType = sum[:], crypto.SHA512
+	default:
+		return fmt.Errorf("Неподдерживаемый алгоритм подписи %s", algorithm)
+	}
+
+	switch public := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm == xmlSignatureECDSASHA256 {
+			return fmt.Errorf("Алгоритм подписи не соответствует ключу")
+		}
+		return rsa.VerifyPKCS1v15(public, hashType, digest, signature)
+	case *ecdsa.PublicKey:
+		if algorithm != xmlSignatureECDSASHA256 {
+			return fmt.Errorf("Алгоритм подписи не соответствует ключу")
+		}
+		// По RFC 4051 значение записывается как r || s, часть реализаций использует DER
+		if size := len(signature) / 2; size > 0 && len(signature)%2 == 0 {
+			r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(public, digest, r, s) {
+				return nil
+			}
+		}
+		if ecdsa.VerifyASN1(public, digest, signature) {
+			return nil
+		}
+		return fmt.Errorf("Неверная подпись")
+	}
+
+	return fmt.Errorf("Неподдерживаемый тип ключа сертификата")
+}
+
+func xmlDigest(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case xmlDigestSHA256:
+		return sha256.New(), nil
+	case xmlDigestSHA512:
+		return sha512.New(), nil
+	}
+
+	return nil, fmt.Errorf("Неподдерживаемый алгоритм хеширования %s", algorithm)
+}
+
+// Значение в base64; переносы строк и пробелы внутри значения допустимы
+func xmlBase64(el *etree.Element) ([]byte, error) {
+	if el == nil {
+		return nil, fmt.Errorf("Отсутствует значение подписи")
+	}
+
+	value, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(el.Text()), ""))
+	if err != nil {
+		return nil, fmt.Errorf("Некорректное значение подписи %w", err)
+	}
+
+	return value, nil
+}
+
+// Префиксы из InclusiveNamespaces PrefixList; #default обозначает пространство имен по умолчанию
+func inclusivePrefixes(method *etree.Element) []string {
+	list := childNS(method, xmlExcC14N, "InclusiveNamespaces")
+	if list == nil {
+		return nil
+	}
+
+	prefixes := strings.Fields(list.SelectAttrValue("PrefixList", ""))
+	for i, prefix := range prefixes {
+		if prefix == "#default" {
+			prefixes[i] = ""
+		}
+	}
+	return prefixes
+}
+
+// Дочерний элемент с заданным пространством имен и локальным именем
+func childNS(el *etree.Element, namespace, tag string) *etree.Element {
+	children := childrenNS(el, namespace, tag)
+	if len(children) == 0 {
+		return nil
+	}
+	return children[0]
+}
+
+func childrenNS(el *etree.Element, namespace, tag string) []*etree.Element {
+	result := []*etree.Element{}
+	for _, child := range el.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == namespace {
+			result = append(result, child)
+		}
+	}
+	return result
+}
+
+// Exclusive XML Canonicalization 1.0 без комментариев (https://www.w3.org/TR/xml-exc-c14n/).
+// Элемент skip пропускается, что соответствует преобразованию enveloped-signature.
+func canonicalize(el *etree.Element, skip *etree.Element, inclusive []string) []byte {
+	canonicalizer := exclusiveCanonicalizer{skip: skip, inclusive: inclusive}
+	scope := map[string]string{}
+	ancestors := []*etree.Element{}
+	for parent := el.Parent(); parent != nil; parent = parent.Parent() {
+		ancestors = append(ancestors, parent)
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		scope = declareNamespaces(scope, ancestors[i])
+	}
+
+	canonicalizer.element(el, scope, map[string]string{})
+	return canonicalizer.buf.Bytes()
+}
+
+type exclusiveCanonicalizer struct {
+	buf       bytes.Buffer
+	skip      *etree.Element
+	inclusive []string
+}
+
+// scope - объявленные пространства имен, rendered - выведенные в каноническую форму предками
+func (c *exclusiveCanonicalizer) element(el *etree.Element, scope, rendered map[string]string) {
+	scope = declareNamespaces(scope, el)
+
+	// Выводятся только видимо используемые префиксы и префиксы из InclusiveNamespaces
+	prefixes := []string{el.Space}
+	attrs := []etree.Attr{}
+	for _, attr := range el.Attr {
+		if isNamespaceDeclaration(attr) {
+			continue
+		}
+		attrs = append(attrs, attr)
+		if attr.Space != "" && attr.Space != "xml" {
+			prefixes = append(prefixes, attr.Space)
+		}
+	}
+	for _, prefix := range c.inclusive {
+		if _, ok := scope[prefix]; ok {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	slices.Sort(prefixes)
+	prefixes = slices.Compact(prefixes)
+
+	output := rendered
+	declarations := []string{}
+	for _, prefix := range prefixes {
+		uri := scope[prefix]
+		// Префикс уже выведен предком с тем же значением либо не связан с пространством имен
+		if prefix == "xml" || (prefix != "" && uri == "") || uri == rendered[prefix] {
+			continue
+		}
+
+		if len(declarations) == 0 {
+			output = maps.Clone(rendered)
+		}
+		output[prefix] = uri
+		declarations = append(declarations, prefix)
+	}
+
+	slices.SortFunc(attrs, func(a, b etree.Attr) int {
+		if order := strings.Compare(attributeNamespace(a, scope), attributeNamespace(b, scope)); order != 0 {
+			return order
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	c.buf.WriteString("<" + el.FullTag())
+	for _, prefix := range declarations {
+		if prefix == "" {
+			c.buf.WriteString(` xmlns="`)
+		} else {
+			c.buf.WriteString(` xmlns:` + prefix + `="`)
+		}
+		c.buf.WriteString(escapeCanonicalAttr(output[prefix]) + `"`)
+	}
+	for _, attr := range attrs {
+		c.buf.WriteString(" " + attr.FullKey() + `="` + escapeCanonicalAttr(attr.Value) + `"`)
+	}
+	c.buf.WriteString(">")
+
+	for _, token := range el.Child {
+		switch child := token.(type) {
+		case *etree.Element:
+			if child != c.skip {
+				c.element(child, scope, output)
+			}
+		case *etree.CharData:
+			c.buf.WriteString(escapeCanonicalText(child.Data))
+		case *etree.ProcInst:
+			c.buf.WriteString("<?" + child.Target)
+			if child.Inst != "" {
+				c.buf.WriteString(" " + child.Inst)
+			}
+			c.buf.WriteString("?>")
+		}
+	}
+
+	c.buf.WriteString("</" + el.FullTag() + ">")
+}
+
+// Область видимости с учетом объявлений элемента; исходная карта не изменяется
+func declareNamespaces(scope map[string]string, el *etree.Element) map[string]string {
+	result, copied := scope, false
+	for _, attr := range el.Attr {
+		if !isNamespaceDeclaration(attr) {
+			continue
+		}
+		if !copied {
+			result, copied = maps.Clone(scope), true
+		}
+		if attr.Space == "xmlns" {
+			result[attr.Key] = attr.Value
+		} else {
+			result[""] = attr.Value
+		}
+	}
+	return result
+}
+
+func isNamespaceDeclaration(attr etree.Attr) bool {
+	return attr.Space == "xmlns" || (attr.Space == "" && attr.Key == "xmlns")
+}
+
+func attributeNamespace(attr etree.Attr, scope map[string]string) string {
+	switch attr.Space {
+	case "":
+		return ""
+	case "xml":
+		return xmlNamespace
+	}
+	return scope[attr.Space]
+}
+
+var canonicalTextReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var canonicalAttrReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;",
+	"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+func escapeCanonicalText(value string) string {
+	return canonicalTextReplacer.Replace(value)
+}
+
+func escapeCanonicalAttr(value string) string {
+	return canonicalAttrReplacer.Replace(value)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+func TestVerifyXMLSignature(t *testing.T) {
+	signer := newSAMLSigner(t)
+
+	tests := []struct {
+		name       string
+		build      func(t *testing.T, assertion *etree.Element)
+		certs      []*x509.Certificate
+		wantSigned bool
+		wantErr    string
+	}{
+		{
+			name:       "действующая подпись",
+			build:      func(t *testing.T, assertion *etree.Element) { signer.sign(t, assertion) },
+			wantSigned: true,
+		},
+		{
+			name:  "элемент без подписи",
+			build: func(t *testing.T, assertion *etree.Element) {},
+		},
+		{
+			name: "две подписи",
+			build: func(t *testing.T, assertion *etree.Element) {
+				signature := signer.sign(t, assertion)
+				assertion.AddChild(signature.Copy())
+			},
+			wantErr: "несколько подписей",
+		},
+		{
+			name: "две ссылки в подписи",
+			build: func(t *testing.T, assertion *etree.Element) {
+				signedInfo := signer.sign(t, assertion).FindElement("ds:SignedInfo")
+				signedInfo.AddChild(signedInfo.FindElement("ds:Reference").Copy())
+			},
+			wantErr: "ровно одну ссылку",
+		},
+		{
+			name: "пустая ссылка на весь документ",
+			build: func(t *testing.T, assertion *etree.Element) {
+				signer.sign(t, assertion).FindElement("ds:SignedInfo/ds:Reference").CreateAttr("URI", "")
+			},
+			wantErr: "Подпись не относится к элементу",
+		},
+		{
+			name: "без преобразования enveloped-signature",
+			build: func(t *testing.T, assertion *etree.Element) {
+				transforms := signer.sign(t, assertion).FindElement("ds:SignedInfo/ds:Reference/ds:Transforms")
+				transforms.RemoveChild(transforms.FindElement("ds:Transform"))
+			},
+			wantErr: "enveloped-signature и exc-c14n",
+		},
+		{
+			name: "хеш SHA-1",
+			build: func(t *testing.T, assertion *etree.Element) {
+				method := signer.sign(t, assertion).FindElement("ds:SignedInfo/ds:Reference/ds:DigestMethod")
+				method.CreateAttr("Algorithm", "http://www.w3.org/2000/09/xmldsig#sha1")
+			},
+			wantErr: "Неподдерживаемый алгоритм хеширования",
+		},
+		{
+			name:    "сертификат другого IdP",
+			build:   func(t *testing.T, assertion *etree.Element) { signer.sign(t, assertion) },
+			certs:   []*x509.Certificate{newSAMLSigner(t).cert},
+			wantErr: "Подпись не прошла проверку",
+		},
+		{
+			name: "измененный атрибут подписанного элемента",
+			build: func(t *testing.T, assertion *etree.Element) {
+				signer.sign(t, assertion)
+				assertion.CreateAttr("IssueInstant", "2000-01-01T00:00:00Z")
+			},
+			wantErr: "Хеш подписанного элемента не совпадает",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, _, assertion := newSAMLResponse(time.Now())
+			test.build(t, assertion)
+
+			// Проверяется элемент из разобранного документа, как при приеме ответа
+			raw, err := doc.WriteToBytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed := etree.NewDocument()
+			if err = parsed.ReadFromBytes(raw); err != nil {
+				t.Fatal(err)
+			}
+
+			certs := test.certs
+			if certs == nil {
+				certs = []*x509.Certificate{signer.cert}
+			}
+			signed, err := verifyXMLSignature(parsed.FindElement("/Response/Assertion"), certs)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Ошибка %v, ожидалась %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signed != test.wantSigned {
+				t.Errorf("Подписан %v, ожидалось %v", signed, test.wantSigned)
+			}
+		})
+	}
+}
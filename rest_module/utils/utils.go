@@ -1,6 +1,10 @@
 package utils
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"time"
+)
 
 func GetEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -9,3 +13,21 @@ func GetEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// Целочисленный параметр из переменной окружения
+func GetEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Длительность из переменной окружения в формате time.ParseDuration (15m, 24h)
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}